@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// errNotInArchive is returned by archives for the blocks they do not have
+var errNotInArchive = errors.New("not in archive")
+
+// BlockArchive is a local store of historical blocks, like era files.
+// It returns errNotInArchive for the blocks it does not have.
+type BlockArchive interface {
+	BlockByNumber(ctx context.Context, num uint64) (*types.Block, error)
+}
+
+// ArchiveBlockSource reads blocks from the first archive that has them, and falls back to another source for
+// the blocks that are in none of the archives, like the latest blocks.
+type ArchiveBlockSource struct {
+	archives []BlockArchive
+	fallback BlockSource
+}
+
+func NewArchiveBlockSource(fallback BlockSource, archives ...BlockArchive) *ArchiveBlockSource {
+	return &ArchiveBlockSource{archives: archives, fallback: fallback}
+}
+
+func (s *ArchiveBlockSource) LatestNumber(ctx context.Context) (uint64, error) {
+	return s.fallback.LatestNumber(ctx)
+}
+
+func (s *ArchiveBlockSource) BlockByNumber(ctx context.Context, num uint64) (*types.Block, error) {
+	for _, a := range s.archives {
+		bl, err := a.BlockByNumber(ctx, num)
+		if !errors.Is(err, errNotInArchive) {
+			return bl, err
+		}
+	}
+	return s.fallback.BlockByNumber(ctx, num)
+}
+
+// ArchiveReceiptsSource reads receipts from the first archive that has them, and falls back to another source.
+// The archives return errNotInArchive for the blocks they do not have.
+type ArchiveReceiptsSource struct {
+	archives []ReceiptsSource
+	fallback ReceiptsSource
+}
+
+func NewArchiveReceiptsSource(fallback ReceiptsSource, archives ...ReceiptsSource) *ArchiveReceiptsSource {
+	return &ArchiveReceiptsSource{archives: archives, fallback: fallback}
+}
+
+func (s *ArchiveReceiptsSource) FetchReceipts(ctx context.Context, bl *types.Block) (types.Receipts, error) {
+	for _, a := range s.archives {
+		receipts, err := a.FetchReceipts(ctx, bl)
+		if !errors.Is(err, errNotInArchive) {
+			return receipts, err
+		}
+	}
+	return s.fallback.FetchReceipts(ctx, bl)
+}
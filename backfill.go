@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"sync"
+	"time"
+)
+
+const (
+	// number of blocks that are fetched together, before being handed to the pipeline in order
+	backfillBatchSize = 50
+	// how long to pause after catching up with the head, before checking the range again
+	backfillInterval = time.Minute
+)
+
+// Backfiller walks the canonical chain from the first block at or after MinTime up to the head,
+// and hands every block that has not been written yet, or that changed since, to the pipeline.
+type Backfiller struct {
+	log     log.Logger
+	src     BlockSource
+	db      *ChainDB
+	minTime uint64
+
+	out chan<- *types.Block
+}
+
+func NewBackfiller(log log.Logger, src BlockSource, db *ChainDB, minTime uint64, out chan<- *types.Block) *Backfiller {
+	return &Backfiller{
+		log:     log,
+		src:     src,
+		db:      db,
+		minTime: minTime,
+		out:     out,
+	}
+}
+
+// Run repeatedly backfills the chain until the context is canceled.
+func (b *Backfiller) Run(ctx context.Context) error {
+	for {
+		if err := b.backfill(ctx); err != nil {
+			b.log.Warn("failed to backfill", "err", err)
+		}
+		select {
+		case <-time.After(backfillInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// FindStart finds the number of the first block with a timestamp at or after minTime,
+// with a binary search over the blocks up to and including latest.
+func FindStart(ctx context.Context, src BlockSource, minTime uint64, latest uint64) (uint64, error) {
+	lo, hi := uint64(0), latest+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		bl, err := src.BlockByNumber(ctx, mid)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get block %d: %w", mid, err)
+		}
+		if bl.Time() < minTime {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+func (b *Backfiller) backfill(ctx context.Context) error {
+	latest, err := b.src.LatestNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get latest block number: %w", err)
+	}
+	start, err := FindStart(ctx, b.src, b.minTime, latest)
+	if err != nil {
+		return fmt.Errorf("failed to find first block after min time %d: %w", b.minTime, err)
+	}
+	b.log.Info("backfilling", "start", start, "latest", latest)
+	// finalized blocks don't change anymore, those we do not need to fetch to compare
+	finalized := func(num uint64) bool {
+		rec, ok := b.db.Get(num)
+		return ok && rec.Status == BlockFinalized
+	}
+	written := func(bl *types.Block) bool {
+		rec, ok := b.db.Get(bl.NumberU64())
+		if !ok {
+			return false
+		}
+		if rec.Written(bl.Hash()) {
+			return true
+		}
+		b.log.Warn("re-processing changed block", "number", bl.NumberU64(), "prev", rec.Hash, "hash", bl.Hash())
+		return false
+	}
+	if err := fetchRange(ctx, b.src, start, latest+1, b.out, finalized, written); err != nil {
+		return err
+	}
+	b.log.Info("completed backfill", "start", start, "latest", latest)
+	return nil
+}
+
+// fetchRange fetches the blocks in [start, end) in batches, and hands them to the output in order.
+// Numbers for which skipNum returns true are not fetched, and fetched blocks for which skip returns true are not handed over.
+// Either function may be nil.
+func fetchRange(ctx context.Context, src BlockSource, start, end uint64, out chan<- *types.Block,
+	skipNum func(num uint64) bool, skip func(bl *types.Block) bool) error {
+	for num := start; num < end; num += backfillBatchSize {
+		batchEnd := num + backfillBatchSize
+		if batchEnd > end {
+			batchEnd = end
+		}
+		var nums []uint64
+		for n := num; n < batchEnd; n++ {
+			if skipNum == nil || !skipNum(n) {
+				nums = append(nums, n)
+			}
+		}
+		blocks, err := fetchBlocks(ctx, src, nums)
+		if err != nil {
+			return fmt.Errorf("failed to fetch blocks [%d, %d): %w", num, batchEnd, err)
+		}
+		for _, bl := range blocks {
+			if skip != nil && skip(bl) {
+				continue
+			}
+			select {
+			case out <- bl:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// fetchBlocks concurrently fetches the blocks with the given numbers
+func fetchBlocks(ctx context.Context, src BlockSource, nums []uint64) ([]*types.Block, error) {
+	blocks := make([]*types.Block, len(nums))
+	errs := make([]error, len(nums))
+	var wg sync.WaitGroup
+	for i := range blocks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			blocks[i], errs[i] = src.BlockByNumber(ctx, nums[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block %d: %w", nums[i], err)
+		}
+	}
+	return blocks, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"math/big"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// memBlockSource serves a chain of blocks from memory, and records which blocks were fetched
+type memBlockSource struct {
+	blocks []*types.Block
+
+	mu      sync.Mutex
+	fetched map[uint64]bool
+}
+
+func newMemBlockSource(n int) *memBlockSource {
+	s := &memBlockSource{fetched: make(map[uint64]bool)}
+	for i := 0; i < n; i++ {
+		s.blocks = append(s.blocks, types.NewBlockWithHeader(&types.Header{Number: big.NewInt(int64(i)), Time: 1_700_000_000 + uint64(i)*12}))
+	}
+	return s
+}
+
+func (s *memBlockSource) LatestNumber(ctx context.Context) (uint64, error) {
+	return uint64(len(s.blocks) - 1), nil
+}
+
+func (s *memBlockSource) BlockByNumber(ctx context.Context, num uint64) (*types.Block, error) {
+	if num >= uint64(len(s.blocks)) {
+		return nil, ethereum.NotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetched[num] = true
+	return s.blocks[num], nil
+}
+
+func TestBackfill(t *testing.T) {
+	src := newMemBlockSource(120)
+	db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// blocks [40, 50) are finalized, 70 is exported, and 72 was exported with a different hash before a reorg
+	for n := uint64(40); n < 50; n++ {
+		if err := db.PutExported(n, src.blocks[n].Hash(), src.blocks[n].Time()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Prune(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutExported(70, src.blocks[70].Hash(), src.blocks[70].Time()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutExported(72, common.Hash{1}, src.blocks[72].Time()); err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan *types.Block, len(src.blocks))
+	// starts at block 10
+	b := NewBackfiller(log.NewLogger(log.DiscardHandler()), src, db, src.blocks[10].Time(), out)
+	if err := b.backfill(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	var got []uint64
+	for bl := range out {
+		got = append(got, bl.NumberU64())
+	}
+	want := append(append(numbers(10, 40), numbers(50, 70)...), numbers(71, 120)...)
+	if !slices.Equal(got, want) {
+		t.Errorf("got blocks %v, want %v", got, want)
+	}
+	for n := uint64(40); n < 50; n++ {
+		if src.fetched[n] {
+			t.Errorf("fetched finalized block %d", n)
+		}
+	}
+}
+
+// numbers returns the numbers in [start, end)
+func numbers(start, end uint64) []uint64 {
+	var out []uint64
+	for n := start; n < end; n++ {
+		out = append(out, n)
+	}
+	return out
+}
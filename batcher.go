@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/ethereum-optimism/optimism/op-node/params"
+	"github.com/ethereum-optimism/optimism/op-node/rollup"
+	"github.com/ethereum-optimism/optimism/op-node/rollup/derive"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"io"
+)
+
+const (
+	// number of L1 blocks after which an incomplete channel is dropped, the channel timeout of the Bedrock derivation
+	// of the standard rollup config
+	bedrockChannelTimeout = 300
+	// maximum decompressed size of a channel
+	maxChannelBytes = 100_000_000
+)
+
+// channelTimeout returns the number of L1 blocks after which an incomplete channel of the inbox is dropped,
+// at the given L1 time: from the rollup config of the chain if it is configured, or else the Granite activation
+// of the inbox registry. Granite shortened the channel timeout.
+func channelTimeout(in *Inbox, time uint64) uint64 {
+	if in.RollupConfig != nil {
+		return rollup.NewChainSpec(in.RollupConfig).ChannelTimeout(time)
+	}
+	if in.GraniteTime != nil && time >= *in.GraniteTime {
+		return params.ChannelTimeoutGranite
+	}
+	return bedrockChannelTimeout
+}
+
+// batch types in a decompressed channel
+const (
+	singularBatchType = 0
+	spanBatchType     = 1
+)
+
+var errChannelCompression = errors.New("unsupported channel compression")
+
+// decodeChannel decompresses the data of a channel, and counts the batches in it.
+// Channels are zlib compressed, or since Fjord brotli compressed, prefixed with the brotli version byte.
+func decodeChannel(data []byte) (uncompressed int, singular int, span int, err error) {
+	var r io.Reader
+	switch {
+	case len(data) == 0:
+		return 0, 0, 0, errChannelCompression
+	case data[0]&0x0f == derive.ZlibCM8, data[0]&0x0f == derive.ZlibCM15: // the zlib compression method
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		defer zr.Close()
+		r = zr
+	case data[0] == derive.ChannelVersionBrotli:
+		r = brotli.NewReader(bytes.NewReader(data[1:]))
+	default:
+		return 0, 0, 0, errChannelCompression
+	}
+	out, err := io.ReadAll(io.LimitReader(r, maxChannelBytes+1))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to decompress channel: %w", err)
+	}
+	if len(out) > maxChannelBytes {
+		return 0, 0, 0, errors.New("channel is too large")
+	}
+	for rest := out; len(rest) > 0; {
+		var batch []byte
+		batch, rest, err = rlp.SplitString(rest)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("failed to read batch: %w", err)
+		}
+		if len(batch) == 0 {
+			return 0, 0, 0, errors.New("empty batch")
+		}
+		switch batch[0] {
+		case singularBatchType:
+			singular++
+		case spanBatchType:
+			span++
+		default:
+			return 0, 0, 0, fmt.Errorf("unknown batch type %d", batch[0])
+		}
+	}
+	return len(out), singular, span, nil
+}
+
+// channelState is a channel that is being received.
+type channelState struct {
+	// L1 block number of the first received frame
+	open   uint64
+	frames map[uint16][]byte
+	// number of the last frame, -1 until the last frame is received
+	last int
+}
+
+// channelTracker reassembles the channels of one batch inbox from the frames, in L1 order.
+type channelTracker struct {
+	channels map[derive.ChannelID]*channelState
+}
+
+// batcherStats are the batcher metrics of one inbox in one L1 block.
+type batcherStats struct {
+	frames                   float64
+	closed, duration         float64
+	compressed, uncompressed float64
+	singular, span           float64
+	undecoded, dropped       float64
+}
+
+// addFrame adds the frame, and closes the channel if the frame completes it.
+func (t *channelTracker) addFrame(num uint64, f *derive.Frame, stats *batcherStats) {
+	stats.frames += 1
+	ch, ok := t.channels[f.ID]
+	if !ok {
+		ch = &channelState{open: num, frames: make(map[uint16][]byte), last: -1}
+		t.channels[f.ID] = ch
+	}
+	if _, ok := ch.frames[f.FrameNumber]; ok {
+		return // duplicate frame
+	}
+	if ch.last >= 0 && int(f.FrameNumber) > ch.last {
+		return // frame past the end of the channel
+	}
+	ch.frames[f.FrameNumber] = f.Data
+	if f.IsLast {
+		ch.last = int(f.FrameNumber)
+		for n := range ch.frames {
+			if int(n) > ch.last {
+				delete(ch.frames, n)
+			}
+		}
+	}
+	if ch.last < 0 || len(ch.frames) != ch.last+1 {
+		return
+	}
+	delete(t.channels, f.ID)
+	var data []byte
+	for n := 0; n <= ch.last; n++ {
+		data = append(data, ch.frames[uint16(n)]...)
+	}
+	stats.closed += 1
+	stats.duration += float64(num - ch.open)
+	uncompressed, singular, span, err := decodeChannel(data)
+	if err != nil {
+		stats.undecoded += 1
+		return
+	}
+	stats.compressed += float64(len(data))
+	stats.uncompressed += float64(uncompressed)
+	stats.singular += float64(singular)
+	stats.span += float64(span)
+}
+
+// timeout drops the channels that are not complete within the channel timeout.
+func (t *channelTracker) timeout(num uint64, channelTimeout uint64, stats *batcherStats) {
+	for id, ch := range t.channels {
+		if num-ch.open > channelTimeout {
+			delete(t.channels, id)
+			stats.dropped += 1
+		}
+	}
+}
+
+// MakeBatcherStats decodes the frames of the OP Stack batcher transactions, from calldata and blobs,
+// and reassembles them into channels, per inbox. The blocks must be processed in order, without gaps:
+// channels that started before the first processed block never complete, and are counted as dropped.
+// Transactions from unauthorized senders are only counted, over all inboxes.
+func MakeBatcherStats(inboxes *Inboxes) AggregateMetric[*BlobBlock] {
+	inboxes = inboxes.OPStack()
+	trackers := make([]channelTracker, len(inboxes.List))
+	for i := range trackers {
+		trackers[i].channels = make(map[derive.ChannelID]*channelState)
+	}
+	metrics := []struct {
+		name string
+		fn   func(s *batcherStats) float64
+	}{
+		{"batcher_frames", func(s *batcherStats) float64 { return s.frames }},
+		{"batcher_channels_closed", func(s *batcherStats) float64 { return s.closed }},
+		{"batcher_channel_blocks", func(s *batcherStats) float64 { return s.duration }},
+		{"batcher_channel_compressed_bytes", func(s *batcherStats) float64 { return s.compressed }},
+		{"batcher_channel_uncompressed_bytes", func(s *batcherStats) float64 { return s.uncompressed }},
+		{"batcher_singular_batches", func(s *batcherStats) float64 { return s.singular }},
+		{"batcher_span_batches", func(s *batcherStats) float64 { return s.span }},
+		{"batcher_channels_undecoded", func(s *batcherStats) float64 { return s.undecoded }},
+		{"batcher_channels_dropped", func(s *batcherStats) float64 { return s.dropped }},
+	}
+	names := []string{"batcher_unauthorized_txs"}
+	labels := [][]Label{nil}
+	for _, m := range metrics {
+		for _, in := range inboxes.List {
+			names = append(names, m.name)
+			labels = append(labels, []Label{{Key: "inbox", Value: in.Name}})
+		}
+	}
+	stats := make([]batcherStats, len(inboxes.List))
+	return AggregateMetric[*BlobBlock]{
+		Names:  names,
+		Labels: labels,
+		Fn: func(bl *BlobBlock, dest []float64) error {
+			num := bl.NumberU64()
+			for i := range stats {
+				stats[i] = batcherStats{}
+			}
+			unauthorized := 0
+			for _, tx := range bl.Transactions() {
+				i, _ := inboxes.Match(tx.To(), nil, false, func() (common.Address, error) {
+					return bl.Sender(tx)
+				})
+				if i == notInbox {
+					continue
+				}
+				// the derivation ignores the data of other senders, but it is worth knowing that they exist
+				if i == inboxes.Unauthorized() {
+					unauthorized++
+					continue
+				}
+				if len(tx.BlobHashes()) > 0 {
+					for _, h := range tx.BlobHashes() {
+						for j := range bl.Contents[h].frames {
+							trackers[i].addFrame(num, &bl.Contents[h].frames[j], &stats[i])
+						}
+					}
+					continue
+				}
+				// batcher transactions with invalid data are ignored by the derivation, and so are they here
+				frames, err := derive.ParseFrames(tx.Data())
+				if err != nil {
+					continue
+				}
+				for j := range frames {
+					trackers[i].addFrame(num, &frames[j], &stats[i])
+				}
+			}
+			for i := range trackers {
+				trackers[i].timeout(num, channelTimeout(&inboxes.List[i], bl.Time()), &stats[i])
+			}
+			dest[0] = float64(unauthorized)
+			for j, m := range metrics {
+				for i := range stats {
+					dest[1+j*len(stats)+i] = m.fn(&stats[i])
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// NewBatcherExporter creates an exporter of the OP Stack batcher metrics of the finalized blocks of the L1 chain,
+// starting at the min time of the chain. Frames in blobs are only seen if the chain fetches the blob sidecars.
+func NewBatcherExporter(ctx context.Context, log log.Logger, ch *Chain, victoria *VictoriaClient) *FinalizedExporter[*BlobBlock] {
+	src := &blobSource{blocks: NewRPCBlockSource(ch.EthRPC), config: ch.Config, minTime: ch.MinTime}
+	if ch.BlobSidecars {
+		src.beacon = ch.Beacon
+	}
+	blockNum := func(bl *BlobBlock) uint64 {
+		return bl.NumberU64()
+	}
+	// victoria-metrics expects millisecond timestamps
+	blockTime := func(bl *BlobBlock) int64 {
+		return int64(bl.Time()) * 1000
+	}
+	return NewFinalizedExporter[*BlobBlock](ctx, log, ch, victoria, "batches", src, blockNum, blockTime, MakeBatcherStats(ch.Inboxes))
+}
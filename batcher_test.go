@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"github.com/ethereum-optimism/optimism/op-node/rollup"
+	"github.com/ethereum-optimism/optimism/op-node/rollup/derive"
+	"github.com/ethereum/go-ethereum/rlp"
+	"io"
+	"testing"
+)
+
+func TestDecodeChannel(t *testing.T) {
+	var batches []byte
+	for _, b := range [][]byte{{singularBatchType, 1, 2, 3}, {spanBatchType, 4}, {singularBatchType}} {
+		enc, err := rlp.EncodeToBytes(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, enc...)
+	}
+	compress := func(version []byte, newWriter func(w io.Writer) io.WriteCloser) []byte {
+		buf := bytes.NewBuffer(version)
+		w := newWriter(buf)
+		if _, err := w.Write(batches); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	zlibData := compress(nil, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+	brotliData := compress([]byte{derive.ChannelVersionBrotli}, func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) })
+
+	tests := []struct {
+		name     string
+		data     []byte
+		singular int
+		span     int
+		wantErr  bool
+	}{
+		{name: "zlib", data: zlibData, singular: 2, span: 1},
+		{name: "brotli", data: brotliData, singular: 2, span: 1},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "unknown version", data: append([]byte{0x02}, brotliData[1:]...), wantErr: true},
+		{name: "truncated zlib", data: zlibData[:len(zlibData)/2], wantErr: true},
+		{name: "brotli without version byte", data: brotliData[1:], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uncompressed, singular, span, err := decodeChannel(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uncompressed != len(batches) || singular != tt.singular || span != tt.span {
+				t.Fatalf("got %d bytes, %d singular and %d span batches, expected %d bytes, %d and %d",
+					uncompressed, singular, span, len(batches), tt.singular, tt.span)
+			}
+		})
+	}
+}
+
+func TestChannelTimeout(t *testing.T) {
+	granite := uint64(1000)
+	rollupCfg := &rollup.Config{ChannelTimeoutBedrock: 120, GraniteTime: &granite}
+	tests := []struct {
+		name  string
+		inbox Inbox
+		time  uint64
+		want  uint64
+	}{
+		{name: "unknown schedule", time: 2000, want: 300},
+		{name: "registry before granite", inbox: Inbox{GraniteTime: &granite}, time: 999, want: 300},
+		{name: "registry granite", inbox: Inbox{GraniteTime: &granite}, time: 1000, want: 50},
+		{name: "rollup config before granite", inbox: Inbox{RollupConfig: rollupCfg}, time: 999, want: 120},
+		{name: "rollup config granite", inbox: Inbox{RollupConfig: rollupCfg}, time: 1000, want: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := channelTimeout(&tt.inbox, tt.time); got != tt.want {
+				t.Fatalf("got %d, expected %d", got, tt.want)
+			}
+		})
+	}
+}
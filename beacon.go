@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/log"
+	"math/bits"
+)
+
+// BeaconSlot is a slot of the beacon chain, with its canonical block.
+type BeaconSlot struct {
+	Slot uint64
+	// unix timestamp (seconds) of the start of the slot
+	Time uint64
+	// nil if the slot was missed
+	Block *BeaconBlock
+}
+
+var BeaconMissedSlotMetric = Metric[*BeaconSlot]{
+	Name: "beacon_missed_slot",
+	Fn: func(s *BeaconSlot) (float64, error) {
+		if s.Block == nil {
+			return 1, nil
+		}
+		return 0, nil
+	},
+}
+
+// beaconBlockMetric creates a metric of the block of the slot, which is 0 for missed slots.
+func beaconBlockMetric(name string, fn func(bl *BeaconBlock) float64) Metric[*BeaconSlot] {
+	return Metric[*BeaconSlot]{
+		Name: name,
+		Fn: func(s *BeaconSlot) (float64, error) {
+			if s.Block == nil {
+				return 0, nil
+			}
+			return fn(s.Block), nil
+		},
+	}
+}
+
+var BeaconProposerIndexMetric = beaconBlockMetric("beacon_proposer_index", func(bl *BeaconBlock) float64 {
+	return float64(bl.ProposerIndex)
+})
+
+var BeaconAttestationsMetric = beaconBlockMetric("beacon_attestations", func(bl *BeaconBlock) float64 {
+	return float64(len(bl.Body.Attestations))
+})
+
+// BeaconAttestationParticipationMetric is the ratio of committee members that attested,
+// over all the aggregate attestations in the block.
+var BeaconAttestationParticipationMetric = beaconBlockMetric("beacon_attestation_participation", func(bl *BeaconBlock) float64 {
+	var set, total int
+	for _, att := range bl.Body.Attestations {
+		s, n := bitlistCount(att.AggregationBits)
+		set += s
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(set) / float64(total)
+})
+
+// BeaconSyncCommitteeParticipationMetric is the ratio of sync committee members that signed the previous block.
+var BeaconSyncCommitteeParticipationMetric = beaconBlockMetric("beacon_sync_committee_participation", func(bl *BeaconBlock) float64 {
+	if bl.Body.SyncAggregate == nil || len(bl.Body.SyncAggregate.SyncCommitteeBits) == 0 {
+		return 0 // before altair
+	}
+	committeeBits := bl.Body.SyncAggregate.SyncCommitteeBits
+	set := 0
+	for _, b := range committeeBits {
+		set += bits.OnesCount8(b)
+	}
+	return float64(set) / float64(len(committeeBits)*8)
+})
+
+var BeaconProposerSlashingsMetric = beaconBlockMetric("beacon_proposer_slashings", func(bl *BeaconBlock) float64 {
+	return float64(len(bl.Body.ProposerSlashings))
+})
+
+var BeaconAttesterSlashingsMetric = beaconBlockMetric("beacon_attester_slashings", func(bl *BeaconBlock) float64 {
+	return float64(len(bl.Body.AttesterSlashings))
+})
+
+var BeaconDepositsMetric = beaconBlockMetric("beacon_deposits", func(bl *BeaconBlock) float64 {
+	return float64(len(bl.Body.Deposits))
+})
+
+var BeaconVoluntaryExitsMetric = beaconBlockMetric("beacon_voluntary_exits", func(bl *BeaconBlock) float64 {
+	return float64(len(bl.Body.VoluntaryExits))
+})
+
+var BeaconBLSToExecutionChangesMetric = beaconBlockMetric("beacon_bls_to_execution_changes", func(bl *BeaconBlock) float64 {
+	return float64(len(bl.Body.BLSToExecutionChanges))
+})
+
+var BeaconMetrics = Aggregate[*BeaconSlot](
+	BeaconMissedSlotMetric,
+	BeaconProposerIndexMetric,
+	BeaconAttestationsMetric,
+	BeaconAttestationParticipationMetric,
+	BeaconSyncCommitteeParticipationMetric,
+	BeaconProposerSlashingsMetric,
+	BeaconAttesterSlashingsMetric,
+	BeaconDepositsMetric,
+	BeaconVoluntaryExitsMetric,
+	BeaconBLSToExecutionChangesMetric,
+)
+
+// bitlistCount returns the number of set bits, and the length, of an SSZ bitlist.
+// The highest set bit of the last byte is the delimiter that marks the length, and is not counted.
+func bitlistCount(data []byte) (set, length int) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return 0, 0 // invalid bitlist
+	}
+	for _, b := range data {
+		set += bits.OnesCount8(b)
+	}
+	length = (len(data)-1)*8 + bits.Len8(data[len(data)-1]) - 1
+	return set - 1, length
+}
+
+// beaconSource provides the finalized slots of a beacon node.
+type beaconSource struct {
+	cl      *BeaconClient
+	minTime uint64
+	timing  *BeaconTiming
+}
+
+func (s *beaconSource) Finalized(ctx context.Context) (uint64, error) {
+	if s.timing == nil {
+		timing, err := s.cl.Timing(ctx)
+		if err != nil {
+			return 0, err
+		}
+		s.timing = &timing
+	}
+	return s.cl.HeaderSlot(ctx, "finalized")
+}
+
+func (s *beaconSource) Start(ctx context.Context, finalized uint64) (uint64, bool, error) {
+	return s.timing.SlotAt(s.minTime), true, nil
+}
+
+func (s *beaconSource) Fetch(ctx context.Context, slot uint64) (*BeaconSlot, error) {
+	bl, err := s.cl.BlockBySlot(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+	return &BeaconSlot{Slot: slot, Time: s.timing.SlotTime(slot), Block: bl}, nil
+}
+
+// NewBeaconExporter creates an exporter of the consensus-layer metrics of the finalized slots of the chain,
+// starting at the min time of the chain.
+func NewBeaconExporter(ctx context.Context, log log.Logger, ch *Chain, victoria *VictoriaClient) *FinalizedExporter[*BeaconSlot] {
+	src := &beaconSource{cl: ch.Beacon, minTime: ch.MinTime}
+	slotNum := func(s *BeaconSlot) uint64 {
+		return s.Slot
+	}
+	// victoria-metrics expects millisecond timestamps
+	slotTime := func(s *BeaconSlot) int64 {
+		return int64(s.Time) * 1000
+	}
+	return NewFinalizedExporter[*BeaconSlot](ctx, log, ch, victoria, "beacon", src, slotNum, slotTime, BeaconMetrics)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const beaconRequestTimeout = 30 * time.Second
+
+// BeaconClient reads from the REST API of a beacon node.
+// See https://ethereum.github.io/beacon-APIs/
+type BeaconClient struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+func NewBeaconClient(endpoint string) *BeaconClient {
+	return &BeaconClient{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		httpClient: &http.Client{Timeout: beaconRequestTimeout},
+	}
+}
+
+// errBeaconNotFound is returned when the beacon node has no data for the request, e.g. a missed slot
+var errBeaconNotFound = errors.New("not found")
+
+func (c *BeaconClient) get(ctx context.Context, path string, dest any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errBeaconNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return fmt.Errorf("beacon request %s failed with status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return fmt.Errorf("failed to decode beacon response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// BeaconTiming is what is needed to convert between slots and time
+type BeaconTiming struct {
+	GenesisTime    uint64
+	SecondsPerSlot uint64
+}
+
+// SlotTime returns the unix timestamp (seconds) of the start of the slot
+func (t BeaconTiming) SlotTime(slot uint64) uint64 {
+	return t.GenesisTime + slot*t.SecondsPerSlot
+}
+
+// SlotAt returns the first slot that starts at or after the given unix timestamp (seconds)
+func (t BeaconTiming) SlotAt(timestamp uint64) uint64 {
+	if timestamp <= t.GenesisTime {
+		return 0
+	}
+	return (timestamp - t.GenesisTime + t.SecondsPerSlot - 1) / t.SecondsPerSlot
+}
+
+func (c *BeaconClient) Timing(ctx context.Context) (BeaconTiming, error) {
+	var genesis struct {
+		Data struct {
+			GenesisTime uint64 `json:"genesis_time,string"`
+		} `json:"data"`
+	}
+	if err := c.get(ctx, "/eth/v1/beacon/genesis", &genesis); err != nil {
+		return BeaconTiming{}, fmt.Errorf("failed to get genesis: %w", err)
+	}
+	var spec struct {
+		Data struct {
+			SecondsPerSlot uint64 `json:"SECONDS_PER_SLOT,string"`
+		} `json:"data"`
+	}
+	if err := c.get(ctx, "/eth/v1/config/spec", &spec); err != nil {
+		return BeaconTiming{}, fmt.Errorf("failed to get spec: %w", err)
+	}
+	if spec.Data.SecondsPerSlot == 0 {
+		return BeaconTiming{}, errors.New("spec has no SECONDS_PER_SLOT")
+	}
+	return BeaconTiming{GenesisTime: genesis.Data.GenesisTime, SecondsPerSlot: spec.Data.SecondsPerSlot}, nil
+}
+
+// HeaderSlot returns the slot of the block header with the given id, e.g. "head" or "finalized".
+func (c *BeaconClient) HeaderSlot(ctx context.Context, id string) (uint64, error) {
+	var out struct {
+		Data struct {
+			Header struct {
+				Message struct {
+					Slot uint64 `json:"slot,string"`
+				} `json:"message"`
+			} `json:"header"`
+		} `json:"data"`
+	}
+	if err := c.get(ctx, "/eth/v1/beacon/headers/"+id, &out); err != nil {
+		return 0, fmt.Errorf("failed to get %s header: %w", id, err)
+	}
+	return out.Data.Header.Message.Slot, nil
+}
+
+// BeaconAttestation is the part of an attestation that metrics look at
+type BeaconAttestation struct {
+	AggregationBits hexutil.Bytes `json:"aggregation_bits"`
+}
+
+// BeaconBlock is the part of a beacon block that metrics look at.
+type BeaconBlock struct {
+	Slot          uint64 `json:"slot,string"`
+	ProposerIndex uint64 `json:"proposer_index,string"`
+	Body          struct {
+		ProposerSlashings []json.RawMessage   `json:"proposer_slashings"`
+		AttesterSlashings []json.RawMessage   `json:"attester_slashings"`
+		Attestations      []BeaconAttestation `json:"attestations"`
+		Deposits          []json.RawMessage   `json:"deposits"`
+		VoluntaryExits    []json.RawMessage   `json:"voluntary_exits"`
+		// since altair
+		SyncAggregate *struct {
+			SyncCommitteeBits hexutil.Bytes `json:"sync_committee_bits"`
+		} `json:"sync_aggregate"`
+		// since capella
+		BLSToExecutionChanges []json.RawMessage `json:"bls_to_execution_changes"`
+	} `json:"body"`
+}
+
+// BlockBySlot returns the canonical block of the slot, or nil if the slot was missed.
+func (c *BeaconClient) BlockBySlot(ctx context.Context, slot uint64) (*BeaconBlock, error) {
+	var out struct {
+		Data struct {
+			Message BeaconBlock `json:"message"`
+		} `json:"data"`
+	}
+	if err := c.get(ctx, "/eth/v2/beacon/blocks/"+strconv.FormatUint(slot, 10), &out); err != nil {
+		if errors.Is(err, errBeaconNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get block of slot %d: %w", slot, err)
+	}
+	if out.Data.Message.Slot != slot {
+		return nil, fmt.Errorf("expected block of slot %d, but got slot %d", slot, out.Data.Message.Slot)
+	}
+	return &out.Data.Message, nil
+}
+
+// BlobSidecar is the part of a blob sidecar that metrics look at.
+type BlobSidecar struct {
+	Index         uint64        `json:"index,string"`
+	Blob          hexutil.Bytes `json:"blob"`
+	KZGCommitment hexutil.Bytes `json:"kzg_commitment"`
+}
+
+// BlobSidecars returns the blob sidecars of the canonical block of the slot, or nil if the slot was missed.
+// Beacon nodes prune the sidecars after 4096 epochs (about 18 days) by default.
+func (c *BeaconClient) BlobSidecars(ctx context.Context, slot uint64) ([]BlobSidecar, error) {
+	var out struct {
+		Data []BlobSidecar `json:"data"`
+	}
+	if err := c.get(ctx, "/eth/v1/beacon/blob_sidecars/"+strconv.FormatUint(slot, 10), &out); err != nil {
+		if errors.Is(err, errBeaconNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get blob sidecars of slot %d: %w", slot, err)
+	}
+	return out.Data, nil
+}
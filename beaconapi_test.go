@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBeaconSourceFetch(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		// the expected proposer, or -1 if the slot was missed
+		wantProposer int64
+		wantErr      string
+	}{
+		{
+			name:         "block",
+			status:       http.StatusOK,
+			body:         `{"version":"deneb","data":{"message":{"slot":"100","proposer_index":"42","body":{"attestations":[]}}}}`,
+			wantProposer: 42,
+		},
+		{
+			name:         "missed slot",
+			status:       http.StatusNotFound,
+			body:         `{"code":404,"message":"NOT_FOUND: beacon block at slot 100"}`,
+			wantProposer: -1,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			body:    `{"code":500,"message":"internal error"}`,
+			wantErr: "failed with status 500",
+		},
+		{
+			name:    "block of another slot",
+			status:  http.StatusOK,
+			body:    `{"data":{"message":{"slot":"99","proposer_index":"42","body":{}}}}`,
+			wantErr: "expected block of slot 100, but got slot 99",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/eth/v2/beacon/blocks/100" {
+					t.Errorf("unexpected request %s", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			src := &beaconSource{cl: NewBeaconClient(srv.URL), timing: &BeaconTiming{GenesisTime: 1000, SecondsPerSlot: 12}}
+			s, err := src.Fetch(context.Background(), 100)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Slot != 100 || s.Time != 1000+100*12 {
+				t.Errorf("got slot %d at %d", s.Slot, s.Time)
+			}
+			missed, err := BeaconMissedSlotMetric.Fn(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantProposer < 0 {
+				if s.Block != nil || missed != 1 {
+					t.Errorf("got block %+v and missed %v, want a missed slot", s.Block, missed)
+				}
+			} else if s.Block == nil || int64(s.Block.ProposerIndex) != tt.wantProposer || missed != 0 {
+				t.Errorf("got block %+v and missed %v, want proposer %d", s.Block, missed, tt.wantProposer)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	"math"
+	"math/big"
+)
+
+// BeaconFork identifies the SSZ type of beacon blocks
+type BeaconFork int
+
+const (
+	ForkPhase0 BeaconFork = iota
+	ForkAltair
+	ForkBellatrix
+	ForkCapella
+	ForkDeneb
+	// ForkElectra also covers the later forks that did not change the beacon block body, i.e. Fulu
+	ForkElectra
+)
+
+const (
+	slotsPerEpoch = 32
+	// epoch of forks that are not scheduled
+	farFutureEpoch = math.MaxUint64
+)
+
+// BeaconForkSchedule lists the first epoch of every fork after phase0, in order.
+type BeaconForkSchedule [ForkElectra]uint64
+
+// Fork returns the fork that the slot belongs to.
+func (s *BeaconForkSchedule) Fork(slot uint64) BeaconFork {
+	fork := ForkPhase0
+	for i, epoch := range s {
+		if slot/slotsPerEpoch >= epoch {
+			fork = BeaconFork(i + 1)
+		}
+	}
+	return fork
+}
+
+// beaconForkSchedules of the networks that era files are published for, by network name
+var beaconForkSchedules = map[string]*BeaconForkSchedule{
+	"mainnet": {74240, 144896, 194048, 269568, 364032},
+	"goerli":  {36660, 112260, 162304, 231680, farFutureEpoch},
+	"sepolia": {50, 100, 56832, 132608, 222464},
+	"holesky": {0, 0, 256, 29696, 115968},
+}
+
+// sszOffset reads the 4-byte offset at the given position, and checks that it is within the data.
+func sszOffset(data []byte, pos int) (int, error) {
+	if pos+4 > len(data) {
+		return 0, fmt.Errorf("offset at %d out of bounds (len %d)", pos, len(data))
+	}
+	offset := int(binary.LittleEndian.Uint32(data[pos:]))
+	if offset > len(data) {
+		return 0, fmt.Errorf("offset %d at %d out of bounds (len %d)", offset, pos, len(data))
+	}
+	return offset, nil
+}
+
+// sszSpan returns the variable-size field that starts at the offset at pos,
+// and ends at the offset at nextPos, or at the end of the data if nextPos is negative.
+func sszSpan(data []byte, pos int, nextPos int) ([]byte, error) {
+	start, err := sszOffset(data, pos)
+	if err != nil {
+		return nil, err
+	}
+	end := len(data)
+	if nextPos >= 0 {
+		if end, err = sszOffset(data, nextPos); err != nil {
+			return nil, err
+		}
+	}
+	if start > end {
+		return nil, fmt.Errorf("offset %d at %d is past the next offset %d", start, pos, end)
+	}
+	return data[start:end], nil
+}
+
+// beaconPayload is the part of a beacon block that makes up the execution block
+type beaconPayload struct {
+	slot uint64
+	fork BeaconFork
+	// the parent beacon block root, which is committed to by the execution block since deneb
+	parentRoot common.Hash
+	// SSZ encoded execution payload, nil if the fork has no execution payload
+	payload []byte
+	// SSZ encoded execution requests since electra
+	requests []byte
+}
+
+// beaconBlockPayload returns the execution payload, and what else goes into the execution block,
+// of the SSZ encoded SignedBeaconBlock.
+func beaconBlockPayload(data []byte, schedule *BeaconForkSchedule) (*beaconPayload, error) {
+	// SignedBeaconBlock: message offset, signature
+	msg, err := sszSpan(data, 0, -1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed beacon block: %w", err)
+	}
+	// BeaconBlock: slot, proposer index, parent root, state root, body offset
+	if len(msg) < 8+8+32+32+4 {
+		return nil, fmt.Errorf("beacon block too short: %d", len(msg))
+	}
+	p := &beaconPayload{
+		slot:       binary.LittleEndian.Uint64(msg[:8]),
+		parentRoot: common.BytesToHash(msg[16:48]),
+	}
+	p.fork = schedule.Fork(p.slot)
+	if p.fork < ForkBellatrix {
+		return p, nil
+	}
+	body, err := sszSpan(msg, 8+8+32+32, -1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid beacon block body: %w", err)
+	}
+	// BeaconBlockBody: randao reveal, eth1 data, graffiti, 5 operation list offsets, sync aggregate,
+	// and then the execution payload offset, followed by the offsets of fork specific lists:
+	// bls to execution changes since capella, blob kzg commitments since deneb and execution requests since electra.
+	const (
+		payloadOffsetPos  = 96 + 72 + 32 + 5*4 + 160
+		requestsOffsetPos = payloadOffsetPos + 3*4
+	)
+	nextPos := -1
+	if p.fork >= ForkCapella {
+		nextPos = payloadOffsetPos + 4
+	}
+	if p.payload, err = sszSpan(body, payloadOffsetPos, nextPos); err != nil {
+		return nil, fmt.Errorf("invalid execution payload in beacon block body: %w", err)
+	}
+	if p.fork >= ForkElectra {
+		if p.requests, err = sszSpan(body, requestsOffsetPos, -1); err != nil {
+			return nil, fmt.Errorf("invalid execution requests in beacon block body: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// decodeExecutionPayload turns the execution payload into a block, and verifies the block hash.
+// It returns nil if the payload is empty, i.e. the block is from before the merge.
+func decodeExecutionPayload(p *beaconPayload) (*types.Block, error) {
+	data := p.payload
+	// fixed part: parent hash, fee recipient, state root, receipts root, logs bloom, prev randao,
+	// block number, gas limit, gas used, timestamp, extra data offset, base fee, block hash, transactions offset,
+	// since capella the withdrawals offset, and since deneb the blob gas used and the excess blob gas
+	const (
+		numberPos    = 32 + 20 + 32 + 32 + 256 + 32
+		extraPos     = numberPos + 4*8
+		baseFeePos   = extraPos + 4
+		blockHashPos = baseFeePos + 32
+		txsPos       = blockHashPos + 32
+		withdrawPos  = txsPos + 4
+		blobGasPos   = withdrawPos + 4
+	)
+	fixedSize := withdrawPos
+	if p.fork >= ForkCapella {
+		fixedSize += 4
+	}
+	if p.fork >= ForkDeneb {
+		fixedSize += 8 + 8
+	}
+	if len(data) < fixedSize {
+		return nil, fmt.Errorf("execution payload too short: %d", len(data))
+	}
+	blockHash := common.BytesToHash(data[blockHashPos : blockHashPos+32])
+	if blockHash == (common.Hash{}) {
+		return nil, nil // pre-merge blocks have an empty payload
+	}
+	extra, err := sszSpan(data, extraPos, txsPos)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extra data: %w", err)
+	}
+	withdrawalsOffsetPos := -1
+	if p.fork >= ForkCapella {
+		withdrawalsOffsetPos = withdrawPos
+	}
+	txsData, err := sszSpan(data, txsPos, withdrawalsOffsetPos)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transactions: %w", err)
+	}
+	txs, err := decodeSSZTransactions(txsData)
+	if err != nil {
+		return nil, err
+	}
+	// base fee is a little-endian uint256
+	baseFee := make([]byte, 32)
+	for i := 0; i < 32; i++ {
+		baseFee[i] = data[baseFeePos+31-i]
+	}
+	header := &types.Header{
+		ParentHash:  common.BytesToHash(data[0:32]),
+		UncleHash:   types.EmptyUncleHash,
+		Coinbase:    common.BytesToAddress(data[32:52]),
+		Root:        common.BytesToHash(data[52:84]),
+		TxHash:      types.DeriveSha(types.Transactions(txs), trie.NewStackTrie(nil)),
+		ReceiptHash: common.BytesToHash(data[84:116]),
+		Bloom:       types.BytesToBloom(data[116:372]),
+		Difficulty:  new(big.Int),
+		Number:      new(big.Int).SetUint64(binary.LittleEndian.Uint64(data[numberPos:])),
+		GasLimit:    binary.LittleEndian.Uint64(data[numberPos+8:]),
+		GasUsed:     binary.LittleEndian.Uint64(data[numberPos+16:]),
+		Time:        binary.LittleEndian.Uint64(data[numberPos+24:]),
+		Extra:       extra,
+		MixDigest:   common.BytesToHash(data[372:404]),
+		BaseFee:     new(big.Int).SetBytes(baseFee),
+	}
+	var withdrawals types.Withdrawals
+	if p.fork >= ForkCapella {
+		withdrawalsData, err := sszSpan(data, withdrawPos, -1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid withdrawals: %w", err)
+		}
+		if withdrawals, err = decodeSSZWithdrawals(withdrawalsData); err != nil {
+			return nil, err
+		}
+		h := types.DeriveSha(withdrawals, trie.NewStackTrie(nil))
+		header.WithdrawalsHash = &h
+	}
+	if p.fork >= ForkDeneb {
+		blobGasUsed := binary.LittleEndian.Uint64(data[blobGasPos:])
+		excessBlobGas := binary.LittleEndian.Uint64(data[blobGasPos+8:])
+		header.BlobGasUsed = &blobGasUsed
+		header.ExcessBlobGas = &excessBlobGas
+		header.ParentBeaconRoot = &p.parentRoot
+	}
+	if p.fork >= ForkElectra {
+		requests, err := decodeSSZExecutionRequests(p.requests)
+		if err != nil {
+			return nil, err
+		}
+		h := types.CalcRequestsHash(requests)
+		header.RequestsHash = &h
+	}
+	bl := types.NewBlockWithHeader(header).WithBody(types.Body{Transactions: txs, Withdrawals: withdrawals})
+	if bl.Hash() != blockHash {
+		return nil, fmt.Errorf("failed to verify block hash: computed %s but payload said %s", bl.Hash(), blockHash)
+	}
+	return bl, nil
+}
+
+// decodeSSZTransactions decodes a list of opaque transactions
+func decodeSSZTransactions(data []byte) ([]*types.Transaction, error) {
+	if len(data) == 0 {
+		return []*types.Transaction{}, nil
+	}
+	first, err := sszOffset(data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transactions list: %w", err)
+	}
+	if first%4 != 0 || first == 0 {
+		return nil, fmt.Errorf("invalid first transaction offset %d", first)
+	}
+	count := first / 4
+	txs := make([]*types.Transaction, count)
+	for i := range txs {
+		nextPos := -1
+		if i+1 < count {
+			nextPos = 4 * (i + 1)
+		}
+		txData, err := sszSpan(data, 4*i, nextPos)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction %d: %w", i, err)
+		}
+		var tx types.Transaction
+		if err := tx.UnmarshalBinary(txData); err != nil {
+			return nil, fmt.Errorf("failed to decode transaction %d: %w", i, err)
+		}
+		txs[i] = &tx
+	}
+	return txs, nil
+}
+
+// decodeSSZWithdrawals decodes a list of withdrawals:
+// index, validator index, address and amount in gwei.
+func decodeSSZWithdrawals(data []byte) (types.Withdrawals, error) {
+	const size = 8 + 8 + 20 + 8
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("invalid withdrawals list length %d", len(data))
+	}
+	out := make(types.Withdrawals, 0, len(data)/size)
+	for i := 0; i < len(data); i += size {
+		out = append(out, &types.Withdrawal{
+			Index:     binary.LittleEndian.Uint64(data[i:]),
+			Validator: binary.LittleEndian.Uint64(data[i+8:]),
+			Address:   common.BytesToAddress(data[i+16 : i+36]),
+			Amount:    binary.LittleEndian.Uint64(data[i+36:]),
+		})
+	}
+	return out, nil
+}
+
+// decodeSSZExecutionRequests turns the execution requests into the EIP-7685 requests of the execution block:
+// the deposit, withdrawal and consolidation request lists, each prefixed with its request type.
+// The requests have a fixed size, and are encoded the same way in SSZ and in the execution block.
+func decodeSSZExecutionRequests(data []byte) ([][]byte, error) {
+	sizes := []int{
+		48 + 32 + 8 + 96 + 8, // deposit: pubkey, withdrawal credentials, amount, signature, index
+		20 + 48 + 8,          // withdrawal: source address, validator pubkey, amount
+		20 + 48 + 48,         // consolidation: source address, source pubkey, target pubkey
+	}
+	requests := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		nextPos := -1
+		if i+1 < len(sizes) {
+			nextPos = 4 * (i + 1)
+		}
+		list, err := sszSpan(data, 4*i, nextPos)
+		if err != nil {
+			return nil, fmt.Errorf("invalid execution requests of type %d: %w", i, err)
+		}
+		if len(list)%size != 0 {
+			return nil, fmt.Errorf("invalid execution requests list length %d of type %d", len(list), i)
+		}
+		requests[i] = append([]byte{byte(i)}, list...)
+	}
+	return requests, nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// sszVariable is a variable-size field of an SSZ container, which is encoded as an offset in the fixed part
+type sszVariable []byte
+
+// sszContainer encodes a container of fixed-size fields ([]byte) and variable-size fields (sszVariable).
+func sszContainer(fields ...any) []byte {
+	fixedSize := 0
+	for _, f := range fields {
+		switch f := f.(type) {
+		case sszVariable:
+			fixedSize += 4
+		case []byte:
+			fixedSize += len(f)
+		}
+	}
+	var fixed, variable []byte
+	for _, f := range fields {
+		switch f := f.(type) {
+		case sszVariable:
+			fixed = binary.LittleEndian.AppendUint32(fixed, uint32(fixedSize+len(variable)))
+			variable = append(variable, f...)
+		case []byte:
+			fixed = append(fixed, f...)
+		}
+	}
+	return append(fixed, variable...)
+}
+
+// sszList encodes a list of variable-size items
+func sszList(items [][]byte) []byte {
+	fields := make([]any, len(items))
+	for i, item := range items {
+		fields[i] = sszVariable(item)
+	}
+	return sszContainer(fields...)
+}
+
+func le64(v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, v)
+}
+
+// encodeSSZPayload encodes the block as the execution payload of the fork
+func encodeSSZPayload(bl *types.Block, fork BeaconFork) []byte {
+	baseFee := make([]byte, 32)
+	for i, b := range bl.BaseFee().FillBytes(make([]byte, 32)) {
+		baseFee[31-i] = b
+	}
+	var txs [][]byte
+	for _, tx := range bl.Transactions() {
+		data, err := tx.MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+		txs = append(txs, data)
+	}
+	fields := []any{
+		bl.ParentHash().Bytes(), bl.Coinbase().Bytes(), bl.Root().Bytes(), bl.ReceiptHash().Bytes(),
+		bl.Bloom().Bytes(), bl.MixDigest().Bytes(),
+		le64(bl.NumberU64()), le64(bl.GasLimit()), le64(bl.GasUsed()), le64(bl.Time()),
+		sszVariable(bl.Extra()), baseFee, bl.Hash().Bytes(), sszVariable(sszList(txs)),
+	}
+	if fork >= ForkCapella {
+		var withdrawals []byte
+		for _, w := range bl.Withdrawals() {
+			withdrawals = append(withdrawals, le64(w.Index)...)
+			withdrawals = append(withdrawals, le64(w.Validator)...)
+			withdrawals = append(withdrawals, w.Address.Bytes()...)
+			withdrawals = append(withdrawals, le64(w.Amount)...)
+		}
+		fields = append(fields, sszVariable(withdrawals))
+	}
+	if fork >= ForkDeneb {
+		fields = append(fields, le64(*bl.BlobGasUsed()), le64(*bl.ExcessBlobGas()))
+	}
+	return sszContainer(fields...)
+}
+
+// emptySSZPayload encodes the empty execution payload of a block before the merge
+func emptySSZPayload(fork BeaconFork) []byte {
+	fields := []any{make([]byte, 32+20+32+32+256+32+4*8), sszVariable(nil), make([]byte, 32+32), sszVariable(nil)}
+	if fork >= ForkCapella {
+		fields = append(fields, sszVariable(nil))
+	}
+	if fork >= ForkDeneb {
+		fields = append(fields, make([]byte, 16))
+	}
+	return sszContainer(fields...)
+}
+
+// encodeSignedBeaconBlock wraps the execution payload and requests in a signed beacon block of the fork.
+// The payload is left out before bellatrix.
+func encodeSignedBeaconBlock(slot uint64, parentRoot common.Hash, fork BeaconFork, payload []byte, requests []byte) []byte {
+	empty := sszVariable(nil)
+	body := []any{make([]byte, 96+72+32), empty, empty, empty, empty, empty, make([]byte, 160)}
+	if fork >= ForkBellatrix {
+		body = append(body, sszVariable(payload))
+	}
+	if fork >= ForkCapella {
+		body = append(body, empty) // bls to execution changes
+	}
+	if fork >= ForkDeneb {
+		body = append(body, empty) // blob kzg commitments
+	}
+	if fork >= ForkElectra {
+		body = append(body, sszVariable(requests))
+	}
+	msg := sszContainer(le64(slot), le64(7), parentRoot.Bytes(), make([]byte, 32), sszVariable(sszContainer(body...)))
+	return sszContainer(sszVariable(msg), make([]byte, 96))
+}
+
+// testExecutionBlock creates a block with a transaction and the fields of the fork
+func testExecutionBlock(t testing.TB, num uint64, fork BeaconFork, parentRoot common.Hash, requests [][]byte) *types.Block {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	tx, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{
+		ChainID: big.NewInt(1), Nonce: num, GasTipCap: big.NewInt(1e9), GasFeeCap: big.NewInt(30e9),
+		Gas: 21000, To: &common.Address{0xaa}, Value: big.NewInt(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := types.Transactions{tx}
+	header := &types.Header{
+		ParentHash:  common.Hash{byte(num)},
+		UncleHash:   types.EmptyUncleHash,
+		Coinbase:    common.Address{0xcb},
+		Root:        common.Hash{0x01},
+		TxHash:      types.DeriveSha(txs, trie.NewStackTrie(nil)),
+		ReceiptHash: common.Hash{0x02},
+		Difficulty:  new(big.Int),
+		Number:      new(big.Int).SetUint64(num),
+		GasLimit:    30_000_000,
+		GasUsed:     21000,
+		Time:        1_700_000_000 + num*12,
+		Extra:       []byte("test"),
+		MixDigest:   common.Hash{0x03},
+		BaseFee:     big.NewInt(7e9 + 1),
+	}
+	var withdrawals types.Withdrawals
+	if fork >= ForkCapella {
+		withdrawals = types.Withdrawals{{Index: num, Validator: 5, Address: common.Address{0xee}, Amount: 32e9}}
+		h := types.DeriveSha(withdrawals, trie.NewStackTrie(nil))
+		header.WithdrawalsHash = &h
+	}
+	if fork >= ForkDeneb {
+		blobGasUsed, excessBlobGas := uint64(131072), uint64(393216)
+		header.BlobGasUsed = &blobGasUsed
+		header.ExcessBlobGas = &excessBlobGas
+		header.ParentBeaconRoot = &parentRoot
+	}
+	if fork >= ForkElectra {
+		h := types.CalcRequestsHash(requests)
+		header.RequestsHash = &h
+	}
+	return types.NewBlockWithHeader(header).WithBody(types.Body{Transactions: txs, Withdrawals: withdrawals})
+}
+
+func TestBeaconForkSchedule(t *testing.T) {
+	tests := []struct {
+		network string
+		slot    uint64
+		want    BeaconFork
+	}{
+		{"mainnet", 0, ForkPhase0},
+		{"mainnet", 74240*32 - 1, ForkPhase0},
+		{"mainnet", 74240 * 32, ForkAltair},
+		{"mainnet", 144896 * 32, ForkBellatrix},
+		{"mainnet", 194048 * 32, ForkCapella},
+		{"mainnet", 364032*32 - 1, ForkDeneb},
+		{"mainnet", 364032 * 32, ForkElectra},
+		{"goerli", 1 << 40, ForkDeneb},
+		{"holesky", 0, ForkBellatrix},
+	}
+	for _, tt := range tests {
+		if got := beaconForkSchedules[tt.network].Fork(tt.slot); got != tt.want {
+			t.Errorf("%s slot %d: got fork %d, want %d", tt.network, tt.slot, got, tt.want)
+		}
+	}
+}
+
+func TestSSZSpan(t *testing.T) {
+	// offsets 8 and 10, then 2 and 3 bytes of data
+	data := []byte{8, 0, 0, 0, 10, 0, 0, 0, 1, 2, 3, 4, 5}
+	tests := []struct {
+		name    string
+		data    []byte
+		pos     int
+		nextPos int
+		want    []byte
+		wantErr string
+	}{
+		{name: "up to the next offset", data: data, pos: 0, nextPos: 4, want: []byte{1, 2}},
+		{name: "up to the end", data: data, pos: 4, nextPos: -1, want: []byte{3, 4, 5}},
+		{name: "offset out of bounds", data: data[:3], pos: 0, nextPos: -1, wantErr: "out of bounds"},
+		{name: "offset past the end", data: data[:9], pos: 4, nextPos: -1, wantErr: "offset 10 at 4 out of bounds"},
+		{name: "offset past the next offset", data: data, pos: 4, nextPos: 0, wantErr: "past the next offset"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sszSpan(tt.data, tt.pos, tt.nextPos)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeExecutionPayload(t *testing.T) {
+	parentRoot := common.HexToHash("0xbeac")
+	withdrawalRequest := append([]byte{1}, bytes.Repeat([]byte{0x42}, 20+48+8)...)
+	schedule := beaconForkSchedules["mainnet"]
+	slots := map[BeaconFork]uint64{
+		ForkAltair:    74240 * 32,
+		ForkBellatrix: 144896 * 32,
+		ForkCapella:   194048 * 32,
+		ForkDeneb:     269568 * 32,
+		ForkElectra:   364032 * 32,
+	}
+
+	tests := []struct {
+		name string
+		fork BeaconFork
+		// execution requests of the block, as the type followed by the requests
+		requests [][]byte
+		// modifies the encoded payload and requests
+		corrupt func(payload, requests []byte) ([]byte, []byte)
+		// whether the beacon block has no execution block
+		wantNil bool
+		wantErr string
+	}{
+		{name: "altair", fork: ForkAltair, wantNil: true},
+		{name: "bellatrix", fork: ForkBellatrix},
+		{name: "capella", fork: ForkCapella},
+		{name: "deneb", fork: ForkDeneb},
+		{name: "electra without requests", fork: ForkElectra, requests: [][]byte{{0}, {1}, {2}}},
+		{name: "electra with requests", fork: ForkElectra, requests: [][]byte{{0}, withdrawalRequest, {2}}},
+		{
+			name: "pre-merge",
+			fork: ForkBellatrix,
+			corrupt: func(payload, requests []byte) ([]byte, []byte) {
+				return emptySSZPayload(ForkBellatrix), requests
+			},
+			wantNil: true,
+		},
+		{
+			name: "wrong block hash",
+			fork: ForkDeneb,
+			corrupt: func(payload, requests []byte) ([]byte, []byte) {
+				payload[32+20] ^= 1 // state root
+				return payload, requests
+			},
+			wantErr: "failed to verify block hash",
+		},
+		{
+			name: "truncated payload",
+			fork: ForkCapella,
+			corrupt: func(payload, requests []byte) ([]byte, []byte) {
+				return payload[:500], requests
+			},
+			wantErr: "execution payload too short",
+		},
+		{
+			name:     "invalid requests length",
+			fork:     ForkElectra,
+			requests: [][]byte{{0}, withdrawalRequest, {2}},
+			corrupt: func(payload, requests []byte) ([]byte, []byte) {
+				return payload, sszContainer(sszVariable(nil), sszVariable(withdrawalRequest[2:]), sszVariable(nil))
+			},
+			wantErr: "invalid execution requests list length",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bl := testExecutionBlock(t, 100, tt.fork, parentRoot, tt.requests)
+			payload := encodeSSZPayload(bl, tt.fork)
+			var requests []byte
+			if tt.requests != nil {
+				requests = sszContainer(sszVariable(tt.requests[0][1:]), sszVariable(tt.requests[1][1:]), sszVariable(tt.requests[2][1:]))
+			}
+			if tt.corrupt != nil {
+				payload, requests = tt.corrupt(payload, requests)
+			}
+			data := encodeSignedBeaconBlock(slots[tt.fork], parentRoot, tt.fork, payload, requests)
+
+			p, err := beaconBlockPayload(data, schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.slot != slots[tt.fork] || p.fork != tt.fork || p.parentRoot != parentRoot {
+				t.Fatalf("got slot %d, fork %d and parent root %s", p.slot, p.fork, p.parentRoot)
+			}
+			var got *types.Block
+			if p.payload != nil {
+				got, err = decodeExecutionPayload(p)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Fatalf("got block %d, want none", got.NumberU64())
+				}
+				return
+			}
+			if got == nil || got.Hash() != bl.Hash() {
+				t.Fatalf("got block %v, want %s", got, bl.Hash())
+			}
+			if len(got.Transactions()) != 1 || got.Transactions()[0].Hash() != bl.Transactions()[0].Hash() {
+				t.Errorf("transactions do not match")
+			}
+			if len(got.Withdrawals()) != len(bl.Withdrawals()) {
+				t.Errorf("got %d withdrawals, want %d", len(got.Withdrawals()), len(bl.Withdrawals()))
+			}
+		})
+	}
+}
+
+func TestDecodeSSZExecutionRequests(t *testing.T) {
+	// EIP-7685: the hash of no requests is the sha256 of nothing
+	requests, err := decodeSSZExecutionRequests(sszContainer(sszVariable(nil), sszVariable(nil), sszVariable(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := common.HexToHash("0xe3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	if got := types.CalcRequestsHash(requests); got != want {
+		t.Errorf("got requests hash %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"math/big"
+)
+
+// blob gas used by every blob, see EIP-4844
+const blobGasPerBlob = 1 << 17
+
+// blobGasPrice returns the blob base fee of the block in wei, following the blob schedule of the chain config,
+// or nil if the block has no blob gas fields or the chain config has no blob schedule for it.
+// OP Stack chains do not support blobs, so have no blob gas price.
+func blobGasPrice(config *params.ChainConfig, h *types.Header) *big.Int {
+	if h.ExcessBlobGas == nil || config.IsOptimism() || !config.IsCancun(h.Number, h.Time) ||
+		config.BlobScheduleConfig == nil || config.BlobScheduleConfig.Cancun == nil {
+		return nil
+	}
+	return eip4844.CalcBlobFee(config, h)
+}
+
+// BlobBlock is a block with the blob contents and fee parameters that the blob metrics look at.
+type BlobBlock struct {
+	*types.Block
+
+	// measured content of the blobs, by versioned hash, nil if the blob sidecars are not fetched
+	Contents map[common.Hash]BlobContent
+
+	// recovers the senders of the transactions, which the inbox attribution needs
+	signer types.Signer
+	// blob base fee in wei, nil if unknown
+	blobBaseFee *big.Int
+}
+
+// Sender returns the sender of a transaction of the block.
+func (bl *BlobBlock) Sender(tx *types.Transaction) (common.Address, error) {
+	return types.Sender(bl.signer, tx)
+}
+
+var BlobGasUsedMetric = Metric[*BlobBlock]{
+	Name: "blob_gas_used",
+	Fn: func(bl *BlobBlock) (float64, error) {
+		if bl.BlobGasUsed() == nil {
+			return 0, nil
+		}
+		return float64(*bl.BlobGasUsed()), nil
+	},
+}
+
+var ExcessBlobGasMetric = Metric[*BlobBlock]{
+	Name: "excess_blob_gas",
+	Fn: func(bl *BlobBlock) (float64, error) {
+		if bl.ExcessBlobGas() == nil {
+			return 0, nil
+		}
+		return float64(*bl.ExcessBlobGas()), nil
+	},
+}
+
+var BlobBaseFeeMetric = Metric[*BlobBlock]{
+	Name: "blob_basefee",
+	Fn: func(bl *BlobBlock) (float64, error) {
+		return GweiFloat64(bl.blobBaseFee), nil
+	},
+}
+
+var BlockBlobsMetric = Metric[*BlobBlock]{
+	Name: "block_blobs",
+	Fn: func(bl *BlobBlock) (float64, error) {
+		n := 0
+		for _, tx := range bl.Transactions() {
+			n += len(tx.BlobHashes())
+		}
+		return float64(n), nil
+	},
+}
+
+var TxBlobsHistogram = Histogram[*BlobBlock](
+	"tx_blobs",
+	[]float64{1, 2, 3, 4, 5, 6},
+	func(bl *BlobBlock, add func(v float64)) error {
+		for _, tx := range bl.Transactions() {
+			if len(tx.BlobHashes()) > 0 {
+				add(float64(len(tx.BlobHashes())))
+			}
+		}
+		return nil
+	},
+)
+
+// MakeBlobStats attributes the blobs of each block, and their blob fees in gwei, to the rollup inboxes of the L1 chain,
+// like MakeInboxStats. Blob transactions are attributed by recipient and sender only:
+// the blobs are the data, whatever method is called.
+func MakeBlobStats(inboxes *Inboxes) AggregateMetric[*BlobBlock] {
+	names := inboxes.Labels("other")
+
+	perInbox := func(fn func(bl *BlobBlock, blobs int) float64) func(elem *BlobBlock, dest []float64) error {
+		return func(bl *BlobBlock, dest []float64) error {
+			for _, tx := range bl.Transactions() {
+				if len(tx.BlobHashes()) == 0 {
+					continue
+				}
+				dest[blobTxInboxIndex(inboxes, bl, tx, len(dest)-1)] += fn(bl, len(tx.BlobHashes()))
+			}
+			return nil
+		}
+	}
+	return CombineAggregates[*BlobBlock](
+		ParametrizedMetric[*BlobBlock]("blob_txs", "inbox", names, perInbox(func(bl *BlobBlock, blobs int) float64 {
+			return 1
+		})),
+		ParametrizedMetric[*BlobBlock]("blobs", "inbox", names, perInbox(func(bl *BlobBlock, blobs int) float64 {
+			return float64(blobs)
+		})),
+		ParametrizedMetric[*BlobBlock]("blob_fees", "inbox", names, perInbox(func(bl *BlobBlock, blobs int) float64 {
+			if bl.blobBaseFee == nil {
+				return 0
+			}
+			fee := new(big.Int).Mul(bl.blobBaseFee, new(big.Int).SetUint64(uint64(blobs)*blobGasPerBlob))
+			return GweiFloat64(fee)
+		})),
+	)
+}
+
+// blobTxInboxIndex returns the label index of the inbox that the transaction is attributed to,
+// the unauthorized sender index if no inbox accepts the sender, or otherIndex if it is not sent to an inbox.
+func blobTxInboxIndex(inboxes *Inboxes, bl *BlobBlock, tx *types.Transaction, otherIndex int) int {
+	i, _ := inboxes.Match(tx.To(), nil, false, func() (common.Address, error) {
+		return bl.Sender(tx)
+	})
+	if i == notInbox {
+		return otherIndex
+	}
+	return i
+}
+
+func BlobMetrics(inboxes *Inboxes) AggregateMetric[*BlobBlock] {
+	return CombineAggregates[*BlobBlock](
+		Aggregate[*BlobBlock](
+			BlobGasUsedMetric,
+			ExcessBlobGasMetric,
+			BlobBaseFeeMetric,
+			BlockBlobsMetric,
+		),
+		TxBlobsHistogram,
+		MakeBlobStats(inboxes),
+	)
+}
+
+// blobSource provides the finalized blocks of an execution RPC.
+// If it has a beacon client, the blob sidecars of the blocks are fetched as well.
+type blobSource struct {
+	blocks  *RPCBlockSource
+	config  *params.ChainConfig
+	minTime uint64
+	// whether to start at the first block with blobs, instead of at the min time
+	fromBlobs bool
+
+	beacon *BeaconClient
+	timing *BeaconTiming
+}
+
+func (s *blobSource) Finalized(ctx context.Context) (uint64, error) {
+	if s.beacon != nil && s.timing == nil {
+		timing, err := s.beacon.Timing(ctx)
+		if err != nil {
+			return 0, err
+		}
+		s.timing = &timing
+	}
+	h, err := s.blocks.HeaderByLabel(ctx, eth.Finalized)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get finalized block: %w", err)
+	}
+	return h.Number.Uint64(), nil
+}
+
+// Start searches the first block after the min time, that has the cancun blob fields if fromBlobs is set.
+func (s *blobSource) Start(ctx context.Context, finalized uint64) (uint64, bool, error) {
+	lo, hi := uint64(0), finalized+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		h, err := s.blocks.HeaderByNumber(ctx, mid)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to get block %d: %w", mid, err)
+		}
+		if (s.fromBlobs && h.BlobGasUsed == nil) || h.Time < s.minTime {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo <= finalized, nil
+}
+
+func (s *blobSource) Fetch(ctx context.Context, num uint64) (*BlobBlock, error) {
+	block, err := s.blocks.BlockByNumber(ctx, num)
+	if err != nil {
+		return nil, err
+	}
+	bl := &BlobBlock{
+		Block:       block,
+		signer:      types.LatestSignerForChainID(s.config.ChainID),
+		blobBaseFee: blobGasPrice(s.config, block.Header()),
+	}
+	if s.beacon != nil {
+		if err := s.fetchContents(ctx, bl); err != nil {
+			return nil, fmt.Errorf("failed to fetch blobs of block %d: %w", num, err)
+		}
+	}
+	return bl, nil
+}
+
+var errBlobSlot = errors.New("block time is not at the start of a slot")
+
+// fetchContents fetches the blob sidecars of the slot of the block, and checks that they belong to the block.
+func (s *blobSource) fetchContents(ctx context.Context, bl *BlobBlock) error {
+	hashes := make(map[common.Hash]struct{})
+	for _, tx := range bl.Transactions() {
+		for _, h := range tx.BlobHashes() {
+			hashes[h] = struct{}{}
+		}
+	}
+	if len(hashes) == 0 {
+		bl.Contents = map[common.Hash]BlobContent{}
+		return nil
+	}
+	t := bl.Time()
+	if t < s.timing.GenesisTime || (t-s.timing.GenesisTime)%s.timing.SecondsPerSlot != 0 {
+		return errBlobSlot
+	}
+	contents, err := fetchBlobContents(ctx, s.beacon, (t-s.timing.GenesisTime)/s.timing.SecondsPerSlot)
+	if err != nil {
+		return err
+	}
+	for h := range contents {
+		if _, ok := hashes[h]; !ok {
+			return fmt.Errorf("beacon node has blob %s, which is not in the block", h)
+		}
+	}
+	bl.Contents = contents
+	return nil
+}
+
+// NewBlobExporter creates an exporter of the blob metrics of the finalized blocks of the chain,
+// starting at the first block with blobs after the min time of the chain.
+// The content of the blobs is measured too, if the chain is configured to fetch the blob sidecars.
+func NewBlobExporter(ctx context.Context, log log.Logger, ch *Chain, victoria *VictoriaClient) *FinalizedExporter[*BlobBlock] {
+	src := &blobSource{blocks: NewRPCBlockSource(ch.EthRPC), config: ch.Config, minTime: ch.MinTime, fromBlobs: true}
+	m := BlobMetrics(ch.Inboxes)
+	if ch.BlobSidecars {
+		src.beacon = ch.Beacon
+		m = CombineAggregates[*BlobBlock](m, MakeBlobContentStats(ch.Inboxes))
+	}
+	blockNum := func(bl *BlobBlock) uint64 {
+		return bl.NumberU64()
+	}
+	// victoria-metrics expects millisecond timestamps
+	blockTime := func(bl *BlobBlock) int64 {
+		return int64(bl.Time()) * 1000
+	}
+	return NewFinalizedExporter[*BlobBlock](ctx, log, ch, victoria, "blobs", src, blockNum, blockTime, m)
+}
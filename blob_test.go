@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+	"math/big"
+	"testing"
+)
+
+func TestBlobGasPrice(t *testing.T) {
+	sepolia := params.SepoliaChainConfig
+	excess := uint64(20_000_000)
+	opConfig := &params.ChainConfig{
+		ChainID:      big.NewInt(10),
+		LondonBlock:  big.NewInt(0),
+		ShanghaiTime: new(uint64),
+		CancunTime:   new(uint64),
+		Optimism:     &params.OptimismConfig{EIP1559Elasticity: 6, EIP1559Denominator: 50},
+	}
+	tests := []struct {
+		name   string
+		config *params.ChainConfig
+		time   uint64
+		excess *uint64
+		// blob base fee in wei, 0 for nil
+		want int64
+	}{
+		{name: "before cancun", config: sepolia, time: *sepolia.CancunTime - 12},
+		{name: "cancun", config: sepolia, time: *sepolia.PragueTime - 12, excess: &excess, want: 399},
+		{name: "prague", config: sepolia, time: *sepolia.PragueTime, excess: &excess, want: 54},
+		{name: "bpo1", config: sepolia, time: *sepolia.BPO1Time, excess: &excess, want: 10},
+		{name: "bpo2", config: sepolia, time: *sepolia.BPO2Time, excess: &excess, want: 5},
+		{name: "op stack", config: opConfig, time: 1_765_000_000, excess: new(uint64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &types.Header{Number: big.NewInt(9_000_000), Time: tt.time, ExcessBlobGas: tt.excess}
+			got := blobGasPrice(tt.config, h)
+			if tt.want == 0 {
+				if got != nil {
+					t.Fatalf("got blob gas price %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Cmp(big.NewInt(tt.want)) != 0 {
+				t.Errorf("got blob gas price %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeBlobStats(t *testing.T) {
+	batcher, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox := common.Address{0xa}
+	inboxes := newInboxes([]Inbox{{Name: "a", Address: inbox, Senders: []common.Address{crypto.PubkeyToAddress(batcher.PublicKey)}}})
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	var nonce uint64
+	blobTx := func(key *ecdsa.PrivateKey, to common.Address, blobs int) *types.Transaction {
+		nonce++
+		tx, err := types.SignNewTx(key, signer, &types.BlobTx{ChainID: uint256.NewInt(1), Nonce: nonce, To: to, Gas: 21_000,
+			GasFeeCap: uint256.NewInt(1), BlobFeeCap: uint256.NewInt(1), BlobHashes: make([]common.Hash, blobs)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	plain, err := types.SignNewTx(batcher, signer, &types.DynamicFeeTx{ChainID: big.NewInt(1), To: &inbox, Gas: 21_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the method of blob transactions is not checked, and transactions without blobs are not counted
+	txs := []*types.Transaction{
+		blobTx(batcher, inbox, 2),
+		blobTx(other, inbox, 1),
+		blobTx(other, common.Address{0xb}, 3),
+		plain,
+	}
+	bl := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(100)}).WithBody(types.Body{Transactions: txs})
+
+	tests := []struct {
+		name        string
+		blobBaseFee *big.Int
+		// blob fee in gwei of a single blob
+		blobFee float64
+	}{
+		{name: "known fee", blobBaseFee: big.NewInt(params.GWei), blobFee: blobGasPerBlob},
+		{name: "unknown fee"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateValues(t, MakeBlobStats(inboxes), &BlobBlock{Block: bl, signer: signer, blobBaseFee: tt.blobBaseFee})
+			for _, w := range []struct {
+				inbox string
+				blobs float64
+			}{
+				{inbox: "a", blobs: 2},
+				{inbox: unauthorizedSenderLabel, blobs: 1},
+				{inbox: "other", blobs: 3},
+			} {
+				for name, want := range map[string]float64{
+					"blob_txs":  1,
+					"blobs":     w.blobs,
+					"blob_fees": w.blobs * tt.blobFee,
+				} {
+					key := formatLabeledMetric(name, []Label{{Key: "inbox", Value: w.inbox}})
+					if got[key] != want {
+						t.Errorf("got %s %v, want %v", key, got[key], want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-node/rollup/derive"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// size of a blob: 4096 field elements of 32 bytes
+	blobSize = 4096 * 32
+	// maximum number of bytes the OP Stack blob encoding fits in a blob:
+	// 4 field elements hold 127 bytes, and the first field element starts with a version byte and a 3-byte length
+	opBlobCapacity = (4*31+3)*1024 - 4
+	// version of the OP Stack blob encoding
+	opBlobEncodingVersion = 0
+)
+
+// kzgToVersionedHash computes the versioned hash of a blob from its KZG commitment, as defined by EIP-4844.
+func kzgToVersionedHash(commitment []byte) common.Hash {
+	h := sha256.Sum256(commitment)
+	h[0] = 0x01 // VERSIONED_HASH_VERSION_KZG
+	return h
+}
+
+// decodeOPBlob decodes a blob of the OP Stack blob encoding,
+// see https://specs.optimism.io/protocol/derivation.html#blob-encoding
+// Every field element carries 31 bytes of data, and 6 bits in its first byte.
+// The 6-bit chunks of every group of 4 field elements are reassembled into 3 more bytes.
+func decodeOPBlob(blob []byte) ([]byte, error) {
+	if len(blob) != blobSize {
+		return nil, fmt.Errorf("invalid blob size %d", len(blob))
+	}
+	if blob[1] != opBlobEncodingVersion {
+		return nil, fmt.Errorf("unknown blob encoding version %d", blob[1])
+	}
+	outputLen := int(blob[2])<<16 | int(blob[3])<<8 | int(blob[4])
+	if outputLen > opBlobCapacity {
+		return nil, fmt.Errorf("blob data length %d exceeds capacity", outputLen)
+	}
+	output := make([]byte, opBlobCapacity)
+	// the first field element only has 27 bytes of data, after the version and length
+	copy(output[0:27], blob[5:32])
+	opos, ipos := 28, 32
+	var encoded [4]byte
+	decodeFieldElement := func() (byte, error) {
+		// the two highest bits must be 0, to stay below the field modulus
+		if blob[ipos]&0b1100_0000 != 0 {
+			return 0, fmt.Errorf("invalid field element at %d", ipos)
+		}
+		copy(output[opos:], blob[ipos+1:ipos+32])
+		b := blob[ipos]
+		opos += 32
+		ipos += 32
+		return b, nil
+	}
+	reassemble := func() {
+		opos-- // the 4 field elements hold 127 bytes, not 128
+		x := (encoded[0] & 0b0011_1111) | ((encoded[1] & 0b0011_0000) << 2)
+		y := (encoded[1] & 0b0000_1111) | ((encoded[3] & 0b0000_1111) << 4)
+		z := (encoded[2] & 0b0011_1111) | ((encoded[3] & 0b0011_0000) << 2)
+		output[opos-32] = z
+		output[opos-32*2] = y
+		output[opos-32*3] = x
+	}
+	encoded[0] = blob[0]
+	var err error
+	for j := 1; j < 4; j++ {
+		if encoded[j], err = decodeFieldElement(); err != nil {
+			return nil, err
+		}
+	}
+	reassemble()
+	for i := 1; i < 1024 && opos < outputLen; i++ {
+		for j := 0; j < 4; j++ {
+			if encoded[j], err = decodeFieldElement(); err != nil {
+				return nil, err
+			}
+		}
+		reassemble()
+	}
+	for i := outputLen; i < len(output); i++ {
+		if output[i] != 0 {
+			return nil, fmt.Errorf("non-zero data at %d, after the data length %d", i, outputLen)
+		}
+	}
+	for ; ipos < len(blob); ipos++ {
+		if blob[ipos] != 0 {
+			return nil, fmt.Errorf("non-zero blob byte at %d, after the data", ipos)
+		}
+	}
+	return output[:outputLen], nil
+}
+
+// BlobContent is what the blob metrics need to know about the content of a blob.
+type BlobContent struct {
+	// number of bytes of data in the blob
+	Payload int
+	// number of bytes of data the blob could have held with its encoding
+	Capacity int
+	// number of OP Stack derivation frames, 0 if the blob is not of the OP Stack blob encoding
+	Frames int
+
+	// the OP Stack derivation frames, for the batcher metrics
+	frames []derive.Frame
+}
+
+// analyzeBlob measures the content of a blob. Blobs of the OP Stack encoding are decoded,
+// for other encodings the payload is the blob up to its last non-zero byte.
+func analyzeBlob(blob []byte) BlobContent {
+	if data, err := decodeOPBlob(blob); err == nil {
+		out := BlobContent{Payload: len(data), Capacity: opBlobCapacity}
+		if frames, err := derive.ParseFrames(data); err == nil {
+			out.Frames = len(frames)
+			out.frames = frames
+		}
+		return out
+	}
+	end := len(blob)
+	for end > 0 && blob[end-1] == 0 {
+		end--
+	}
+	return BlobContent{Payload: end, Capacity: len(blob)}
+}
+
+// fetchBlobContents fetches the blob sidecars of the slot, and measures the blobs, by versioned hash.
+// Blobs that the beacon node does not have anymore are missing from the result.
+func fetchBlobContents(ctx context.Context, cl *BeaconClient, slot uint64) (map[common.Hash]BlobContent, error) {
+	sidecars, err := cl.BlobSidecars(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[common.Hash]BlobContent, len(sidecars))
+	for _, sc := range sidecars {
+		if len(sc.Blob) != blobSize {
+			return nil, fmt.Errorf("blob %d of slot %d has invalid size %d", sc.Index, slot, len(sc.Blob))
+		}
+		out[kzgToVersionedHash(sc.KZGCommitment)] = analyzeBlob(sc.Blob)
+	}
+	return out, nil
+}
+
+// MakeBlobContentStats attributes the measured content of the blobs of each block to the rollup inboxes of the L1 chain.
+// Blobs that are not available from the beacon node are only counted.
+func MakeBlobContentStats(inboxes *Inboxes) AggregateMetric[*BlobBlock] {
+	names := inboxes.Labels("other")
+
+	perInbox := func(fn func(c BlobContent) float64) func(elem *BlobBlock, dest []float64) error {
+		return func(bl *BlobBlock, dest []float64) error {
+			for _, tx := range bl.Transactions() {
+				i := blobTxInboxIndex(inboxes, bl, tx, len(dest)-1)
+				for _, h := range tx.BlobHashes() {
+					if c, ok := bl.Contents[h]; ok {
+						dest[i] += fn(c)
+					}
+				}
+			}
+			return nil
+		}
+	}
+	return CombineAggregates[*BlobBlock](
+		ParametrizedMetric[*BlobBlock]("blob_payload_bytes", "inbox", names, perInbox(func(c BlobContent) float64 {
+			return float64(c.Payload)
+		})),
+		ParametrizedMetric[*BlobBlock]("blob_wasted_bytes", "inbox", names, perInbox(func(c BlobContent) float64 {
+			return float64(c.Capacity - c.Payload)
+		})),
+		ParametrizedMetric[*BlobBlock]("blob_frames", "inbox", names, perInbox(func(c BlobContent) float64 {
+			return float64(c.Frames)
+		})),
+		Histogram[*BlobBlock]("blob_payload", []float64{1_000, 10_000, 50_000, 100_000, 120_000, opBlobCapacity},
+			func(bl *BlobBlock, add func(v float64)) error {
+				for _, c := range bl.Contents {
+					add(float64(c.Payload))
+				}
+				return nil
+			}),
+		Aggregate[*BlobBlock](Metric[*BlobBlock]{
+			Name: "blob_unavailable",
+			Fn: func(bl *BlobBlock) (float64, error) {
+				missing := 0
+				for _, tx := range bl.Transactions() {
+					for _, h := range tx.BlobHashes() {
+						if _, ok := bl.Contents[h]; !ok {
+							missing++
+						}
+					}
+				}
+				return float64(missing), nil
+			},
+		}),
+	)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDecodeOPBlob(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rng.Read(data)
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+		// modifies the blob after encoding the data with the reference encoder of the OP Stack
+		modify  func(blob []byte)
+		wantErr string
+	}{
+		{name: "empty", data: []byte{}},
+		{name: "first field element", data: random(27)},
+		{name: "partial round", data: random(200)},
+		{name: "full", data: random(opBlobCapacity)},
+		{
+			name:    "unknown version",
+			data:    random(10),
+			modify:  func(blob []byte) { blob[1] = 1 },
+			wantErr: "unknown blob encoding version 1",
+		},
+		{
+			name:    "length exceeds capacity",
+			data:    random(10),
+			modify:  func(blob []byte) { blob[2], blob[3], blob[4] = 0xff, 0xff, 0xff },
+			wantErr: "exceeds capacity",
+		},
+		{
+			name:    "field element above the modulus",
+			data:    random(100),
+			modify:  func(blob []byte) { blob[32] |= 0b1000_0000 },
+			wantErr: "invalid field element at 32",
+		},
+		{
+			name:    "data after the length",
+			data:    random(100),
+			modify:  func(blob []byte) { blob[4] = 50 },
+			wantErr: "after the data length 50",
+		},
+		{
+			name:    "blob bytes after the data",
+			data:    random(100),
+			modify:  func(blob []byte) { blob[blobSize-1] = 1 },
+			wantErr: "after the data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var blob eth.Blob
+			if err := blob.FromData(tt.data); err != nil {
+				t.Fatal(err)
+			}
+			if tt.modify != nil {
+				tt.modify(blob[:])
+			}
+			got, err := decodeOPBlob(blob[:])
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("got %d bytes, want %d bytes", len(got), len(tt.data))
+			}
+		})
+	}
+	if _, err := decodeOPBlob(make([]byte, blobSize-1)); err == nil || !strings.Contains(err.Error(), "invalid blob size") {
+		t.Errorf("got error %v for a short blob", err)
+	}
+}
+
+func TestAnalyzeBlob(t *testing.T) {
+	var opBlob eth.Blob
+	if err := opBlob.FromData(bytes.Repeat([]byte{1}, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	other := make([]byte, blobSize)
+	other[0], other[5000] = 0xff, 1
+
+	tests := []struct {
+		name string
+		blob []byte
+		want BlobContent
+	}{
+		{name: "op stack encoding without frames", blob: opBlob[:], want: BlobContent{Payload: 1000, Capacity: opBlobCapacity}},
+		{name: "other encoding", blob: other, want: BlobContent{Payload: 5001, Capacity: blobSize}},
+		{name: "zero blob", blob: make([]byte, blobSize), want: BlobContent{Payload: 0, Capacity: opBlobCapacity}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := analyzeBlob(tt.blob)
+			if got.Payload != tt.want.Payload || got.Capacity != tt.want.Capacity || got.Frames != tt.want.Frames {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// rpcBlockBody is the part of the eth_getBlockBy* response that is not covered by the header JSON decoding.
+type rpcBlockBody struct {
+	Hash         common.Hash          `json:"hash"`
+	Transactions []*types.Transaction `json:"transactions"`
+	Withdrawals  types.Withdrawals    `json:"withdrawals,omitempty"`
+}
+
+// BlockSource provides canonical blocks by number, e.g. for backfilling.
+type BlockSource interface {
+	// LatestNumber returns the number of the latest block that is available from the source.
+	LatestNumber(ctx context.Context) (uint64, error)
+	// BlockByNumber returns the canonical block with the given number,
+	// or ethereum.NotFound if the source does not have it.
+	BlockByNumber(ctx context.Context, num uint64) (*types.Block, error)
+}
+
+// RPCBlockSource fetches full blocks over JSON-RPC.
+// Unlike the op-node EthClient it keeps the withdrawals, which some of the block metrics need.
+// Uncles are not fetched, these do not exist anymore post-Merge.
+type RPCBlockSource struct {
+	rpc client.RPC
+}
+
+func NewRPCBlockSource(rpc client.RPC) *RPCBlockSource {
+	return &RPCBlockSource{rpc: rpc}
+}
+
+var _ BlockSource = (*RPCBlockSource)(nil)
+
+func (s *RPCBlockSource) LatestNumber(ctx context.Context) (uint64, error) {
+	var num hexutil.Uint64
+	if err := s.rpc.CallContext(ctx, &num, "eth_blockNumber"); err != nil {
+		return 0, err
+	}
+	return uint64(num), nil
+}
+
+func (s *RPCBlockSource) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	bl, err := s.blockCall(ctx, "eth_getBlockByHash", hash)
+	if err != nil {
+		return nil, err
+	}
+	if bl.Hash() != hash {
+		return nil, fmt.Errorf("expected block %s but got %s", hash, bl.Hash())
+	}
+	return bl, nil
+}
+
+func (s *RPCBlockSource) BlockByNumber(ctx context.Context, num uint64) (*types.Block, error) {
+	bl, err := s.blockCall(ctx, "eth_getBlockByNumber", hexutil.Uint64(num))
+	if err != nil {
+		return nil, err
+	}
+	if bl.NumberU64() != num {
+		return nil, fmt.Errorf("expected block %d but got %d", num, bl.NumberU64())
+	}
+	return bl, nil
+}
+
+func (s *RPCBlockSource) BlockByLabel(ctx context.Context, label eth.BlockLabel) (*types.Block, error) {
+	return s.blockCall(ctx, "eth_getBlockByNumber", label.Arg())
+}
+
+// HeaderByNumber returns the header of the canonical block with the given number, without fetching the transactions.
+func (s *RPCBlockSource) HeaderByNumber(ctx context.Context, num uint64) (*types.Header, error) {
+	h, err := s.headerCall(ctx, hexutil.Uint64(num))
+	if err != nil {
+		return nil, err
+	}
+	if h.Number.Uint64() != num {
+		return nil, fmt.Errorf("expected block %d but got %d", num, h.Number.Uint64())
+	}
+	return h, nil
+}
+
+func (s *RPCBlockSource) HeaderByLabel(ctx context.Context, label eth.BlockLabel) (*types.Header, error) {
+	return s.headerCall(ctx, label.Arg())
+}
+
+func (s *RPCBlockSource) headerCall(ctx context.Context, id any) (*types.Header, error) {
+	var raw json.RawMessage
+	if err := s.rpc.CallContext(ctx, &raw, "eth_getBlockByNumber", id, false); err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+	var header types.Header
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode block header: %w", err)
+	}
+	var rpcHash struct {
+		Hash common.Hash `json:"hash"`
+	}
+	if err := json.Unmarshal(raw, &rpcHash); err != nil {
+		return nil, fmt.Errorf("failed to decode block hash: %w", err)
+	}
+	if header.Hash() != rpcHash.Hash {
+		return nil, fmt.Errorf("failed to verify block hash: computed %s but RPC said %s", header.Hash(), rpcHash.Hash)
+	}
+	return &header, nil
+}
+
+func (s *RPCBlockSource) blockCall(ctx context.Context, method string, id any) (*types.Block, error) {
+	var raw json.RawMessage
+	if err := s.rpc.CallContext(ctx, &raw, method, id, true); err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+	var header types.Header
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode block header: %w", err)
+	}
+	var body rpcBlockBody
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode block body: %w", err)
+	}
+	bl := types.NewBlockWithHeader(&header).WithBody(types.Body{Transactions: body.Transactions, Withdrawals: body.Withdrawals})
+	// The hash is what we track the chain with, so it has to match what the RPC claims the block is.
+	if bl.Hash() != body.Hash {
+		return nil, fmt.Errorf("failed to verify block hash: computed %s but RPC said %s", bl.Hash(), body.Hash)
+	}
+	return bl, nil
+}
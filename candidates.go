@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// number of buckets of the rolling window: the window moves, and candidates are reported, per bucket
+	candidateBuckets = 24
+	// minimum calldata size of a transaction without blobs to count towards the volume of its recipient:
+	// inbox transactions are large, and this avoids tracking every address
+	candidateMinCalldata = 1024
+	// 1 day of 12 second blocks
+	defaultCandidateWindow = 7200
+)
+
+// label value of candidates of which the top transactions have no method selector
+const noSelectorLabel = "none"
+
+type candidateKey struct {
+	to       common.Address
+	selector string
+	sender   common.Address
+}
+
+// candidateTotals is the data volume of an address within the window, by selector and by sender.
+type candidateTotals struct {
+	bytes     uint64
+	selectors map[string]uint64
+	senders   map[common.Address]uint64
+}
+
+// topKey returns the key with the most bytes, the smallest key on ties, to report consistently.
+func topKey[K comparable](m map[K]uint64, less func(a, b K) bool) (top K) {
+	var best uint64
+	for k, v := range m {
+		if v > best || (v == best && less(k, top)) {
+			top, best = k, v
+		}
+	}
+	return top
+}
+
+// CandidateDetector tracks the data volume, calldata and blobs, sent to addresses that are not in the inbox registry,
+// over a rolling window of blocks. Addresses of which the volume reaches the threshold are reported as inbox candidates,
+// with the method selector and sender of most of their data, to promote them into the inbox registry.
+// The blocks must be processed in order. The window is kept in memory, and starts empty.
+type CandidateDetector struct {
+	log      log.Logger
+	ch       *Chain
+	victoria *VictoriaClient
+
+	threshold  uint64
+	bucketSize uint64
+
+	// volumes per bucket, oldest first, the last bucket is the current one
+	buckets     []map[candidateKey]uint64
+	bucketStart uint64
+	totals      map[common.Address]*candidateTotals
+	// time of the last processed block
+	lastTime uint64
+	// the candidates of the last report, to only log new candidates
+	reported map[common.Address]struct{}
+}
+
+func NewCandidateDetector(log log.Logger, ch *Chain, victoria *VictoriaClient, threshold uint64, window uint64) *CandidateDetector {
+	bucketSize := window / candidateBuckets
+	if bucketSize == 0 {
+		bucketSize = 1
+	}
+	return &CandidateDetector{
+		log:        log,
+		ch:         ch,
+		victoria:   victoria,
+		threshold:  threshold,
+		bucketSize: bucketSize,
+		totals:     make(map[common.Address]*candidateTotals),
+		reported:   make(map[common.Address]struct{}),
+	}
+}
+
+// Process adds the block to the window, and reports the candidates when the block starts a new bucket.
+// Reporting failures are logged, the detection continues regardless.
+func (d *CandidateDetector) Process(ctx context.Context, bl *BlobBlock) {
+	num := bl.NumberU64()
+	if d.buckets == nil {
+		d.buckets = []map[candidateKey]uint64{make(map[candidateKey]uint64)}
+		d.bucketStart = num
+	}
+	if num >= d.bucketStart+d.bucketSize {
+		if err := d.report(ctx); err != nil {
+			d.log.Warn("failed to report inbox candidates", "block", num, "err", err)
+		}
+		d.buckets = append(d.buckets, make(map[candidateKey]uint64))
+		if len(d.buckets) > candidateBuckets {
+			d.evict(d.buckets[0])
+			d.buckets = d.buckets[1:]
+		}
+		d.bucketStart = num
+	}
+	d.lastTime = bl.Time()
+	for _, tx := range bl.Transactions() {
+		to, input, blobs := tx.To(), tx.Data(), tx.BlobHashes()
+		if to == nil || len(blobs) == 0 && len(input) < candidateMinCalldata {
+			continue
+		}
+		if i, _ := d.ch.Inboxes.Match(to, nil, false, func() (common.Address, error) {
+			return bl.Sender(tx)
+		}); i != notInbox {
+			continue
+		}
+		from, err := bl.Sender(tx)
+		if err != nil {
+			d.log.Warn("failed to recover sender of inbox candidate", "block", num, "tx", tx.Hash(), "err", err)
+			continue
+		}
+		size := uint64(len(input)) + uint64(len(blobs))*blobSize
+		key := candidateKey{to: *to, selector: noSelectorLabel, sender: from}
+		if len(input) >= 4 {
+			key.selector = hexutil.Encode(input[:4])
+		}
+		d.buckets[len(d.buckets)-1][key] += size
+		t, ok := d.totals[key.to]
+		if !ok {
+			t = &candidateTotals{selectors: make(map[string]uint64), senders: make(map[common.Address]uint64)}
+			d.totals[key.to] = t
+		}
+		t.bytes += size
+		t.selectors[key.selector] += size
+		t.senders[key.sender] += size
+	}
+}
+
+// evict removes the volumes of a bucket that left the window from the totals.
+func (d *CandidateDetector) evict(bucket map[candidateKey]uint64) {
+	for key, size := range bucket {
+		t := d.totals[key.to]
+		t.bytes -= size
+		if t.bytes == 0 {
+			delete(d.totals, key.to)
+			continue
+		}
+		if t.selectors[key.selector] -= size; t.selectors[key.selector] == 0 {
+			delete(t.selectors, key.selector)
+		}
+		if t.senders[key.sender] -= size; t.senders[key.sender] == 0 {
+			delete(t.senders, key.sender)
+		}
+	}
+}
+
+// report writes the calldata_candidates metric, the window volume of every candidate,
+// labeled by address, and the top selector and sender. Like the reorg metrics, these are not labeled by metrics hour.
+func (d *CandidateDetector) report(ctx context.Context) error {
+	var out []byte
+	current := make(map[common.Address]struct{})
+	for addr, t := range d.totals {
+		if t.bytes < d.threshold {
+			continue
+		}
+		current[addr] = struct{}{}
+		selector := topKey(t.selectors, func(a, b string) bool { return a < b })
+		sender := topKey(t.senders, func(a, b common.Address) bool { return a.Hex() < b.Hex() })
+		if _, ok := d.reported[addr]; !ok {
+			d.log.Info("new inbox candidate", "address", addr, "bytes", t.bytes,
+				"selector", selector, "sender", sender, "senders", len(t.senders))
+		}
+		var err error
+		out, err = appendImportLine(out, "calldata_candidates", map[string]string{
+			ChainLabel: d.ch.Name,
+			"address":  addr.Hex(),
+			"selector": selector,
+			"sender":   sender.Hex(),
+		}, float64(t.bytes), int64(d.lastTime)*1000)
+		if err != nil {
+			return err
+		}
+	}
+	d.reported = current
+	if len(out) == 0 {
+		return nil
+	}
+	return d.victoria.Import(ctx, out)
+}
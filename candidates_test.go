@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type candidateReport struct {
+	Metric map[string]string `json:"metric"`
+	Values []float64         `json:"values"`
+}
+
+func TestCandidateDetector(t *testing.T) {
+	var mu sync.Mutex
+	// the candidate reports, by block that triggered the report
+	var reports [][]candidateReport
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("failed to decompress body: %v", err)
+			return
+		}
+		data, err := io.ReadAll(gr)
+		if err != nil {
+			t.Errorf("failed to read body: %v", err)
+		}
+		var report []candidateReport
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var line candidateReport
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Errorf("failed to decode report: %v", err)
+			}
+			report = append(report, line)
+		}
+		mu.Lock()
+		reports = append(reports, report)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	logger := log.NewLogger(log.DiscardHandler())
+	victoria, err := NewVictoriaClient(logger, &DBConfig{Victoria: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyA, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyB, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	inbox := common.Address{0x10}
+	candidate := common.Address{0x20}
+	small := common.Address{0x30}
+	var nonce uint64
+	tx := func(t *testing.T, key *ecdsa.PrivateKey, to common.Address, selector []byte, size int) *types.Transaction {
+		data := append(append([]byte(nil), selector...), make([]byte, size-len(selector))...)
+		nonce++
+		signed, err := types.SignNewTx(key, signer, &types.LegacyTx{Nonce: nonce, To: &to, Gas: 1_000_000, GasPrice: big.NewInt(1), Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	selectorA, selectorB := []byte{0x12, 0x34, 0x56, 0x78}, []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	// the candidate receives data in blocks 0 to 2, the known inbox and the small transactions are not tracked
+	txs := map[uint64][]*types.Transaction{
+		0: {tx(t, keyA, candidate, selectorA, 2000), tx(t, keyA, inbox, selectorA, 2000), tx(t, keyA, small, selectorA, 100)},
+		1: {tx(t, keyB, candidate, selectorB, 1500)},
+		2: {tx(t, keyA, candidate, selectorA, 2000)},
+	}
+
+	ch := &Chain{Name: "test", Inboxes: newInboxes([]Inbox{{Name: "known", Address: inbox}})}
+	d := NewCandidateDetector(logger, ch, victoria, 5000, 24)
+	for num := uint64(0); num < 30; num++ {
+		bl := types.NewBlockWithHeader(&types.Header{Number: new(big.Int).SetUint64(num), Time: 1000 + num*12}).
+			WithBody(types.Body{Transactions: txs[num]})
+		d.Process(context.Background(), &BlobBlock{Block: bl, signer: signer})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// reported from block 3, which starts the bucket after block 2, until block 24, when block 0 leaves the window of 24 blocks
+	if len(reports) != 22 {
+		t.Fatalf("got %d reports, want 22", len(reports))
+	}
+	for i, report := range reports {
+		if len(report) != 1 {
+			t.Fatalf("report %d: got %d candidates, want 1", i, len(report))
+		}
+		c := report[0]
+		if c.Metric["address"] != candidate.Hex() || c.Metric["selector"] != "0x12345678" ||
+			c.Metric["sender"] != crypto.PubkeyToAddress(keyA.PublicKey).Hex() || c.Metric["chain"] != "test" {
+			t.Errorf("report %d: got candidate %v", i, c.Metric)
+		}
+		if len(c.Values) != 1 || c.Values[0] != 5500 {
+			t.Errorf("report %d: got volume %v, want 5500", i, c.Values)
+		}
+	}
+}
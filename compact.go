@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum/go-ethereum/log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// how often finalized metrics hours are compacted
+const compactionInterval = 10 * time.Minute
+
+// Compactor merges the series of finalized metrics hours into series without the metrics-hour label,
+// to keep the cardinality low. Finalized hours cannot be reorged anymore, so they don't need to be deletable.
+// The highest compacted hour is kept in the chain database, so the exporter can refuse reorgs into compacted hours.
+//
+// It is run by the ChainExporter, so no data of the chain is written while an hour is being compacted.
+// Data that is exported into a compacted hour later, e.g. by the backfiller, is merged by the next compaction.
+type Compactor struct {
+	log      log.Logger
+	ch       *Chain
+	victoria *VictoriaClient
+}
+
+func NewCompactor(log log.Logger, ch *Chain, victoria *VictoriaClient) *Compactor {
+	return &Compactor{log: log, ch: ch, victoria: victoria}
+}
+
+// Compact compacts all metrics hours before the hour of the finalized block.
+func (c *Compactor) Compact(ctx context.Context) error {
+	// finish any compaction that was interrupted
+	cp, err := c.ch.DB.CompactionCheckpoint()
+	if err != nil {
+		return fmt.Errorf("failed to read compaction checkpoint: %w", err)
+	}
+	if cp != nil {
+		c.log.Info("resuming compaction", "hour", cp.Hour, "imported", cp.Imported)
+		if err := c.compactHour(ctx, cp); err != nil {
+			return fmt.Errorf("failed to resume compaction of hour %d: %w", cp.Hour, err)
+		}
+	}
+
+	finalized, err := c.ch.Blocks.BlockByLabel(ctx, eth.Finalized)
+	if err != nil {
+		return fmt.Errorf("failed to get finalized block: %w", err)
+	}
+	// the hour of the finalized block itself may still get new blocks that can be reorged
+	limit := MetricsHour(int64(finalized.Time()) * 1000)
+
+	values, err := c.victoria.LabelValues(ctx, MetricsHourLabel, c.selector())
+	if err != nil {
+		return fmt.Errorf("failed to get metrics hours: %w", err)
+	}
+	var hours []int64
+	for _, v := range values {
+		hour, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.log.Warn("ignoring invalid metrics hour", "value", v)
+			continue
+		}
+		if hour < limit {
+			hours = append(hours, hour)
+		}
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i] < hours[j] })
+	for _, hour := range hours {
+		if err := c.compactHour(ctx, &CompactionCheckpoint{Hour: hour}); err != nil {
+			return fmt.Errorf("failed to compact hour %d: %w", hour, err)
+		}
+	}
+	if len(hours) > 0 {
+		c.log.Info("compacted metrics hours", "first", hours[0], "last", hours[len(hours)-1], "finalized", eth.ToBlockID(finalized))
+	}
+	return nil
+}
+
+func (c *Compactor) selector() string {
+	return fmt.Sprintf(`{%s=%q}`, ChainLabel, c.ch.Name)
+}
+
+func (c *Compactor) hourSelector(hour int64) string {
+	return fmt.Sprintf(`{%s=%q,%s="%d"}`, ChainLabel, c.ch.Name, MetricsHourLabel, hour)
+}
+
+// compactHour merges the series of the hour, and then deletes the originals.
+// The checkpoint is updated in between, so the originals are never deleted before the merged data is imported,
+// and a resumed compaction does not import the merged data again once the import completed.
+// If the process stops during the import, the hour is merged again on resume,
+// and the identical samples are removed by VictoriaMetrics deduplication, if enabled.
+func (c *Compactor) compactHour(ctx context.Context, cp *CompactionCheckpoint) error {
+	if !cp.Imported {
+		if err := c.ch.DB.SetCompactionCheckpoint(cp); err != nil {
+			return fmt.Errorf("failed to store compaction checkpoint: %w", err)
+		}
+		data, err := c.victoria.Export(ctx, c.hourSelector(cp.Hour))
+		if err != nil {
+			return fmt.Errorf("failed to read back series: %w", err)
+		}
+		merged, err := withoutLabel(data, MetricsHourLabel)
+		if err != nil {
+			return err
+		}
+		if len(merged) > 0 {
+			if err := c.victoria.Import(ctx, merged); err != nil {
+				return fmt.Errorf("failed to import merged series: %w", err)
+			}
+		}
+		cp = &CompactionCheckpoint{Hour: cp.Hour, Imported: true}
+		if err := c.ch.DB.SetCompactionCheckpoint(cp); err != nil {
+			return fmt.Errorf("failed to store compaction checkpoint: %w", err)
+		}
+	}
+	if err := c.victoria.DeleteSeries(ctx, c.hourSelector(cp.Hour)); err != nil {
+		return fmt.Errorf("failed to delete original series: %w", err)
+	}
+	// the exporter cannot roll back reorgs into compacted hours
+	if err := c.ch.DB.SetCompactedHour(cp.Hour); err != nil {
+		return fmt.Errorf("failed to store compacted hour: %w", err)
+	}
+	return c.ch.DB.SetCompactionCheckpoint(nil)
+}
+
+// withoutLabel removes the label from every series in the JSON-lines data
+func withoutLabel(data []byte, label string) ([]byte, error) {
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry struct {
+			Metric     map[string]string `json:"metric"`
+			Values     json.RawMessage   `json:"values"`
+			Timestamps json.RawMessage   `json:"timestamps"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode exported series: %w", err)
+		}
+		delete(entry.Metric, label)
+		if err := enc.Encode(&entry); err != nil {
+			return nil, fmt.Errorf("failed to encode merged series: %w", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read exported series: %w", err)
+	}
+	return out.Bytes(), nil
+}
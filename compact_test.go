@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// fakeVictoria keeps the series of every metrics hour, and records the imports of merged series
+type fakeVictoria struct {
+	mu sync.Mutex
+	// JSON-lines series by metrics hour
+	hours map[string][]byte
+	// number of imported merged series, by the metrics hour they came from
+	merged map[string]int
+	// number of delete requests that are rejected before they succeed
+	failDeletes int
+}
+
+var hourMatch = regexp.MustCompile(`mh="(\d+)"`)
+
+func (v *fakeVictoria) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch r.URL.Path {
+	case "/api/v1/label/" + MetricsHourLabel + "/values":
+		var hours []string
+		for hour := range v.hours {
+			hours = append(hours, hour)
+		}
+		sort.Strings(hours)
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": hours})
+	case "/api/v1/export":
+		m := hourMatch.FindStringSubmatch(r.URL.Query().Get("match[]"))
+		_, _ = w.Write(v.hours[m[1]])
+	case "/api/v1/import":
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(gr)
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var entry struct {
+				Metric map[string]string `json:"metric"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if _, ok := entry.Metric[MetricsHourLabel]; ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			v.merged[entry.Metric["source"]]++
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "/api/v1/admin/tsdb/delete_series":
+		if v.failDeletes > 0 {
+			v.failDeletes--
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m := hourMatch.FindStringSubmatch(r.URL.Query().Get("match[]"))
+		delete(v.hours, m[1])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestCompactor(t *testing.T) {
+	const finalizedHour = 103
+	tests := []struct {
+		name        string
+		checkpoint  *CompactionCheckpoint
+		failDeletes int
+		// number of merged imports of every hour
+		wantMerged map[string]int
+	}{
+		{name: "finalized hours", wantMerged: map[string]int{"100": 1, "101": 1}},
+		{name: "resume after the import", checkpoint: &CompactionCheckpoint{Hour: 100, Imported: true}, wantMerged: map[string]int{"101": 1}},
+		{name: "resume during the import", checkpoint: &CompactionCheckpoint{Hour: 100}, wantMerged: map[string]int{"100": 1, "101": 1}},
+		{name: "failed delete", failDeletes: 1, wantMerged: map[string]int{"100": 1, "101": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &fakeVictoria{hours: make(map[string][]byte), merged: make(map[string]int), failDeletes: tt.failDeletes}
+			for _, hour := range []string{"100", "101", fmt.Sprint(finalizedHour)} {
+				v.hours[hour] = []byte(fmt.Sprintf(`{"metric":{"__name__":"x","chain":"test","mh":%q,"source":%q},"values":[1],"timestamps":[1]}`+"\n", hour, hour))
+			}
+			srv := httptest.NewServer(v)
+			defer srv.Close()
+			logger := log.NewLogger(log.DiscardHandler())
+			victoria, err := NewVictoriaClient(logger, &DBConfig{Victoria: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if err := db.SetCompactionCheckpoint(tt.checkpoint); err != nil {
+				t.Fatal(err)
+			}
+			finalized := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1000), Difficulty: new(big.Int), Time: finalizedHour*60*60 + 5})
+			c := &chainRPC{byHash: map[common.Hash]*types.Block{finalized.Hash(): finalized}, head: finalized}
+			ch := &Chain{Name: "test", DB: db, Blocks: NewRPCBlockSource(c)}
+			compactor := NewCompactor(logger, ch, victoria)
+
+			ctx := context.Background()
+			for i := 0; i < tt.failDeletes; i++ {
+				if err := compactor.Compact(ctx); err == nil {
+					t.Fatal("expected the rejected delete to fail the compaction")
+				}
+				// the merged series were imported, so the resumed compaction only deletes the originals
+				if cp, err := db.CompactionCheckpoint(); err != nil || cp == nil || !cp.Imported {
+					t.Fatalf("got checkpoint %+v %v, want an imported checkpoint", cp, err)
+				}
+			}
+			if err := compactor.Compact(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(v.merged) != len(tt.wantMerged) {
+				t.Errorf("got merged %v, want %v", v.merged, tt.wantMerged)
+			}
+			for hour, n := range tt.wantMerged {
+				if v.merged[hour] != n {
+					t.Errorf("got merged %v, want %v", v.merged, tt.wantMerged)
+				}
+			}
+			if len(v.hours) != 1 || v.hours[fmt.Sprint(finalizedHour)] == nil {
+				t.Errorf("got hours %v left, want only the finalized hour", v.hours)
+			}
+			if cp, err := db.CompactionCheckpoint(); err != nil || cp != nil {
+				t.Errorf("got checkpoint %+v %v, want none", cp, err)
+			}
+			if hour, ok, err := db.CompactedHour(); err != nil || !ok || hour != 101 {
+				t.Errorf("got compacted hour %d %v %v, want 101", hour, ok, err)
+			}
+		})
+	}
+}
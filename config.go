@@ -3,28 +3,55 @@ package main
 import (
 	"context"
 	"fmt"
-	"github.com/ethereum-optimism/optimism/op-node/client"
-	"github.com/ethereum-optimism/optimism/op-node/sources"
+	"github.com/ethereum-optimism/optimism/op-node/rollup"
+	"github.com/ethereum-optimism/optimism/op-service/client"
+	"github.com/ethereum-optimism/optimism/op-service/sources"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"path/filepath"
 	"sort"
 	"time"
 )
 
 type DBConfig struct {
 	Victoria string `yaml:"victoria"`
+	// optional basic auth
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// optional bearer token auth, cannot be combined with basic auth
+	BearerToken string `yaml:"bearer_token"`
 }
 
 type ChainConfig struct {
 	EthRPC  string `yaml:"eth_rpc"`
+	RPCKind string `yaml:"rpc_kind"`
 	OpRPC   string `yaml:"op_rpc"`
 	L1      string `yaml:"l1"`
-	Type    string `yaml:"type"`
-	MinTime uint64 `yaml:"min_time"`
+	// optional directory of beacon chain era files, to read historical ethereum blocks from
+	BeaconEra string `yaml:"beacon_era"`
+	// optional directory of era1 files, to read pre-merge ethereum blocks and receipts from
+	Era1 string `yaml:"era1"`
+	// optional beacon node REST API, to export consensus-layer metrics of ethereum chains from
+	BeaconAPI string `yaml:"beacon_api"`
+	// whether to fetch the blob sidecars from the beacon API, to measure the content of blobs
+	BlobSidecars bool   `yaml:"blob_sidecars"`
+	Type         string `yaml:"type"`
+	MinTime      uint64 `yaml:"min_time"`
+	// number of blocks after which a block is considered final, defaults depend on the chain type
+	FinalityDepth uint64 `yaml:"finality_depth"`
+	// optional, data bytes per window after which an address that is not a known inbox is reported as inbox candidate
+	CandidateThreshold uint64 `yaml:"candidate_threshold"`
+	// number of blocks of the inbox candidate window, defaults to a day
+	CandidateWindow uint64 `yaml:"candidate_window"`
 }
 
 type Config struct {
-	DB     DBConfig                `yaml:"db"`
-	Chains map[string]*ChainConfig `yaml:"chains"`
+	DB DBConfig `yaml:"db"`
+	// directory to keep the local chain databases in
+	DataDir string `yaml:"data_dir"`
+	// optional YAML or JSON file of rollup inboxes, defaults to the built-in registry
+	Inboxes string                  `yaml:"inboxes"`
+	Chains  map[string]*ChainConfig `yaml:"chains"`
 }
 
 type ChainType string
@@ -34,6 +61,20 @@ const (
 	OPStackChain  ChainType = "opstack"
 )
 
+const defaultDataDir = "data"
+
+// DefaultFinalityDepth is a conservative number of blocks after which blocks of the chain type are final.
+func (typ ChainType) DefaultFinalityDepth() uint64 {
+	switch typ {
+	case EthereumChain:
+		return 2 * 32 // 2 epochs
+	case OPStackChain:
+		return 1800 // 1 hour of 2 second blocks, L2 finality trails behind L1 finality
+	default:
+		return 1000
+	}
+}
+
 func ParseChainType(name string) (ChainType, error) {
 	x := ChainType(name)
 	switch x {
@@ -48,21 +89,44 @@ type Chain struct {
 	Name string
 	Type ChainType
 
-	EthRPC client.RPC
-	EthCl  *sources.EthClient
+	EthRPC   client.RPC
+	EthCl    *sources.EthClient
+	Config   *params.ChainConfig
+	Blocks   *RPCBlockSource
+	Receipts ReceiptsSource
+	// source of historical blocks, for backfilling
+	History BlockSource
+
+	OpRPC        client.RPC
+	OpCl         *sources.RollupClient
+	RollupConfig *rollup.Config
+	// matches user deposits to their L1 events, nil without a linked L1 chain
+	Deposits *DepositMatcher
+	// withdrawals of the chain that are not finalized yet, nil if not an OP Stack chain
+	Withdrawals *WithdrawalIndex
+	// OP Stack chains of which this chain is the L1, sorted by name
+	Rollups []*Chain
 
-	OpCl *sources.RollupClient
+	// nil if the chain has no beacon API
+	Beacon *BeaconClient
+	// whether the blob sidecars are fetched from the beacon API
+	BlobSidecars bool
+	// rollup inboxes of the chain, as L1, empty if none are known
+	Inboxes *Inboxes
+	// inbox candidate detection, disabled if the threshold is 0
+	CandidateThreshold uint64
+	CandidateWindow    uint64
 
 	L1      *Chain
 	MinTime uint64
 
 	Buffer chan *BlockWithReceipts
 
-	// TODO ring-buffer db of past written blocks
+	DB *ChainDB
 }
 
 type System struct {
-	// TODO victoria api http client
+	Victoria *VictoriaClient
 
 	Chains []*Chain
 }
@@ -76,11 +140,37 @@ var defaultEthClConfig = &sources.EthClientConfig{
 	MaxConcurrentRequests: 10,
 	TrustRPC:              true,
 	MustBePostMerge:       false,
-	RPCProviderKind:       sources.RPCKindBasic,
+	RPCProviderKind:       sources.RPCKindDebugGeth,
 	MethodResetDuration:   time.Minute,
 }
 
-func NewSystem(ctx context.Context, log log.Logger, cfg *Config) (*System, error) {
+// NewSystem opens the databases and RPC clients of all chains.
+// If it fails, the databases and clients that were opened already are closed again.
+func NewSystem(ctx context.Context, log log.Logger, cfg *Config) (_ *System, err error) {
+	// victoria-metrics is optional, e.g. to dump metrics to CSV
+	var victoria *VictoriaClient
+	if cfg.DB.Victoria != "" {
+		victoria, err = NewVictoriaClient(log.New("db", "victoria"), &cfg.DB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create victoria-metrics client: %w", err)
+		}
+	}
+	sys := &System{Victoria: victoria, Chains: make([]*Chain, 0, len(cfg.Chains))}
+	defer func() {
+		if err != nil {
+			if closeErr := sys.Close(); closeErr != nil {
+				log.Warn("failed to close partially opened system", "err", closeErr)
+			}
+		}
+	}()
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	inboxes, err := LoadInboxes(cfg.Inboxes)
+	if err != nil {
+		return nil, err
+	}
 	byName := make(map[string]*Chain)
 	for name, chCfg := range cfg.Chains {
 		typ, err := ParseChainType(chCfg.Type)
@@ -94,6 +184,16 @@ func NewSystem(ctx context.Context, log log.Logger, cfg *Config) (*System, error
 			MinTime: chCfg.MinTime,
 			Buffer:  make(chan *BlockWithReceipts, 100), // TODO buffer size
 		}
+		sys.Chains = append(sys.Chains, ch)
+		finalityDepth := chCfg.FinalityDepth
+		if finalityDepth == 0 {
+			finalityDepth = typ.DefaultFinalityDepth()
+		}
+		db, err := OpenChainDB(filepath.Join(dataDir, name), finalityDepth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open db of chain %s: %w", name, err)
+		}
+		ch.DB = db
 		if typ == EthereumChain || typ == OPStackChain {
 			if chCfg.EthRPC == "" {
 				return nil, fmt.Errorf("eth-like chain %s needs eth-rpc", name)
@@ -103,11 +203,71 @@ func NewSystem(ctx context.Context, log log.Logger, cfg *Config) (*System, error
 				return nil, fmt.Errorf("failed to create eth RPC: %w", err)
 			}
 			ch.EthRPC = ethRPC
-			ethCl, err := sources.NewEthClient(ethRPC, log, nil, defaultEthClConfig)
+			ethClConfig := *defaultEthClConfig
+			if chCfg.RPCKind != "" {
+				ethClConfig.RPCProviderKind = sources.RPCProviderKind(chCfg.RPCKind)
+			}
+			ethCl, err := sources.NewEthClient(ethRPC, log, nil, &ethClConfig)
 			if err != nil {
 				return nil, fmt.Errorf("failed to create eth client: %w", err)
 			}
 			ch.EthCl = ethCl
+			var chainConfig params.ChainConfig
+			if err := ethRPC.CallContext(ctx, &chainConfig, "eth_chainConfig"); err != nil {
+				return nil, fmt.Errorf("failed to get chain config of %s: %w", name, err)
+			}
+			ch.Config = &chainConfig
+			ch.Inboxes = inboxes[chainConfig.ChainID.Uint64()]
+			if ch.Inboxes == nil {
+				ch.Inboxes = newInboxes(nil)
+			}
+			ch.Blocks = NewRPCBlockSource(ethRPC)
+			ch.Receipts = NewRPCReceiptsSource(ethCl, &chainConfig)
+			ch.History = ch.Blocks
+		}
+		if (chCfg.BeaconEra != "" || chCfg.Era1 != "") && typ != EthereumChain {
+			return nil, fmt.Errorf("chain %s of type %s cannot use era files", name, typ)
+		}
+		var archives []BlockArchive
+		if chCfg.Era1 != "" {
+			era1, err := OpenEra1Store(log.New("chain", name, "source", "era1"), chCfg.Era1, ch.Config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open era1 store of chain %s: %w", name, err)
+			}
+			archives = append(archives, era1)
+			ch.Receipts = NewArchiveReceiptsSource(ch.Receipts, era1)
+		}
+		if chCfg.BeaconEra != "" {
+			era, err := OpenEraStore(log.New("chain", name, "source", "era"), chCfg.BeaconEra)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open era store of chain %s: %w", name, err)
+			}
+			archives = append(archives, era)
+		}
+		if len(archives) > 0 {
+			ch.History = NewArchiveBlockSource(ch.Blocks, archives...)
+		}
+		if chCfg.BeaconAPI != "" {
+			if typ != EthereumChain {
+				return nil, fmt.Errorf("chain %s of type %s cannot use a beacon API", name, typ)
+			}
+			ch.Beacon = NewBeaconClient(chCfg.BeaconAPI)
+		}
+		if chCfg.BlobSidecars {
+			if ch.Beacon == nil {
+				return nil, fmt.Errorf("chain %s needs a beacon API to fetch blob sidecars", name)
+			}
+			ch.BlobSidecars = true
+		}
+		if chCfg.CandidateThreshold > 0 {
+			if typ != EthereumChain {
+				return nil, fmt.Errorf("chain %s of type %s cannot detect inbox candidates", name, typ)
+			}
+			ch.CandidateThreshold = chCfg.CandidateThreshold
+			ch.CandidateWindow = chCfg.CandidateWindow
+			if ch.CandidateWindow == 0 {
+				ch.CandidateWindow = defaultCandidateWindow
+			}
 		}
 		if typ == OPStackChain {
 			if chCfg.OpRPC == "" {
@@ -117,7 +277,14 @@ func NewSystem(ctx context.Context, log log.Logger, cfg *Config) (*System, error
 			if err != nil {
 				return nil, fmt.Errorf("failed to create op RPC: %w", err)
 			}
+			ch.OpRPC = opRPC
 			ch.OpCl = sources.NewRollupClient(opRPC)
+			rollupCfg, err := ch.OpCl.RollupConfig(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get rollup config of %s: %w", name, err)
+			}
+			ch.RollupConfig = rollupCfg
+			ch.Withdrawals = NewWithdrawalIndex(ch.DB)
 		}
 
 		byName[name] = ch
@@ -128,12 +295,31 @@ func NewSystem(ctx context.Context, log log.Logger, cfg *Config) (*System, error
 			if !ok {
 				return nil, fmt.Errorf("%s has unknown l1 %s", name, chCfg.L1)
 			}
-			byName[name].L1 = l1Ch
+			ch := byName[name]
+			ch.L1 = l1Ch
+			if ch.Type == OPStackChain {
+				l1Ch.Rollups = append(l1Ch.Rollups, ch)
+				// for the L1 origin lag of the L2 blocks
+				l1Ch.DB.IndexTimes()
+				if l1Ch.Inboxes != nil {
+					// the batcher metrics follow the fork schedule of the chain
+					for i := range l1Ch.Inboxes.List {
+						if in := &l1Ch.Inboxes.List[i]; in.OPStack && in.Address == ch.RollupConfig.BatchInboxAddress {
+							in.RollupConfig = ch.RollupConfig
+						}
+					}
+				}
+				if l1Ch.EthRPC != nil {
+					ch.Deposits = NewDepositMatcher(l1Ch, ch.RollupConfig.DepositContractAddress)
+					ch.Receipts = NewDepositReceiptsSource(log.New("chain", name, "source", "deposits"), ch.Receipts, ch.Deposits)
+				}
+			}
 		}
 	}
-	sys := &System{Chains: make([]*Chain, 0, len(byName))}
 	for _, ch := range byName {
-		sys.Chains = append(sys.Chains, ch)
+		sort.Slice(ch.Rollups, func(i, j int) bool {
+			return ch.Rollups[i].Name < ch.Rollups[j].Name
+		})
 	}
 	// sort by name to make the system creation deterministic
 	sort.Slice(sys.Chains, func(i, j int) bool {
@@ -141,3 +327,19 @@ func NewSystem(ctx context.Context, log log.Logger, cfg *Config) (*System, error
 	})
 	return sys, nil
 }
+
+// SelectChains returns the chains with the given names, or all chains if no names are given.
+func (sys *System) SelectChains(names []string) ([]*Chain, error) {
+	if len(names) == 0 {
+		return sys.Chains, nil
+	}
+	out := make([]*Chain, 0, len(names))
+	for _, name := range names {
+		i := sort.Search(len(sys.Chains), func(i int) bool { return sys.Chains[i].Name >= name })
+		if i == len(sys.Chains) || sys.Chains[i].Name != name {
+			return nil, fmt.Errorf("unknown chain %q", name)
+		}
+		out = append(out, sys.Chains[i])
+	}
+	return out, nil
+}
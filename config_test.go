@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewSystemClosesOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{
+		DataDir: dir,
+		Chains: map[string]*ChainConfig{
+			// fails after its database was opened
+			"a": {Type: string(EthereumChain)},
+		},
+	}
+	_, err := NewSystem(context.Background(), log.NewLogger(log.DiscardHandler()), cfg)
+	if err == nil || !strings.Contains(err.Error(), "needs eth-rpc") {
+		t.Fatalf("got error %v, want missing eth-rpc", err)
+	}
+	// the database can only be opened again if the failed system closed it
+	db, err := OpenChainDB(filepath.Join(dir, "a"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errFlush is returned by Add when the element was added, but the batch could not be flushed
+var errFlush = errors.New("failed to flush metrics")
+
+// csvGroup is a set of series that share the same labels, and can be imported as columns of the same CSV rows.
+type csvGroup struct {
+	// VictoriaMetrics CSV column description, see https://docs.victoriametrics.com/#how-to-import-csv-data
+	format string
+	// label values, one column each, repeated on every row
+	labelValues []string
+	// indices of the aggregate metric values, one column each
+	metrics []int
+}
+
+// CSVExporter writes the metrics of elements in batches, in the VictoriaMetrics CSV format.
+// CSV rows can only carry the labels of a whole row, so the series are grouped by their labels,
+// and every group of a batch is written with a separate call, with its own column format.
+type CSVExporter[E any] struct {
+	// returns the unix timestamp in milliseconds
+	timeFn    func(elem E) int64
+	aggMetric AggregateMetric[E]
+	write     func(format string, data []byte) error
+	onFlush   func(batch []E) error
+
+	n      int
+	groups []csvGroup
+	// number of groups of the current batch that have been written, to resume a failed flush
+	flushed int
+
+	// the elements of the current batch
+	batch      []E
+	timestamps []int64
+	// values of the current batch, one row of len(aggMetric.Names) per element
+	values []float64
+
+	buf    bytes.Buffer
+	csvOut *csv.Writer
+	row    []string
+}
+
+// NewCSVExporter creates an exporter. The given labels are added to every series.
+// If onFlush is not nil, it is called with the elements of each batch after the batch has been written.
+func NewCSVExporter[E any](timeFn func(elem E) int64, aggMetric AggregateMetric[E], labels []Label, write func(format string, data []byte) error, onFlush func(batch []E) error) *CSVExporter[E] {
+	n := 100
+	x := &CSVExporter[E]{
+		timeFn:     timeFn,
+		aggMetric:  aggMetric,
+		write:      write,
+		onFlush:    onFlush,
+		n:          n,
+		batch:      make([]E, 0, n),
+		timestamps: make([]int64, 0, n),
+		values:     make([]float64, 0, n*len(aggMetric.Names)),
+	}
+	byKey := make(map[string]int)
+	for i, name := range aggMetric.Names {
+		groupLabels := append(append([]Label(nil), labels...), aggMetric.Labels[i]...)
+		sort.SliceStable(groupLabels, func(a, b int) bool { return groupLabels[a].Key < groupLabels[b].Key })
+		var key strings.Builder
+		for _, l := range groupLabels {
+			key.WriteString(strconv.Quote(l.Key) + "=" + strconv.Quote(l.Value) + ",")
+		}
+		gi, ok := byKey[key.String()]
+		if !ok {
+			gi = len(x.groups)
+			byKey[key.String()] = gi
+			g := csvGroup{format: "1:time:unix_ms"}
+			for _, l := range groupLabels {
+				g.format += fmt.Sprintf(",%d:label:%s", len(g.labelValues)+2, l.Key)
+				g.labelValues = append(g.labelValues, l.Value)
+			}
+			x.groups = append(x.groups, g)
+		}
+		g := &x.groups[gi]
+		g.format += fmt.Sprintf(",%d:metric:%s", 1+len(g.labelValues)+len(g.metrics)+1, name)
+		g.metrics = append(g.metrics, i)
+	}
+	x.csvOut = csv.NewWriter(&x.buf)
+	return x
+}
+
+// Series returns the number of series that every element adds a sample to.
+func (x *CSVExporter[E]) Series() int {
+	return len(x.aggMetric.Names)
+}
+
+// Add collects the metrics of the element, and flushes if the batch is full.
+// If the flush fails, the element stays in the batch, and the error wraps errFlush.
+func (x *CSVExporter[E]) Add(elem E) error {
+	// a batch that was partially written has to be completed before it can grow
+	if x.flushed > 0 {
+		if err := x.Flush(); err != nil {
+			return fmt.Errorf("failed to complete the flush of the previous batch: %w", err)
+		}
+	}
+	t := x.timeFn(elem)
+	// append a zeroed row, and collect the metrics values into it
+	start := len(x.values)
+	for range x.aggMetric.Names {
+		x.values = append(x.values, 0)
+	}
+	if err := x.aggMetric.Fn(elem, x.values[start:]); err != nil {
+		x.values = x.values[:start]
+		return fmt.Errorf("failed to collect t=%d metric: %w", t, err)
+	}
+	x.timestamps = append(x.timestamps, t)
+	x.batch = append(x.batch, elem)
+
+	if len(x.timestamps) >= x.n {
+		if err := x.Flush(); err != nil {
+			return fmt.Errorf("%w: %w", errFlush, err)
+		}
+	}
+	return nil
+}
+
+// Flush writes the current batch, if it is not empty.
+// If it fails, the batch is kept, and the next flush continues with the groups that were not written yet.
+func (x *CSVExporter[E]) Flush() error {
+	if len(x.batch) == 0 {
+		return nil // return early if there is nothing to output
+	}
+	m := len(x.aggMetric.Names)
+	for ; x.flushed < len(x.groups); x.flushed++ {
+		g := x.groups[x.flushed]
+		x.buf.Reset()
+		for j, t := range x.timestamps {
+			x.row = append(x.row[:0], strconv.FormatInt(t, 10))
+			x.row = append(x.row, g.labelValues...)
+			for _, i := range g.metrics {
+				x.row = append(x.row, strconv.FormatFloat(x.values[j*m+i], 'g', -1, 64))
+			}
+			if err := x.csvOut.Write(x.row); err != nil {
+				return fmt.Errorf("failed to encode metrics row: %w", err)
+			}
+		}
+		x.csvOut.Flush()
+		if err := x.csvOut.Error(); err != nil {
+			return fmt.Errorf("failed to encode metrics rows: %w", err)
+		}
+		if err := x.write(g.format, x.buf.Bytes()); err != nil {
+			return fmt.Errorf("failed to write metrics (t0 = %d, count=%d) to output: %w", x.timestamps[0], len(x.timestamps), err)
+		}
+	}
+	if x.onFlush != nil {
+		if err := x.onFlush(x.batch); err != nil {
+			return fmt.Errorf("failed to process flushed batch: %w", err)
+		}
+	}
+	x.flushed = 0
+	x.timestamps = x.timestamps[:0]
+	x.values = x.values[:0]
+	x.batch = x.batch[:0]
+	return nil
+}
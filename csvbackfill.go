@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"sync/atomic"
+	"time"
+)
+
+// how often the progress of a CSV backfill is logged
+const backfillProgressInterval = 10 * time.Second
+
+// BackfillSummary describes what a CSV backfill of a chain wrote.
+type BackfillSummary struct {
+	Chain string
+	// range of block numbers that was backfilled, the end is exclusive
+	Start, End uint64
+	// blocks that were exported
+	Blocks uint64
+	// blocks that were skipped, because they were already written before
+	Skipped uint64
+	// series that every exported block added a sample to
+	Series int
+	// total number of samples written
+	Samples  uint64
+	Duration time.Duration
+}
+
+// Missing returns the number of blocks in the range that were neither exported nor skipped.
+// Blocks are missing when the backfill is interrupted.
+func (s *BackfillSummary) Missing() uint64 {
+	return (s.End - s.Start) - s.Blocks - s.Skipped
+}
+
+// CSVBackfill exports the metrics of a range of historical blocks through the VictoriaMetrics CSV import.
+//
+// Only finalized blocks are backfilled: these cannot be reorged,
+// so the series are not labeled with the metrics hour, and do not need compaction later.
+// Written blocks are recorded in the chain database, so an interrupted backfill can be run again,
+// and the live exporter does not write the same blocks again.
+type CSVBackfill struct {
+	log      log.Logger
+	ch       *Chain
+	victoria *VictoriaClient
+	m        AggregateMetric[*BlockWithReceipts]
+}
+
+func NewCSVBackfill(log log.Logger, ch *Chain, victoria *VictoriaClient, m AggregateMetric[*BlockWithReceipts]) *CSVBackfill {
+	return &CSVBackfill{log: log, ch: ch, victoria: victoria, m: m}
+}
+
+// Run backfills the finalized blocks with a timestamp in [startTime, endTime).
+// An endTime of 0 backfills up to and including the finalized block.
+// The summary is returned also if the backfill failed halfway.
+func (b *CSVBackfill) Run(ctx context.Context, startTime, endTime uint64) (*BackfillSummary, error) {
+	summary := &BackfillSummary{Chain: b.ch.Name, Series: len(b.m.Names)}
+	started := time.Now()
+	defer func() {
+		summary.Duration = time.Since(started)
+	}()
+
+	finalized, err := b.ch.Blocks.BlockByLabel(ctx, eth.Finalized)
+	if err != nil {
+		return summary, fmt.Errorf("failed to get finalized block: %w", err)
+	}
+	if endTime == 0 || endTime > finalized.Time() {
+		if endTime != 0 {
+			b.log.Warn("end time is past the finalized block, backfilling up to the finalized block",
+				"end_time", endTime, "finalized", eth.ToBlockID(finalized), "finalized_time", finalized.Time())
+		}
+		endTime = finalized.Time() + 1
+	}
+	summary.Start, err = FindStart(ctx, b.ch.History, startTime, finalized.NumberU64())
+	if err != nil {
+		return summary, fmt.Errorf("failed to find first block at or after start time %d: %w", startTime, err)
+	}
+	summary.End, err = FindStart(ctx, b.ch.History, endTime, finalized.NumberU64())
+	if err != nil {
+		return summary, fmt.Errorf("failed to find first block at or after end time %d: %w", endTime, err)
+	}
+	if summary.End < summary.Start {
+		summary.End = summary.Start
+	}
+	b.log.Info("starting backfill", "start", summary.Start, "end", summary.End, "series", summary.Series)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	blocks := make(chan *types.Block, backfillBatchSize)
+	withReceipts := make(chan *BlockWithReceipts, backfillBatchSize)
+	var skipped atomic.Uint64
+	var producerErr, stageErr error
+	go func() {
+		defer close(blocks)
+		producerErr = b.produce(ctx, summary.Start, summary.End, blocks, &skipped)
+	}()
+	go func() {
+		defer close(withReceipts)
+		stage := NewReceiptsStage(b.log.New("stage", "receipts"), b.ch.Receipts, b.ch.Blocks)
+		stageErr = stage.Run(ctx, blocks, withReceipts)
+	}()
+
+	// victoria-metrics expects millisecond timestamps
+	blockTime := func(bl *BlockWithReceipts) int64 {
+		return int64(bl.Block.Time()) * 1000
+	}
+	write := func(format string, data []byte) error {
+		return b.victoria.ImportCSV(ctx, format, data)
+	}
+	var last uint64
+	withdrawals := NewWithdrawalIndexer(b.log.New("stage", "withdrawals"), b.ch)
+	onFlush := func(batch []*BlockWithReceipts) error {
+		// indexing a block again is a no-op, so index before the blocks are marked as exported
+		if withdrawals != nil {
+			withdrawals.Index(batch)
+		}
+		for _, bl := range batch {
+			if err := b.ch.DB.PutExported(bl.Block.NumberU64(), bl.Block.Hash(), bl.Block.Time()); err != nil {
+				return fmt.Errorf("failed to mark block %d as exported: %w", bl.Block.NumberU64(), err)
+			}
+		}
+		summary.Blocks += uint64(len(batch))
+		summary.Samples += uint64(len(batch) * summary.Series)
+		last = batch[len(batch)-1].Block.NumberU64()
+		return b.ch.DB.Prune(last)
+	}
+	labels := []Label{{Key: ChainLabel, Value: b.ch.Name}}
+	exp := NewCSVExporter[*BlockWithReceipts](blockTime, b.m, labels, write, onFlush)
+
+	progress := time.NewTicker(backfillProgressInterval)
+	defer progress.Stop()
+	for {
+		select {
+		case bl, ok := <-withReceipts:
+			if !ok {
+				if err := exp.Flush(); err != nil {
+					return summary, err
+				}
+				summary.Skipped = skipped.Load()
+				// the producer may still be running when the stage failed, it stops on the canceled context
+				if stageErr != nil {
+					return summary, stageErr
+				}
+				if producerErr != nil {
+					return summary, producerErr
+				}
+				return summary, ctx.Err()
+			}
+			if err := exp.Add(bl); err != nil {
+				return summary, err
+			}
+		case <-progress.C:
+			done := summary.Blocks + skipped.Load()
+			b.log.Info("backfill progress", "blocks", summary.Blocks, "skipped", skipped.Load(), "last", last,
+				"progress", fmt.Sprintf("%.2f%%", 100*float64(done)/float64(summary.End-summary.Start)),
+				"blocks_per_sec", fmt.Sprintf("%.1f", float64(summary.Blocks)/time.Since(started).Seconds()))
+		case <-ctx.Done():
+			summary.Skipped = skipped.Load()
+			return summary, ctx.Err()
+		}
+	}
+}
+
+// produce hands every block in [start, end) that was not written yet to the pipeline.
+func (b *CSVBackfill) produce(ctx context.Context, start, end uint64, out chan<- *types.Block, skipped *atomic.Uint64) error {
+	// finalized blocks don't change anymore, those we do not need to fetch to compare
+	skipNum := func(num uint64) bool {
+		if rec, ok := b.ch.DB.Get(num); ok && rec.Status == BlockFinalized {
+			skipped.Add(1)
+			return true
+		}
+		return false
+	}
+	skip := func(bl *types.Block) bool {
+		if rec, ok := b.ch.DB.Get(bl.NumberU64()); ok && rec.Written(bl.Hash()) {
+			skipped.Add(1)
+			return true
+		}
+		return false
+	}
+	return fetchRange(ctx, b.ch.History, start, end, out, skipNum, skip)
+}
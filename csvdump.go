@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"io"
+	"strconv"
+)
+
+// CSVDump writes the metrics of a range of blocks to a single wide CSV file:
+// a timestamp and block number column, followed by one column per series.
+// Unlike the CSV backfill, it does not need VictoriaMetrics, and does not record anything in the chain database.
+type CSVDump struct {
+	log log.Logger
+	ch  *Chain
+	m   AggregateMetric[*BlockWithReceipts]
+}
+
+func NewCSVDump(log log.Logger, ch *Chain, m AggregateMetric[*BlockWithReceipts]) *CSVDump {
+	return &CSVDump{log: log, ch: ch, m: m}
+}
+
+// Run writes a row for every block with a timestamp in [startTime, endTime), and returns the number of rows.
+// An endTime of 0 dumps up to and including the latest block.
+func (d *CSVDump) Run(ctx context.Context, w io.Writer, startTime, endTime uint64) (uint64, error) {
+	latest, err := d.ch.History.LatestNumber(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest block number: %w", err)
+	}
+	start, err := FindStart(ctx, d.ch.History, startTime, latest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find first block at or after start time %d: %w", startTime, err)
+	}
+	end := latest + 1
+	if endTime != 0 {
+		end, err = FindStart(ctx, d.ch.History, endTime, latest)
+		if err != nil {
+			return 0, fmt.Errorf("failed to find first block at or after end time %d: %w", endTime, err)
+		}
+	}
+	if end < start {
+		end = start
+	}
+	d.log.Info("dumping metrics", "start", start, "end", end, "series", len(d.m.Names))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	blocks := make(chan *types.Block, backfillBatchSize)
+	withReceipts := make(chan *BlockWithReceipts, backfillBatchSize)
+	var producerErr, stageErr error
+	go func() {
+		defer close(blocks)
+		producerErr = fetchRange(ctx, d.ch.History, start, end, blocks, nil, nil)
+	}()
+	go func() {
+		defer close(withReceipts)
+		stage := NewReceiptsStage(d.log.New("stage", "receipts"), d.ch.Receipts, d.ch.Blocks)
+		stageErr = stage.Run(ctx, blocks, withReceipts)
+	}()
+
+	out := csv.NewWriter(w)
+	row := make([]string, 0, 2+len(d.m.Names))
+	row = append(row, "timestamp", "block")
+	for i, name := range d.m.Names {
+		row = append(row, formatLabeledMetric(name, d.m.Labels[i]))
+	}
+	if err := out.Write(row); err != nil {
+		return 0, fmt.Errorf("failed to write header: %w", err)
+	}
+	values := make([]float64, len(d.m.Names))
+	var rows uint64
+	for bl := range withReceipts {
+		for i := range values {
+			values[i] = 0
+		}
+		if err := d.m.Fn(bl, values); err != nil {
+			return rows, fmt.Errorf("failed to collect metrics of block %d: %w", bl.Block.NumberU64(), err)
+		}
+		row = append(row[:0], strconv.FormatUint(bl.Block.Time(), 10), strconv.FormatUint(bl.Block.NumberU64(), 10))
+		for _, v := range values {
+			row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		if err := out.Write(row); err != nil {
+			return rows, fmt.Errorf("failed to write row of block %d: %w", bl.Block.NumberU64(), err)
+		}
+		rows += 1
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return rows, fmt.Errorf("failed to write rows: %w", err)
+	}
+	// the producer may still be running when the stage failed, it stops on the canceled context
+	if stageErr != nil {
+		return rows, stageErr
+	}
+	if producerErr != nil {
+		return rows, producerErr
+	}
+	return rows, ctx.Err()
+}
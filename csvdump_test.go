@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/ethereum/go-ethereum/log"
+	"testing"
+)
+
+func TestCSVDump(t *testing.T) {
+	src := newMemBlockSource(8)
+	m := CombineAggregates[*BlockWithReceipts](
+		Aggregate[*BlockWithReceipts](Metric[*BlockWithReceipts]{
+			Name: "number",
+			Fn: func(elem *BlockWithReceipts) (float64, error) {
+				return float64(elem.Block.NumberU64()), nil
+			},
+		}),
+		ParametrizedMetric[*BlockWithReceipts]("parity", "kind", []string{"even", "odd"}, func(elem *BlockWithReceipts, dest []float64) error {
+			dest[elem.Block.NumberU64()%2] = 1
+			return nil
+		}),
+	)
+	const header = "timestamp,block,number,parity[kind=even],parity[kind=odd]\n"
+	tests := []struct {
+		name      string
+		startTime uint64
+		endTime   uint64
+		want      string
+		wantRows  uint64
+	}{
+		{
+			name:      "range",
+			startTime: src.blocks[2].Time(),
+			endTime:   src.blocks[5].Time(),
+			want: header +
+				"1700000024,2,2,1,0\n" +
+				"1700000036,3,3,0,1\n" +
+				"1700000048,4,4,1,0\n",
+			wantRows: 3,
+		},
+		{
+			name:      "to latest",
+			startTime: src.blocks[6].Time() - 1,
+			want: header +
+				"1700000072,6,6,1,0\n" +
+				"1700000084,7,7,0,1\n",
+			wantRows: 2,
+		},
+		{
+			name:      "empty",
+			startTime: src.blocks[3].Time(),
+			endTime:   src.blocks[3].Time(),
+			want:      header,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &Chain{Name: "test", History: src, Receipts: &flakyReceiptsSource{}}
+			d := NewCSVDump(log.NewLogger(log.DiscardHandler()), ch, m)
+			var out bytes.Buffer
+			rows, err := d.Run(context.Background(), &out, tt.startTime, tt.endTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows != tt.wantRows {
+				t.Errorf("got %d rows, want %d", rows, tt.wantRows)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got output\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"sort"
+	"sync"
+)
+
+type BlockStatus uint8
+
+const (
+	// BlockExported is a block of which the metrics are in VictoriaMetrics.
+	BlockExported BlockStatus = 2
+	// BlockFinalized is an exported block that is older than the finality depth.
+	// Only the number is remembered, as part of a range of finalized blocks.
+	BlockFinalized BlockStatus = 3
+)
+
+func (s BlockStatus) String() string {
+	switch s {
+	case BlockExported:
+		return "exported"
+	case BlockFinalized:
+		return "finalized"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+type BlockRecord struct {
+	Hash common.Hash
+	// block timestamp, in seconds
+	Time   uint64
+	Status BlockStatus
+}
+
+// Written returns true if the block with the given hash does not have to be written (again).
+func (r BlockRecord) Written(hash common.Hash) bool {
+	return r.Status == BlockFinalized || r.Hash == hash
+}
+
+// numRange is an inclusive range of block numbers
+type numRange struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+var (
+	blockKeyPrefix = []byte("b")
+	finalizedKey   = []byte("finalized")
+	compactionKey  = []byte("compaction")
+	compactedKey   = []byte("compacted")
+	reorgsKey      = []byte("reorgs")
+	// not prefixed with "b", which would collide with the block records
+	progressKeyPrefix = []byte("progress/")
+	// block times by block number, of chains that index them
+	blockTimeKeyPrefix = []byte("time/")
+	// withdrawal records, by withdrawal hash
+	withdrawalKeyPrefix = []byte("withdrawal/")
+	// changes of blocks to the withdrawal records, by side, block number and hash
+	withdrawalJournalKeyPrefix = []byte("withdrawal-journal/")
+)
+
+func blockTimeKey(num uint64) []byte {
+	return binary.BigEndian.AppendUint64(common.CopyBytes(blockTimeKeyPrefix), num)
+}
+
+func blockKey(num uint64) []byte {
+	var out [9]byte
+	copy(out[:1], blockKeyPrefix)
+	binary.BigEndian.PutUint64(out[1:], num)
+	return out[:]
+}
+
+// ChainDB is the on-disk record of the blocks of a chain that have been written to VictoriaMetrics,
+// shared by the head follower, the backfiller and the exporter, and kept across restarts.
+// Recent blocks are recorded by number with their hash and export status.
+// Exported blocks older than the finality depth are pruned into ranges of finalized block numbers.
+type ChainDB struct {
+	db            ethdb.KeyValueStore
+	finalityDepth uint64
+	// keep the times of exported blocks, also after they are finalized
+	indexTimes bool
+
+	mu        sync.RWMutex
+	finalized []numRange
+}
+
+func OpenChainDB(path string, finalityDepth uint64) (*ChainDB, error) {
+	db, err := leveldb.New(path, 16, 16, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db %q: %w", path, err)
+	}
+	out := &ChainDB{db: db, finalityDepth: finalityDepth}
+	if err := out.load(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ChainDB) load() error {
+	dat, err := c.db.Get(finalizedKey)
+	if err == nil {
+		if err := json.Unmarshal(dat, &c.finalized); err != nil {
+			return fmt.Errorf("failed to decode finalized block ranges: %w", err)
+		}
+	} else if ok, _ := c.db.Has(finalizedKey); ok {
+		return fmt.Errorf("failed to read finalized block ranges: %w", err)
+	}
+	// Forget records we cannot read, so the blocks get processed again.
+	it := c.db.NewIterator(blockKeyPrefix, nil)
+	defer it.Release()
+	batch := c.db.NewBatch()
+	for it.Next() {
+		if _, err := decodeBlockRecord(it.Value()); err != nil {
+			if err := batch.Delete(common.CopyBytes(it.Key())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Error(); err != nil {
+		return fmt.Errorf("failed to iterate block records: %w", err)
+	}
+	return batch.Write()
+}
+
+func decodeBlockRecord(dat []byte) (BlockRecord, error) {
+	if len(dat) != 41 {
+		return BlockRecord{}, fmt.Errorf("invalid block record length: %d", len(dat))
+	}
+	return BlockRecord{
+		Hash:   common.BytesToHash(dat[:32]),
+		Time:   binary.BigEndian.Uint64(dat[32:40]),
+		Status: BlockStatus(dat[40]),
+	}, nil
+}
+
+// Get returns the record of the block with the given number, if any.
+func (c *ChainDB) Get(num uint64) (BlockRecord, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	i := sort.Search(len(c.finalized), func(i int) bool { return c.finalized[i].End >= num })
+	if i < len(c.finalized) && c.finalized[i].Start <= num {
+		return BlockRecord{Status: BlockFinalized}, true
+	}
+	dat, err := c.db.Get(blockKey(num))
+	if err != nil {
+		return BlockRecord{}, false
+	}
+	rec, err := decodeBlockRecord(dat)
+	if err != nil {
+		return BlockRecord{}, false
+	}
+	return rec, true
+}
+
+// PutExported records that the block with the given number, hash and timestamp was exported.
+func (c *ChainDB) PutExported(num uint64, hash common.Hash, time uint64) error {
+	var dat [41]byte
+	copy(dat[:32], hash[:])
+	binary.BigEndian.PutUint64(dat[32:40], time)
+	dat[40] = byte(BlockExported)
+	if err := c.PutBlockTime(num, time); err != nil {
+		return err
+	}
+	return c.db.Put(blockKey(num), dat[:])
+}
+
+// IndexTimes makes the DB keep the times of the blocks, see BlockTime.
+// The L2 chains of an L1 chain use these to look up the L1 head as of the time of an L2 block.
+// It must be called before the DB is used.
+func (c *ChainDB) IndexTimes() {
+	c.indexTimes = true
+}
+
+// PutBlockTime records the time of the block with the given number, if the DB indexes times.
+// The time is removed when the block is forgotten, and pruned some time after it is finalized, see Prune.
+func (c *ChainDB) PutBlockTime(num uint64, time uint64) error {
+	if !c.indexTimes {
+		return nil
+	}
+	var dat [8]byte
+	binary.BigEndian.PutUint64(dat[:], time)
+	return c.db.Put(blockTimeKey(num), dat[:])
+}
+
+// BlockTime returns the time of the block with the given number, and false if it is not indexed.
+func (c *ChainDB) BlockTime(num uint64) (uint64, bool, error) {
+	key := blockTimeKey(num)
+	if ok, err := c.db.Has(key); err != nil || !ok {
+		return 0, false, err
+	}
+	dat, err := c.db.Get(key)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(dat) != 8 {
+		return 0, false, fmt.Errorf("invalid time record of block %d of %d bytes", num, len(dat))
+	}
+	return binary.BigEndian.Uint64(dat), true, nil
+}
+
+// FinalityDepth returns the number of blocks after which blocks of the chain are final.
+func (c *ChainDB) FinalityDepth() uint64 {
+	return c.finalityDepth
+}
+
+// ForgetFrom removes the records of all non-finalized blocks with the given number or higher,
+// and returns how many records were removed. The indexed times of these blocks are removed too.
+func (c *ChainDB) ForgetFrom(num uint64) (int, error) {
+	it := c.db.NewIterator(blockKeyPrefix, blockKey(num)[len(blockKeyPrefix):])
+	defer it.Release()
+	batch := c.db.NewBatch()
+	count := 0
+	for it.Next() {
+		if err := batch.Delete(common.CopyBytes(it.Key())); err != nil {
+			return 0, err
+		}
+		count += 1
+	}
+	if err := it.Error(); err != nil {
+		return 0, fmt.Errorf("failed to iterate block records: %w", err)
+	}
+	timesIt := c.db.NewIterator(blockTimeKeyPrefix, binary.BigEndian.AppendUint64(nil, num))
+	defer timesIt.Release()
+	for timesIt.Next() {
+		if err := batch.Delete(common.CopyBytes(timesIt.Key())); err != nil {
+			return 0, err
+		}
+	}
+	if err := timesIt.Error(); err != nil {
+		return 0, fmt.Errorf("failed to iterate block times: %w", err)
+	}
+	return count, batch.Write()
+}
+
+// Reorgs returns the number of reorgs of the chain that were rolled back.
+func (c *ChainDB) Reorgs() (uint64, error) {
+	if ok, err := c.db.Has(reorgsKey); err != nil || !ok {
+		return 0, err
+	}
+	dat, err := c.db.Get(reorgsKey)
+	if err != nil {
+		return 0, err
+	}
+	if len(dat) != 8 {
+		return 0, fmt.Errorf("invalid reorgs record of %d bytes", len(dat))
+	}
+	return binary.BigEndian.Uint64(dat), nil
+}
+
+// AddReorg increments the number of reorgs of the chain, and returns the new count.
+func (c *ChainDB) AddReorg() (uint64, error) {
+	count, err := c.Reorgs()
+	if err != nil {
+		return 0, err
+	}
+	count += 1
+	var dat [8]byte
+	binary.BigEndian.PutUint64(dat[:], count)
+	return count, c.db.Put(reorgsKey, dat[:])
+}
+
+// Prune moves the exported blocks that are older than the finality depth, relative to the given head,
+// into the finalized ranges. Indexed block times are removed once they are more than maxL1OriginLag blocks older than that,
+// which is as far back as the L2 chains look them up.
+func (c *ChainDB) Prune(head uint64) error {
+	if head < c.finalityDepth {
+		return nil
+	}
+	limit := head - c.finalityDepth
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	it := c.db.NewIterator(blockKeyPrefix, nil)
+	defer it.Release()
+	batch := c.db.NewBatch()
+	changed := false
+	// whether block times were pruned
+	pruned := false
+	for it.Next() {
+		num := binary.BigEndian.Uint64(it.Key()[1:])
+		if num >= limit {
+			break
+		}
+		rec, err := decodeBlockRecord(it.Value())
+		if err != nil || rec.Status != BlockExported {
+			continue
+		}
+		c.finalized = addToRanges(c.finalized, num)
+		if err := batch.Delete(common.CopyBytes(it.Key())); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if err := it.Error(); err != nil {
+		return fmt.Errorf("failed to iterate block records: %w", err)
+	}
+	if changed {
+		dat, err := json.Marshal(c.finalized)
+		if err != nil {
+			return fmt.Errorf("failed to encode finalized block ranges: %w", err)
+		}
+		if err := batch.Put(finalizedKey, dat); err != nil {
+			return err
+		}
+	}
+	if limit > maxL1OriginLag {
+		timesIt := c.db.NewIterator(blockTimeKeyPrefix, nil)
+		defer timesIt.Release()
+		for timesIt.Next() {
+			if binary.BigEndian.Uint64(timesIt.Key()[len(blockTimeKeyPrefix):]) >= limit-maxL1OriginLag {
+				break
+			}
+			if err := batch.Delete(common.CopyBytes(timesIt.Key())); err != nil {
+				return err
+			}
+			pruned = true
+		}
+		if err := timesIt.Error(); err != nil {
+			return fmt.Errorf("failed to iterate block times: %w", err)
+		}
+	}
+	if !changed && !pruned {
+		return nil
+	}
+	return batch.Write()
+}
+
+// addToRanges adds num to the sorted list of ranges, merging adjacent ranges
+func addToRanges(ranges []numRange, num uint64) []numRange {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].End+1 >= num })
+	if i < len(ranges) && ranges[i].Start <= num && num <= ranges[i].End {
+		return ranges
+	}
+	if i < len(ranges) && ranges[i].End+1 == num {
+		ranges[i].End = num
+		// merge with the next range if they touch now
+		if i+1 < len(ranges) && ranges[i+1].Start == num+1 {
+			ranges[i].End = ranges[i+1].End
+			ranges = append(ranges[:i+1], ranges[i+2:]...)
+		}
+		return ranges
+	}
+	if i < len(ranges) && ranges[i].Start == num+1 {
+		ranges[i].Start = num
+		return ranges
+	}
+	ranges = append(ranges, numRange{})
+	copy(ranges[i+1:], ranges[i:])
+	ranges[i] = numRange{Start: num, End: num}
+	return ranges
+}
+
+// CompactionCheckpoint records the progress of the compaction of a metrics hour
+type CompactionCheckpoint struct {
+	Hour int64 `json:"hour"`
+	// Imported is true when the merged series have been imported, and only the originals are left to delete.
+	Imported bool `json:"imported"`
+}
+
+// CompactionCheckpoint returns the checkpoint of the compaction in progress, or nil if there is none.
+func (c *ChainDB) CompactionCheckpoint() (*CompactionCheckpoint, error) {
+	if ok, err := c.db.Has(compactionKey); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, nil
+	}
+	dat, err := c.db.Get(compactionKey)
+	if err != nil {
+		return nil, err
+	}
+	var out CompactionCheckpoint
+	if err := json.Unmarshal(dat, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode compaction checkpoint: %w", err)
+	}
+	return &out, nil
+}
+
+// SetCompactionCheckpoint stores the checkpoint, or removes it if nil.
+func (c *ChainDB) SetCompactionCheckpoint(cp *CompactionCheckpoint) error {
+	if cp == nil {
+		return c.db.Delete(compactionKey)
+	}
+	dat, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to encode compaction checkpoint: %w", err)
+	}
+	return c.db.Put(compactionKey, dat)
+}
+
+// CompactedHour returns the highest metrics hour that has been compacted, and false if none has been.
+func (c *ChainDB) CompactedHour() (int64, bool, error) {
+	if ok, err := c.db.Has(compactedKey); err != nil || !ok {
+		return 0, false, err
+	}
+	dat, err := c.db.Get(compactedKey)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(dat) != 8 {
+		return 0, false, fmt.Errorf("invalid compacted hour record of %d bytes", len(dat))
+	}
+	return int64(binary.BigEndian.Uint64(dat)), true, nil
+}
+
+// SetCompactedHour records that the metrics hour has been compacted, unless a later hour already was.
+func (c *ChainDB) SetCompactedHour(hour int64) error {
+	if prev, ok, err := c.CompactedHour(); err != nil {
+		return err
+	} else if ok && prev >= hour {
+		return nil
+	}
+	var dat [8]byte
+	binary.BigEndian.PutUint64(dat[:], uint64(hour))
+	return c.db.Put(compactedKey, dat[:])
+}
+
+// ExportProgress returns the number of the last element that the named finalized exporter exported,
+// and false if there is none.
+func (c *ChainDB) ExportProgress(name string) (uint64, bool, error) {
+	key := append(common.CopyBytes(progressKeyPrefix), name...)
+	if ok, err := c.db.Has(key); err != nil || !ok {
+		return 0, false, err
+	}
+	dat, err := c.db.Get(key)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(dat) != 8 {
+		return 0, false, fmt.Errorf("invalid %s progress record of %d bytes", name, len(dat))
+	}
+	return binary.BigEndian.Uint64(dat), true, nil
+}
+
+// SetExportProgress stores the number of the last element that the named finalized exporter exported.
+func (c *ChainDB) SetExportProgress(name string, num uint64) error {
+	var dat [8]byte
+	binary.BigEndian.PutUint64(dat[:], num)
+	return c.db.Put(append(common.CopyBytes(progressKeyPrefix), name...), dat[:])
+}
+
+// WithdrawalRecord is what is known of a withdrawal of an OP Stack chain.
+type WithdrawalRecord struct {
+	// L2 time of the MessagePassed event, 0 if unknown
+	Initiated uint64 `json:"initiated,omitempty"`
+	// withdrawn ETH, nil if the initiation is unknown
+	Value *hexutil.Big `json:"value,omitempty"`
+	// L1 time of the latest WithdrawalProven event, 0 if unknown
+	Proven uint64 `json:"proven,omitempty"`
+	// L1 time of the WithdrawalFinalized event, 0 if not finalized
+	Finalized uint64 `json:"finalized,omitempty"`
+}
+
+// WithdrawalJournal is the undo record of the changes of a block to the withdrawal records.
+type WithdrawalJournal struct {
+	Num   uint64      `json:"-"`
+	Block common.Hash `json:"-"`
+	// the records before the block changed them, nil if there was none
+	Prev map[common.Hash]*WithdrawalRecord `json:"prev"`
+}
+
+func withdrawalKey(hash common.Hash) []byte {
+	return append(common.CopyBytes(withdrawalKeyPrefix), hash[:]...)
+}
+
+func withdrawalJournalPrefix(side string) []byte {
+	return append(append(common.CopyBytes(withdrawalJournalKeyPrefix), side...), '/')
+}
+
+func withdrawalJournalKey(side string, num uint64, block common.Hash) []byte {
+	out := binary.BigEndian.AppendUint64(withdrawalJournalPrefix(side), num)
+	return append(out, block[:]...)
+}
+
+// Withdrawal returns the record of the withdrawal, or nil if there is none.
+func (c *ChainDB) Withdrawal(hash common.Hash) (*WithdrawalRecord, error) {
+	key := withdrawalKey(hash)
+	if ok, err := c.db.Has(key); err != nil || !ok {
+		return nil, err
+	}
+	dat, err := c.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var out WithdrawalRecord
+	if err := json.Unmarshal(dat, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode withdrawal record %s: %w", hash, err)
+	}
+	return &out, nil
+}
+
+// putWithdrawals adds the records to the batch, nil records are removed.
+func putWithdrawals(batch ethdb.Batch, records map[common.Hash]*WithdrawalRecord) error {
+	for hash, rec := range records {
+		if rec == nil {
+			if err := batch.Delete(withdrawalKey(hash)); err != nil {
+				return err
+			}
+			continue
+		}
+		dat, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("failed to encode withdrawal record %s: %w", hash, err)
+		}
+		if err := batch.Put(withdrawalKey(hash), dat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithdrawalJournal returns the journal of the block of the given side, or nil if there is none.
+func (c *ChainDB) WithdrawalJournal(side string, num uint64, block common.Hash) (*WithdrawalJournal, error) {
+	key := withdrawalJournalKey(side, num, block)
+	if ok, err := c.db.Has(key); err != nil || !ok {
+		return nil, err
+	}
+	dat, err := c.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	out := WithdrawalJournal{Num: num, Block: block}
+	if err := json.Unmarshal(dat, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode withdrawal journal of block %d (%s): %w", num, block, err)
+	}
+	return &out, nil
+}
+
+// WithdrawalJournals returns the journals of the given side of the blocks in [from, to), in block order.
+func (c *ChainDB) WithdrawalJournals(side string, from, to uint64) ([]*WithdrawalJournal, error) {
+	prefix := withdrawalJournalPrefix(side)
+	it := c.db.NewIterator(prefix, binary.BigEndian.AppendUint64(nil, from))
+	defer it.Release()
+	var out []*WithdrawalJournal
+	for it.Next() {
+		key := it.Key()[len(prefix):]
+		if len(key) != 8+32 {
+			return nil, fmt.Errorf("invalid withdrawal journal key %x", it.Key())
+		}
+		j := &WithdrawalJournal{Num: binary.BigEndian.Uint64(key[:8]), Block: common.BytesToHash(key[8:])}
+		if j.Num >= to {
+			break
+		}
+		if err := json.Unmarshal(it.Value(), j); err != nil {
+			return nil, fmt.Errorf("failed to decode withdrawal journal of block %d (%s): %w", j.Num, j.Block, err)
+		}
+		out = append(out, j)
+	}
+	if err := it.Error(); err != nil {
+		return nil, fmt.Errorf("failed to iterate withdrawal journals: %w", err)
+	}
+	return out, nil
+}
+
+// PutWithdrawals stores the records that the block of the given side changed, together with its journal.
+// Nil records are removed.
+func (c *ChainDB) PutWithdrawals(side string, j *WithdrawalJournal, records map[common.Hash]*WithdrawalRecord) error {
+	batch := c.db.NewBatch()
+	if err := putWithdrawals(batch, records); err != nil {
+		return err
+	}
+	dat, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("failed to encode withdrawal journal of block %d (%s): %w", j.Num, j.Block, err)
+	}
+	if err := batch.Put(withdrawalJournalKey(side, j.Num, j.Block), dat); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// RemoveWithdrawalJournal removes the journal of the given side, together with storing the records.
+// Nil records are removed.
+func (c *ChainDB) RemoveWithdrawalJournal(side string, j *WithdrawalJournal, records map[common.Hash]*WithdrawalRecord) error {
+	batch := c.db.NewBatch()
+	if err := putWithdrawals(batch, records); err != nil {
+		return err
+	}
+	if err := batch.Delete(withdrawalJournalKey(side, j.Num, j.Block)); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+func (c *ChainDB) Close() error {
+	return c.db.Close()
+}
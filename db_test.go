@@ -0,0 +1,181 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAddToRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []numRange
+		num    uint64
+		want   []numRange
+	}{
+		{name: "empty", ranges: nil, num: 5, want: []numRange{{5, 5}}},
+		{name: "inside", ranges: []numRange{{3, 7}}, num: 5, want: []numRange{{3, 7}}},
+		{name: "at the start", ranges: []numRange{{3, 7}}, num: 3, want: []numRange{{3, 7}}},
+		{name: "at the end", ranges: []numRange{{3, 7}}, num: 7, want: []numRange{{3, 7}}},
+		{name: "extends the end", ranges: []numRange{{3, 7}}, num: 8, want: []numRange{{3, 8}}},
+		{name: "extends the start", ranges: []numRange{{3, 7}}, num: 2, want: []numRange{{2, 7}}},
+		{name: "before", ranges: []numRange{{3, 7}}, num: 0, want: []numRange{{0, 0}, {3, 7}}},
+		{name: "after", ranges: []numRange{{3, 7}}, num: 10, want: []numRange{{3, 7}, {10, 10}}},
+		{name: "between", ranges: []numRange{{3, 7}, {12, 15}}, num: 10, want: []numRange{{3, 7}, {10, 10}, {12, 15}}},
+		{name: "merges the gap", ranges: []numRange{{3, 7}, {9, 15}}, num: 8, want: []numRange{{3, 15}}},
+		{name: "merges the gap of the middle", ranges: []numRange{{0, 1}, {3, 7}, {9, 15}, {20, 20}}, num: 8, want: []numRange{{0, 1}, {3, 15}, {20, 20}}},
+		{name: "extends the start of the next", ranges: []numRange{{3, 7}, {10, 15}}, num: 9, want: []numRange{{3, 7}, {9, 15}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := addToRanges(append([]numRange(nil), tt.ranges...), tt.num)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainDBPrune(t *testing.T) {
+	const finalityDepth = 10
+	tests := []struct {
+		name string
+		// exported block numbers
+		exported []uint64
+		heads    []uint64
+		// block numbers that are expected to be finalized, exported, or unknown after pruning
+		wantFinalized, wantExported, wantUnknown []uint64
+	}{
+		{
+			name:         "head within the finality depth",
+			exported:     []uint64{0, 1, 2},
+			heads:        []uint64{9},
+			wantExported: []uint64{0, 1, 2},
+		},
+		{
+			name:          "older than the finality depth",
+			exported:      []uint64{0, 1, 2, 3, 4, 5},
+			heads:         []uint64{13},
+			wantFinalized: []uint64{0, 1, 2},
+			wantExported:  []uint64{3, 4, 5},
+		},
+		{
+			name:          "gaps are not finalized",
+			exported:      []uint64{100, 101, 103, 104, 120},
+			heads:         []uint64{115, 130},
+			wantFinalized: []uint64{100, 101, 103, 104},
+			wantExported:  []uint64{120},
+			wantUnknown:   []uint64{99, 102, 105},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			db, err := OpenChainDB(path, finalityDepth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, num := range tt.exported {
+				if err := db.PutExported(num, common.Hash{byte(num)}, 1000+num); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, head := range tt.heads {
+				if err := db.Prune(head); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// forgetting the unfinalized blocks leaves the finalized ones
+			if _, err := db.ForgetFrom(0); err != nil {
+				t.Fatal(err)
+			}
+			for _, num := range tt.wantExported {
+				if err := db.PutExported(num, common.Hash{byte(num)}, 1000+num); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the finalized ranges are kept across restarts
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if db, err = OpenChainDB(path, finalityDepth); err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			check := func(nums []uint64, want BlockStatus, wantOK bool) {
+				t.Helper()
+				for _, num := range nums {
+					rec, ok := db.Get(num)
+					if ok != wantOK || ok && rec.Status != want {
+						t.Errorf("block %d: got %v %v, want %v %v", num, rec.Status, ok, want, wantOK)
+					}
+					if ok && want == BlockExported && !rec.Written(common.Hash{byte(num)}) {
+						t.Errorf("block %d: record of another hash", num)
+					}
+					if ok && want == BlockFinalized && !rec.Written(common.Hash{0xff}) {
+						t.Errorf("block %d: finalized block is not written", num)
+					}
+				}
+			}
+			check(tt.wantFinalized, BlockFinalized, true)
+			check(tt.wantExported, BlockExported, true)
+			check(tt.wantUnknown, 0, false)
+		})
+	}
+}
+
+func TestChainDBReorgs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := OpenChainDB(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := uint64(1); want <= 3; want++ {
+		if got, err := db.AddReorg(); err != nil || got != want {
+			t.Fatalf("got %d %v, want %d", got, err, want)
+		}
+	}
+	// the count is kept across restarts
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = OpenChainDB(path, 10); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got, err := db.Reorgs(); err != nil || got != 3 {
+		t.Fatalf("got %d %v after reopening, want 3", got, err)
+	}
+}
+
+func TestChainDBPruneBlockTimes(t *testing.T) {
+	const finalityDepth = 10
+	db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), finalityDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.IndexTimes()
+	const head = 400
+	for num := uint64(0); num <= head; num++ {
+		if err := db.PutExported(num, common.Hash{byte(num)}, 1000+num); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Prune(head); err != nil {
+		t.Fatal(err)
+	}
+	// the L2 chains look up times up to maxL1OriginLag blocks before the finalized blocks
+	keep := uint64(head - finalityDepth - maxL1OriginLag)
+	for num := uint64(0); num <= head; num++ {
+		blTime, ok, err := db.BlockTime(num)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (num >= keep) || ok && blTime != 1000+num {
+			t.Errorf("block %d: got time %d %v, want it kept: %v", num, blTime, ok, num >= keep)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-core/forks"
+	"github.com/ethereum-optimism/optimism/op-node/rollup/derive"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"sync"
+)
+
+// number of L1 blocks of which the deposit events are kept, between the receipts stage and the metrics
+const depositCacheSize = 256
+
+// l1Deposits are the source hashes of the user deposits that an L1 block emitted.
+type l1Deposits struct {
+	sources map[common.Hash]struct{}
+}
+
+// DepositMatcher matches the user deposits of an OP Stack chain to the TransactionDeposited events
+// of the OptimismPortal on the L1 chain. Deposits are only included in the first block of their L1 origin,
+// so only the events of the L1 origin are fetched. The events are looked up ahead of the metrics,
+// by the receipts source of the chain, and cached per L1 block.
+type DepositMatcher struct {
+	l1 *Chain
+	// the OptimismPortal
+	portal common.Address
+
+	mu    sync.Mutex
+	cache map[common.Hash]*l1Deposits
+	// cache keys, oldest first
+	order []common.Hash
+}
+
+func NewDepositMatcher(l1 *Chain, portal common.Address) *DepositMatcher {
+	return &DepositMatcher{l1: l1, portal: portal, cache: make(map[common.Hash]*l1Deposits)}
+}
+
+func (m *DepositMatcher) cached(l1Hash common.Hash) *l1Deposits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cache[l1Hash]
+}
+
+// Prefetch fetches the deposit events of the L1 origin of the block, if the block includes user deposits.
+func (m *DepositMatcher) Prefetch(ctx context.Context, bl *types.Block) error {
+	info := blockL1Info(bl)
+	if !hasUserDeposits(info, bl) {
+		return nil
+	}
+	if info == nil {
+		return fmt.Errorf("block %d has user deposits but no L1 info", bl.NumberU64())
+	}
+	if m.cached(info.BlockHash) != nil {
+		return nil
+	}
+	var logs []types.Log
+	if err := m.l1.EthRPC.CallContext(ctx, &logs, "eth_getLogs", map[string]any{
+		"blockHash": info.BlockHash,
+		"address":   m.portal,
+		"topics":    [][]common.Hash{{derive.DepositEventABIHash}},
+	}); err != nil {
+		return fmt.Errorf("failed to get deposit events of L1 block %d (%s): %w", info.Number, info.BlockHash, err)
+	}
+	deps := &l1Deposits{sources: make(map[common.Hash]struct{}, len(logs))}
+	for _, l := range logs {
+		if l.BlockHash != info.BlockHash {
+			return fmt.Errorf("got deposit event of L1 block %s, but expected %s", l.BlockHash, info.BlockHash)
+		}
+		src := derive.UserDepositSource{L1BlockHash: l.BlockHash, LogIndex: uint64(l.Index)}
+		deps.sources[src.SourceHash()] = struct{}{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cache[info.BlockHash]; !ok {
+		m.cache[info.BlockHash] = deps
+		m.order = append(m.order, info.BlockHash)
+		if len(m.order) > depositCacheSize {
+			delete(m.cache, m.order[0])
+			m.order = m.order[1:]
+		}
+	}
+	return nil
+}
+
+// Match returns the L1 time of the deposit event of the user deposit, and false if it was not found:
+// if the events of the L1 origin were not fetched.
+func (m *DepositMatcher) Match(info *L1Info, tx *types.Transaction) (uint64, bool) {
+	deps := m.cached(info.BlockHash)
+	if deps == nil {
+		return 0, false
+	}
+	if _, ok := deps.sources[tx.SourceHash()]; !ok {
+		return 0, false
+	}
+	return info.Time, true
+}
+
+// DepositReceiptsSource prefetches the L1 deposit events of the blocks of which it fetches the receipts.
+// Failing to get the events does not fail the receipts, the deposits of the block are then not matched.
+type DepositReceiptsSource struct {
+	ReceiptsSource
+	log     log.Logger
+	matcher *DepositMatcher
+}
+
+func NewDepositReceiptsSource(log log.Logger, src ReceiptsSource, matcher *DepositMatcher) *DepositReceiptsSource {
+	return &DepositReceiptsSource{ReceiptsSource: src, log: log, matcher: matcher}
+}
+
+func (s *DepositReceiptsSource) FetchReceipts(ctx context.Context, bl *types.Block) (types.Receipts, error) {
+	receipts, err := s.ReceiptsSource.FetchReceipts(ctx, bl)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.matcher.Prefetch(ctx, bl); err != nil && ctx.Err() == nil {
+		s.log.Warn("failed to match deposits to L1", "number", bl.NumberU64(), "err", err)
+	}
+	return receipts, nil
+}
+
+// depositKind is the kind of a deposit transaction, by the domain of its source hash.
+// The values are the indices of the kinds in the deposit_txs metric.
+type depositKind int
+
+const (
+	l1InfoDeposit depositKind = iota
+	userDeposit
+	upgradeDeposit
+)
+
+// upgradeDepositSources are the source hashes of the deposits of the network upgrades.
+var upgradeDepositSources = mustUpgradeDepositSources()
+
+func mustUpgradeDepositSources() map[common.Hash]struct{} {
+	var all []hexutil.Bytes
+	for _, fn := range []func() ([]hexutil.Bytes, error){
+		derive.EcotoneNetworkUpgradeTransactions,
+		derive.FjordNetworkUpgradeTransactions,
+		derive.IsthmusNetworkUpgradeTransactions,
+		derive.JovianNetworkUpgradeTransactions,
+		func() ([]hexutil.Bytes, error) {
+			txs, _, err := derive.UpgradeTransactions(forks.Karst)
+			return txs, err
+		},
+		func() ([]hexutil.Bytes, error) {
+			txs, _, err := derive.LagoonActivationUpgradeTransactions(true)
+			return txs, err
+		},
+	} {
+		txs, err := fn()
+		if err != nil {
+			panic(fmt.Errorf("invalid built-in upgrade transactions: %w", err))
+		}
+		all = append(all, txs...)
+	}
+	out := make(map[common.Hash]struct{}, len(all))
+	for _, data := range all {
+		var tx types.Transaction
+		if err := tx.UnmarshalBinary(data); err != nil {
+			panic(fmt.Errorf("invalid built-in upgrade transaction: %w", err))
+		}
+		out[tx.SourceHash()] = struct{}{}
+	}
+	return out
+}
+
+// depositKindOf classifies the deposit transaction by the domain of its source hash.
+// The source hash of the L1 attributes deposit is derived from the L1 info of the block,
+// and those of upgrade deposits from the intents of the network upgrades. Other deposits are user deposits.
+// Without L1 info, the deposit at the start of the block is taken as the L1 attributes deposit.
+func depositKindOf(info *L1Info, i int, tx *types.Transaction) depositKind {
+	if info == nil && i == 0 {
+		return l1InfoDeposit
+	}
+	if info != nil {
+		src := derive.L1InfoDepositSource{L1BlockHash: info.BlockHash, SeqNumber: info.SequenceNumber}
+		if tx.SourceHash() == src.SourceHash() {
+			return l1InfoDeposit
+		}
+	}
+	if _, ok := upgradeDepositSources[tx.SourceHash()]; ok {
+		return upgradeDeposit
+	}
+	return userDeposit
+}
+
+// userDeposits returns the user deposits of the block.
+func userDeposits(info *L1Info, bl *types.Block) []*types.Transaction {
+	var out []*types.Transaction
+	for i, tx := range bl.Transactions() {
+		if tx.Type() == types.DepositTxType && depositKindOf(info, i, tx) == userDeposit {
+			out = append(out, tx)
+		}
+	}
+	return out
+}
+
+func hasUserDeposits(info *L1Info, bl *types.Block) bool {
+	txs := bl.Transactions()
+	// user deposits come right after the L1 attributes deposit, before upgrade deposits
+	return len(txs) > 1 && txs[1].Type() == types.DepositTxType && depositKindOf(info, 1, txs[1]) == userDeposit
+}
+
+// DepositMetrics creates the deposit metrics of OP Stack blocks:
+//   - deposit_txs: deposit transactions, by kind: system (the L1 attributes deposit), user, or upgrade.
+//   - deposit_system_txs: deposits that are flagged as system transaction, which are free and use no block gas.
+//   - deposit_failed_txs: deposits that failed, the mint is still credited.
+//   - deposit_mint: ETH minted by deposits, in gwei.
+//   - deposit_gas_limit: histogram of the gas limit of user deposits, which is bought on L1.
+//   - deposit_inclusion_latency: histogram of the time from the L1 deposit event to the L2 block, in seconds.
+//   - deposit_unmatched_txs: user deposits without a known L1 deposit event, e.g. without a linked L1 chain.
+//
+// The matcher may be nil.
+func DepositMetrics(matcher *DepositMatcher) AggregateMetric[*BlockWithReceipts] {
+	return CombineAggregates[*BlockWithReceipts](
+		ParametrizedMetric[*BlockWithReceipts]("deposit_txs", "kind", []string{"system", "user", "upgrade"},
+			func(elem *BlockWithReceipts, dest []float64) error {
+				info := blockL1Info(elem.Block)
+				for i, tx := range elem.Block.Transactions() {
+					if tx.Type() == types.DepositTxType {
+						dest[depositKindOf(info, i, tx)] += 1
+					}
+				}
+				return nil
+			}),
+		Aggregate[*BlockWithReceipts](
+			Metric[*BlockWithReceipts]{
+				Name: "deposit_system_txs",
+				Fn: func(elem *BlockWithReceipts) (float64, error) {
+					n := 0
+					for _, tx := range elem.Block.Transactions() {
+						if tx.Type() == types.DepositTxType && tx.IsSystemTx() {
+							n += 1
+						}
+					}
+					return float64(n), nil
+				},
+			},
+			Metric[*BlockWithReceipts]{
+				Name: "deposit_failed_txs",
+				Fn: func(elem *BlockWithReceipts) (float64, error) {
+					n := 0
+					for i, tx := range elem.Block.Transactions() {
+						if tx.Type() == types.DepositTxType && elem.Receipts[i].Status != types.ReceiptStatusSuccessful {
+							n += 1
+						}
+					}
+					return float64(n), nil
+				},
+			},
+			Metric[*BlockWithReceipts]{
+				Name: "deposit_mint",
+				Fn: func(elem *BlockWithReceipts) (float64, error) {
+					var total float64
+					for _, tx := range elem.Block.Transactions() {
+						if tx.Type() == types.DepositTxType && tx.Mint() != nil {
+							total += GweiFloat64(tx.Mint())
+						}
+					}
+					return total, nil
+				},
+			},
+			Metric[*BlockWithReceipts]{
+				Name: "deposit_unmatched_txs",
+				Fn: func(elem *BlockWithReceipts) (float64, error) {
+					info := blockL1Info(elem.Block)
+					n := 0
+					for _, tx := range userDeposits(info, elem.Block) {
+						if matcher == nil || info == nil {
+							n += 1
+						} else if _, ok := matcher.Match(info, tx); !ok {
+							n += 1
+						}
+					}
+					return float64(n), nil
+				},
+			},
+		),
+		Histogram[*BlockWithReceipts]("deposit_gas_limit", []float64{
+			21_000,
+			50_000,
+			100_000,
+			250_000,
+			1_000_000,
+			4_000_000,
+			8_000_000,
+			30_000_000,
+		}, func(elem *BlockWithReceipts, add func(v float64)) error {
+			for _, tx := range userDeposits(blockL1Info(elem.Block), elem.Block) {
+				add(float64(tx.Gas()))
+			}
+			return nil
+		}),
+		Histogram[*BlockWithReceipts]("deposit_inclusion_latency", []float64{
+			12,
+			24,
+			60,
+			120,
+			300,
+			600,
+			1200,
+			3600,
+		}, func(elem *BlockWithReceipts, add func(v float64)) error {
+			if matcher == nil {
+				return nil
+			}
+			info := blockL1Info(elem.Block)
+			if info == nil {
+				return nil
+			}
+			for _, tx := range userDeposits(info, elem.Block) {
+				if l1Time, ok := matcher.Match(info, tx); ok {
+					add(float64(elem.Block.Time()) - float64(l1Time))
+				}
+			}
+			return nil
+		}),
+	)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/ethereum-optimism/optimism/op-node/rollup/derive"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"testing"
+)
+
+func TestDepositKindOf(t *testing.T) {
+	info := &L1Info{BlockHash: common.Hash{0xaa}, SequenceNumber: 3}
+	deposit := func(src interface{ SourceHash() common.Hash }) *types.Transaction {
+		return types.NewTx(&types.DepositTx{SourceHash: src.SourceHash(), Gas: 100_000})
+	}
+	ecotone, err := derive.EcotoneNetworkUpgradeTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upgrade types.Transaction
+	if err := upgrade.UnmarshalBinary(ecotone[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	l1InfoTx := deposit(&derive.L1InfoDepositSource{L1BlockHash: info.BlockHash, SeqNumber: 3})
+	userTx := deposit(&derive.UserDepositSource{L1BlockHash: info.BlockHash, LogIndex: 3})
+	tests := []struct {
+		name string
+		info *L1Info
+		// index of the deposit in the block
+		i    int
+		tx   *types.Transaction
+		want depositKind
+	}{
+		{name: "l1 info", info: info, tx: l1InfoTx, want: l1InfoDeposit},
+		{name: "l1 info of another sequence number", info: info, tx: deposit(&derive.L1InfoDepositSource{L1BlockHash: info.BlockHash, SeqNumber: 2}), want: userDeposit},
+		{name: "first deposit without l1 info", tx: userTx, want: l1InfoDeposit},
+		{name: "user", info: info, i: 1, tx: userTx, want: userDeposit},
+		{name: "user without l1 info", i: 1, tx: userTx, want: userDeposit},
+		{name: "upgrade", info: info, i: 1, tx: &upgrade, want: upgradeDeposit},
+		{name: "upgrade of a later fork", info: info, i: 1, tx: deposit(&derive.UpgradeDepositSource{Intent: "Isthmus: EIP-2935 Contract Deployment"}), want: upgradeDeposit},
+		{name: "unknown upgrade", info: info, i: 1, tx: deposit(&derive.UpgradeDepositSource{Intent: "unknown"}), want: userDeposit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := depositKindOf(tt.info, tt.i, tt.tx); got != tt.want {
+				t.Errorf("got kind %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	block := func(txs ...*types.Transaction) *types.Block {
+		return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)}).WithBody(types.Body{Transactions: txs})
+	}
+	blocks := []struct {
+		name  string
+		block *types.Block
+		users int
+	}{
+		{name: "only l1 info", block: block(l1InfoTx)},
+		{name: "upgrade", block: block(l1InfoTx, &upgrade)},
+		{name: "user and upgrade", block: block(l1InfoTx, userTx, &upgrade), users: 1},
+	}
+	for _, tt := range blocks {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userDeposits(info, tt.block); len(got) != tt.users {
+				t.Errorf("got %d user deposits, want %d", len(got), tt.users)
+			}
+			if got := hasUserDeposits(info, tt.block); got != (tt.users > 0) {
+				t.Errorf("got has user deposits %v, want %v", got, tt.users > 0)
+			}
+		})
+	}
+}
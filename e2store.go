@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/snappy"
+	"io"
+)
+
+// e2store record types, see https://github.com/status-im/nimbus-eth2/blob/stable/docs/e2store.md
+// and https://github.com/eth-clients/e2store-format-specs/blob/main/formats/era1.md
+var (
+	e2Version                     = [2]byte{0x65, 0x32}
+	e2CompressedSignedBeaconBlock = [2]byte{0x01, 0x00}
+	e2CompressedHeader            = [2]byte{0x03, 0x00}
+	e2CompressedBody              = [2]byte{0x04, 0x00}
+	e2CompressedReceipts          = [2]byte{0x05, 0x00}
+	e2SlotIndex                   = [2]byte{0x69, 0x32}
+	e2BlockIndex                  = [2]byte{0x66, 0x32}
+)
+
+// size of an e2store record header: 2 bytes type, 4 bytes little-endian length, 2 reserved bytes
+const e2HeaderSize = 8
+
+type e2Header struct {
+	Type   [2]byte
+	Length uint32
+}
+
+func readE2Header(r io.ReaderAt, pos int64) (e2Header, error) {
+	var buf [e2HeaderSize]byte
+	if _, err := r.ReadAt(buf[:], pos); err != nil {
+		return e2Header{}, fmt.Errorf("failed to read e2store header at %d: %w", pos, err)
+	}
+	if buf[6] != 0 || buf[7] != 0 {
+		return e2Header{}, fmt.Errorf("e2store header at %d has non-zero reserved bytes", pos)
+	}
+	return e2Header{Type: [2]byte{buf[0], buf[1]}, Length: binary.LittleEndian.Uint32(buf[2:6])}, nil
+}
+
+// readE2Record reads the data of the record at the given position, and checks that it has the expected type.
+func readE2Record(r io.ReaderAt, pos int64, typ [2]byte) ([]byte, error) {
+	h, err := readE2Header(r, pos)
+	if err != nil {
+		return nil, err
+	}
+	if h.Type != typ {
+		return nil, fmt.Errorf("expected e2store record type %x at %d, but got %x", typ, pos, h.Type)
+	}
+	data := make([]byte, h.Length)
+	if _, err := r.ReadAt(data, pos+e2HeaderSize); err != nil {
+		return nil, fmt.Errorf("failed to read e2store record at %d: %w", pos, err)
+	}
+	return data, nil
+}
+
+// readE2Compressed reads and decompresses the snappy-framed data of the record at the given position.
+func readE2Compressed(r io.ReaderAt, pos int64, typ [2]byte) ([]byte, error) {
+	data, err := readE2Record(r, pos, typ)
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(snappy.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress e2store record at %d: %w", pos, err)
+	}
+	return out, nil
+}
+
+// e2IndexRecord is a decoded slot index or block index: for each slot or block since the start,
+// the absolute file position of its record, or 0 if there is none.
+type e2IndexRecord struct {
+	Start     uint64
+	Positions []int64
+}
+
+// readE2Index reads the index record of the given type that ends at the given position.
+// The offsets in the record are relative to the start of the record, these are converted to absolute positions.
+func readE2Index(r io.ReaderAt, end int64, typ [2]byte) (*e2IndexRecord, error) {
+	var buf [8]byte
+	if end < e2HeaderSize+16 {
+		return nil, fmt.Errorf("no room for index before %d", end)
+	}
+	if _, err := r.ReadAt(buf[:], end-8); err != nil {
+		return nil, fmt.Errorf("failed to read index count: %w", err)
+	}
+	count := binary.LittleEndian.Uint64(buf[:])
+	size := int64(e2HeaderSize + 8 + 8*count + 8)
+	if count > 1<<24 || size > end {
+		return nil, fmt.Errorf("invalid index count %d before %d", count, end)
+	}
+	start := end - size
+	data, err := readE2Record(r, start, typ)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size-e2HeaderSize {
+		return nil, fmt.Errorf("index at %d has unexpected length %d", start, len(data))
+	}
+	out := &e2IndexRecord{
+		Start:     binary.LittleEndian.Uint64(data[:8]),
+		Positions: make([]int64, count),
+	}
+	for i := range out.Positions {
+		offset := int64(binary.LittleEndian.Uint64(data[8+8*i:]))
+		if offset != 0 {
+			out.Positions[i] = start + offset
+		}
+	}
+	return out, nil
+}
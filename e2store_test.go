@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/golang/snappy"
+	"strings"
+	"testing"
+)
+
+// e2Writer builds an e2store file in memory
+type e2Writer struct {
+	buf bytes.Buffer
+}
+
+// record appends a record, and returns its position
+func (w *e2Writer) record(typ [2]byte, data []byte) int64 {
+	pos := int64(w.buf.Len())
+	w.buf.Write(typ[:])
+	w.buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	w.buf.Write([]byte{0, 0})
+	w.buf.Write(data)
+	return pos
+}
+
+// compressed appends a record with the snappy-framed data, and returns its position
+func (w *e2Writer) compressed(typ [2]byte, data []byte) int64 {
+	var buf bytes.Buffer
+	sw := snappy.NewBufferedWriter(&buf)
+	if _, err := sw.Write(data); err != nil {
+		panic(err)
+	}
+	if err := sw.Close(); err != nil {
+		panic(err)
+	}
+	return w.record(typ, buf.Bytes())
+}
+
+// index appends an index record of the absolute positions, 0 for a missing entry
+func (w *e2Writer) index(typ [2]byte, start uint64, positions []int64) int64 {
+	pos := int64(w.buf.Len())
+	data := binary.LittleEndian.AppendUint64(nil, start)
+	for _, p := range positions {
+		offset := int64(0)
+		if p != 0 {
+			offset = p - pos
+		}
+		data = binary.LittleEndian.AppendUint64(data, uint64(offset))
+	}
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(positions)))
+	return w.record(typ, data)
+}
+
+func TestReadE2Record(t *testing.T) {
+	var w e2Writer
+	versionPos := w.record(e2Version, nil)
+	blockPos := w.compressed(e2CompressedSignedBeaconBlock, []byte("beacon block"))
+	rawPos := w.record(e2CompressedHeader, []byte("not snappy"))
+	reservedPos := w.record(e2CompressedHeader, []byte("header"))
+	w.buf.Bytes()[reservedPos+6] = 1
+	truncatedPos := w.record(e2CompressedHeader, []byte("header"))
+	data := w.buf.Bytes()[:w.buf.Len()-1]
+
+	tests := []struct {
+		name       string
+		pos        int64
+		typ        [2]byte
+		compressed bool
+		want       string
+		wantErr    string
+	}{
+		{name: "version", pos: versionPos, typ: e2Version, want: ""},
+		{name: "compressed", pos: blockPos, typ: e2CompressedSignedBeaconBlock, compressed: true, want: "beacon block"},
+		{name: "wrong type", pos: blockPos, typ: e2CompressedHeader, wantErr: "expected e2store record type 0300"},
+		{name: "not compressed", pos: rawPos, typ: e2CompressedHeader, compressed: true, wantErr: "failed to decompress"},
+		{name: "non-zero reserved bytes", pos: reservedPos, typ: e2CompressedHeader, wantErr: "non-zero reserved bytes"},
+		{name: "truncated", pos: truncatedPos, typ: e2CompressedHeader, wantErr: "failed to read e2store record"},
+		{name: "past the end", pos: int64(len(data)), typ: e2Version, wantErr: "failed to read e2store header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			var err error
+			if tt.compressed {
+				got, err = readE2Compressed(bytes.NewReader(data), tt.pos, tt.typ)
+			} else {
+				got, err = readE2Record(bytes.NewReader(data), tt.pos, tt.typ)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadE2Index(t *testing.T) {
+	tests := []struct {
+		name string
+		// writes the file, and returns the end of the index
+		write   func(w *e2Writer) int64
+		typ     [2]byte
+		want    *e2IndexRecord
+		wantErr string
+	}{
+		{
+			name: "slot index with empty slots",
+			write: func(w *e2Writer) int64 {
+				w.record(e2Version, nil)
+				w.record(e2CompressedSignedBeaconBlock, []byte{1})
+				w.record(e2CompressedSignedBeaconBlock, []byte{2})
+				w.index(e2SlotIndex, 8192, []int64{8, 0, 17})
+				return int64(w.buf.Len())
+			},
+			typ:  e2SlotIndex,
+			want: &e2IndexRecord{Start: 8192, Positions: []int64{8, 0, 17}},
+		},
+		{
+			name: "index followed by another index",
+			write: func(w *e2Writer) int64 {
+				w.record(e2Version, nil)
+				w.record(e2CompressedHeader, []byte{1})
+				w.index(e2BlockIndex, 16384, []int64{8})
+				end := int64(w.buf.Len())
+				w.index(e2SlotIndex, 0, []int64{0})
+				return end
+			},
+			typ:  e2BlockIndex,
+			want: &e2IndexRecord{Start: 16384, Positions: []int64{8}},
+		},
+		{
+			name: "wrong type",
+			write: func(w *e2Writer) int64 {
+				w.index(e2BlockIndex, 0, []int64{0})
+				return int64(w.buf.Len())
+			},
+			typ:     e2SlotIndex,
+			wantErr: "expected e2store record type",
+		},
+		{
+			name: "count larger than the file",
+			write: func(w *e2Writer) int64 {
+				w.record(e2Version, nil)
+				w.buf.Write(binary.LittleEndian.AppendUint64(make([]byte, 16), 100))
+				return int64(w.buf.Len())
+			},
+			typ:     e2SlotIndex,
+			wantErr: "invalid index count 100",
+		},
+		{
+			name: "too small",
+			write: func(w *e2Writer) int64 {
+				w.record(e2Version, nil)
+				return int64(w.buf.Len())
+			},
+			typ:     e2SlotIndex,
+			wantErr: "no room for index",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w e2Writer
+			end := tt.write(&w)
+			got, err := readE2Index(bytes.NewReader(w.buf.Bytes()), end, tt.typ)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Start != tt.want.Start || len(got.Positions) != len(tt.want.Positions) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got.Positions {
+				if got.Positions[i] != tt.want.Positions[i] {
+					t.Fatalf("got %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
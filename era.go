@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// number of slot indices of era files to keep in memory
+	eraIndexCacheSize = 8
+	// number of slots per era file
+	slotsPerEra = 8192
+)
+
+// eraFile is an era file, see https://github.com/status-im/nimbus-eth2/blob/stable/docs/e2store.md#era-files
+// The execution block numbers in the file are resolved once, and then cached.
+type eraFile struct {
+	path string
+	era  uint64
+
+	once sync.Once
+	err  error
+	// whether the file has execution payloads that can be decoded
+	hasPayloads bool
+	// position in the non-empty slots of the first block with an execution payload
+	firstPos int
+	// execution block number of the first block with an execution payload
+	firstNumber uint64
+	// number of blocks with an execution payload
+	count uint64
+}
+
+// EraStore reads execution blocks from a directory of beacon chain era files.
+// Era files are indexed by slot, the execution block numbers are derived from the non-empty slots:
+// after the merge, every beacon block has an execution payload with the next execution block number.
+type EraStore struct {
+	log      log.Logger
+	network  string
+	schedule *BeaconForkSchedule
+	files    []*eraFile
+
+	// positions of the blocks in the non-empty slots, by file path
+	indices *lru.Cache[string, []int64]
+}
+
+// OpenEraStore opens the era files in the directory.
+// The files must be named <network>-<era number>-<short historical root>.era, and be of a known network.
+func OpenEraStore(log log.Logger, dir string) (*EraStore, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.era"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list era files: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no era files in %q", dir)
+	}
+	s := &EraStore{log: log}
+	s.indices, err = lru.New[string, []int64](eraIndexCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		parts := strings.Split(strings.TrimSuffix(filepath.Base(p), ".era"), "-")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unexpected era file name %q", p)
+		}
+		if s.network == "" {
+			s.network = parts[0]
+		} else if s.network != parts[0] {
+			return nil, fmt.Errorf("era file %q is not of network %q", p, s.network)
+		}
+		era, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid era number in file name %q: %w", p, err)
+		}
+		s.files = append(s.files, &eraFile{path: p, era: era})
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].era < s.files[j].era })
+	for i := 1; i < len(s.files); i++ {
+		if s.files[i].era != s.files[i-1].era+1 {
+			return nil, fmt.Errorf("era files are not contiguous, missing era %d", s.files[i-1].era+1)
+		}
+	}
+	schedule, ok := beaconForkSchedules[s.network]
+	if !ok {
+		return nil, fmt.Errorf("unknown era files network %q", s.network)
+	}
+	s.schedule = schedule
+	log.Info("opened era store", "network", s.network, "first_era", s.files[0].era, "last_era", s.files[len(s.files)-1].era)
+	return s, nil
+}
+
+// index reads the positions of the blocks in the non-empty slots of the file.
+func (s *EraStore) index(f *eraFile) ([]int64, error) {
+	if positions, ok := s.indices.Get(f.path); ok {
+		return positions, nil
+	}
+	fh, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readE2Record(fh, 0, e2Version); err != nil {
+		return nil, fmt.Errorf("invalid era file %q: %w", f.path, err)
+	}
+	// the state index is at the end of the file, the block index precedes it, except in era 0 which has no blocks
+	var positions []int64
+	if f.era > 0 {
+		stateIndexSize := int64(e2HeaderSize + 8 + 8 + 8)
+		blockIndex, err := readE2Index(fh, info.Size()-stateIndexSize, e2SlotIndex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block index of %q: %w", f.path, err)
+		}
+		if start, _ := f.slots(); blockIndex.Start != start {
+			return nil, fmt.Errorf("block index of %q starts at slot %d, expected %d", f.path, blockIndex.Start, start)
+		}
+		for _, pos := range blockIndex.Positions {
+			if pos != 0 {
+				positions = append(positions, pos)
+			}
+		}
+	}
+	s.indices.Add(f.path, positions)
+	return positions, nil
+}
+
+// readBlock reads the execution block of the beacon block at the given position.
+// It returns nil if the beacon block has no execution payload.
+func (s *EraStore) readBlock(f *eraFile, pos int64) (*types.Block, error) {
+	fh, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	data, err := readE2Compressed(fh, pos, e2CompressedSignedBeaconBlock)
+	if err != nil {
+		return nil, err
+	}
+	p, err := beaconBlockPayload(data, s.schedule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode beacon block at %d of %q: %w", pos, f.path, err)
+	}
+	if p.payload == nil {
+		return nil, nil
+	}
+	bl, err := decodeExecutionPayload(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode execution payload of slot %d: %w", p.slot, err)
+	}
+	return bl, nil
+}
+
+// resolve determines the range of execution blocks of the file
+func (s *EraStore) resolve(f *eraFile) error {
+	f.once.Do(func() {
+		// files before bellatrix have no payloads
+		_, end := f.slots()
+		if end == 0 || s.schedule.Fork(end-1) < ForkBellatrix {
+			return
+		}
+		positions, err := s.index(f)
+		if err != nil {
+			f.err = err
+			return
+		}
+		// find the first block with an execution payload, pre-merge blocks are all at the start
+		var searchErr error
+		firstPos := sort.Search(len(positions), func(i int) bool {
+			bl, err := s.readBlock(f, positions[i])
+			if err != nil {
+				if searchErr == nil {
+					searchErr = err
+				}
+				return true
+			}
+			return bl != nil
+		})
+		if searchErr != nil {
+			f.err = searchErr
+			return
+		}
+		if firstPos == len(positions) {
+			return
+		}
+		first, err := s.readBlock(f, positions[firstPos])
+		if err != nil {
+			f.err = err
+			return
+		}
+		f.hasPayloads = true
+		f.firstPos = firstPos
+		f.firstNumber = first.NumberU64()
+		f.count = uint64(len(positions) - firstPos)
+	})
+	return f.err
+}
+
+// slots returns the range of slots of the blocks in the file, the end is exclusive.
+// The file of era N has the blocks of the slots before the state at the start of era N.
+func (f *eraFile) slots() (start, end uint64) {
+	if f.era == 0 {
+		return 0, 0
+	}
+	return (f.era - 1) * slotsPerEra, f.era * slotsPerEra
+}
+
+// BlockByNumber reads the execution block with the given number from the era files,
+// or returns errNotInArchive if the era files do not have it.
+func (s *EraStore) BlockByNumber(ctx context.Context, num uint64) (*types.Block, error) {
+	var searchErr error
+	i := sort.Search(len(s.files), func(i int) bool {
+		f := s.files[i]
+		if err := s.resolve(f); err != nil {
+			if searchErr == nil {
+				searchErr = err
+			}
+			return true
+		}
+		// files without payloads are before the merge
+		if !f.hasPayloads {
+			return false
+		}
+		return num < f.firstNumber+f.count
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	if i == len(s.files) || !s.files[i].hasPayloads || num < s.files[i].firstNumber {
+		return nil, errNotInArchive
+	}
+	f := s.files[i]
+	positions, err := s.index(f)
+	if err != nil {
+		return nil, err
+	}
+	bl, err := s.readBlock(f, positions[f.firstPos+int(num-f.firstNumber)])
+	if err != nil {
+		return nil, err
+	}
+	if bl == nil || bl.NumberU64() != num {
+		return nil, fmt.Errorf("era file %q is inconsistent, expected block %d", f.path, num)
+	}
+	return bl, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// number of blocks per era1 file
+const blocksPerEra1 = 8192
+
+// Era1Store reads pre-merge execution blocks and receipts from a directory of era1 files.
+// See https://github.com/eth-clients/e2store-format-specs/blob/main/formats/era1.md
+//
+// Every era1 file has a block index, pointing to the header of each block,
+// which is followed by the body, the receipts and the total difficulty of the block.
+type Era1Store struct {
+	log    log.Logger
+	config *params.ChainConfig
+	// paths of the files, by era1 epoch
+	files map[uint64]string
+
+	// positions of the block headers, by file path
+	indices *lru.Cache[string, []int64]
+}
+
+// OpenEra1Store opens the era1 files in the directory.
+// The files must be named <network>-<epoch>-<short accumulator root>.era1.
+// The chain config is used to derive the receipt fields that are not stored in the files.
+func OpenEra1Store(log log.Logger, dir string, config *params.ChainConfig) (*Era1Store, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.era1"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list era1 files: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no era1 files in %q", dir)
+	}
+	s := &Era1Store{log: log, config: config, files: make(map[uint64]string)}
+	s.indices, err = lru.New[string, []int64](eraIndexCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	network := ""
+	for _, p := range paths {
+		parts := strings.Split(strings.TrimSuffix(filepath.Base(p), ".era1"), "-")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unexpected era1 file name %q", p)
+		}
+		if network == "" {
+			network = parts[0]
+		} else if network != parts[0] {
+			return nil, fmt.Errorf("era1 file %q is not of network %q", p, network)
+		}
+		epoch, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid epoch in era1 file name %q: %w", p, err)
+		}
+		s.files[epoch] = p
+	}
+	log.Info("opened era1 store", "network", network, "files", len(s.files))
+	return s, nil
+}
+
+// index reads the positions of the block headers in the file of the given epoch.
+func (s *Era1Store) index(path string, epoch uint64) ([]int64, error) {
+	if positions, ok := s.indices.Get(path); ok {
+		return positions, nil
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readE2Record(fh, 0, e2Version); err != nil {
+		return nil, fmt.Errorf("invalid era1 file %q: %w", path, err)
+	}
+	blockIndex, err := readE2Index(fh, info.Size(), e2BlockIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block index of %q: %w", path, err)
+	}
+	if blockIndex.Start != epoch*blocksPerEra1 {
+		return nil, fmt.Errorf("block index of %q starts at block %d, expected %d", path, blockIndex.Start, epoch*blocksPerEra1)
+	}
+	s.indices.Add(path, blockIndex.Positions)
+	return blockIndex.Positions, nil
+}
+
+// era1Tuple is a block with its receipts, as stored in an era1 file
+type era1Tuple struct {
+	fh *os.File
+	// positions of the records of the block
+	header, body, receipts int64
+}
+
+// open opens the file with the given block, and finds the records of the block.
+// It returns errNotInArchive if there is no file with the block.
+// The caller must close the file of the returned tuple.
+func (s *Era1Store) open(num uint64) (*era1Tuple, error) {
+	epoch := num / blocksPerEra1
+	path, ok := s.files[epoch]
+	if !ok {
+		return nil, errNotInArchive
+	}
+	positions, err := s.index(path, epoch)
+	if err != nil {
+		return nil, err
+	}
+	i := num - epoch*blocksPerEra1
+	if i >= uint64(len(positions)) || positions[i] == 0 {
+		return nil, errNotInArchive
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &era1Tuple{fh: fh, header: positions[i]}
+	// the body and receipts directly follow the header
+	h, err := readE2Header(fh, t.header)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	t.body = t.header + e2HeaderSize + int64(h.Length)
+	if h, err = readE2Header(fh, t.body); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	t.receipts = t.body + e2HeaderSize + int64(h.Length)
+	return t, nil
+}
+
+func (t *era1Tuple) Header() (*types.Header, error) {
+	data, err := readE2Compressed(t.fh, t.header, e2CompressedHeader)
+	if err != nil {
+		return nil, err
+	}
+	var header types.Header
+	if err := rlp.DecodeBytes(data, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode header: %w", err)
+	}
+	return &header, nil
+}
+
+func (t *era1Tuple) Body() (*types.Body, error) {
+	data, err := readE2Compressed(t.fh, t.body, e2CompressedBody)
+	if err != nil {
+		return nil, err
+	}
+	var body types.Body
+	if err := rlp.DecodeBytes(data, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode body: %w", err)
+	}
+	return &body, nil
+}
+
+// Receipts decodes the receipts, which are stored in the consensus encoding,
+// or in the storage encoding by some older tools.
+func (t *era1Tuple) Receipts() (types.Receipts, error) {
+	data, err := readE2Compressed(t.fh, t.receipts, e2CompressedReceipts)
+	if err != nil {
+		return nil, err
+	}
+	var receipts types.Receipts
+	if err := rlp.DecodeBytes(data, &receipts); err == nil {
+		return receipts, nil
+	}
+	var stored []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode receipts: %w", err)
+	}
+	receipts = make(types.Receipts, len(stored))
+	for i, r := range stored {
+		receipts[i] = (*types.Receipt)(r)
+		receipts[i].Bloom = types.CreateBloom(receipts[i])
+	}
+	return receipts, nil
+}
+
+func (t *era1Tuple) Close() error {
+	return t.fh.Close()
+}
+
+// BlockByNumber reads the block from the era1 files, or returns errNotInArchive if the files do not have it.
+func (s *Era1Store) BlockByNumber(ctx context.Context, num uint64) (*types.Block, error) {
+	t, err := s.open(num)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	header, err := t.Header()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of block %d: %w", num, err)
+	}
+	if header.Number.Uint64() != num {
+		return nil, fmt.Errorf("era1 file is inconsistent, expected block %d but got %d", num, header.Number.Uint64())
+	}
+	body, err := t.Body()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body of block %d: %w", num, err)
+	}
+	if txHash := types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)); txHash != header.TxHash {
+		return nil, fmt.Errorf("era1 file is inconsistent, transactions of block %d do not match the header", num)
+	}
+	return types.NewBlockWithHeader(header).WithBody(types.Body{Transactions: body.Transactions, Uncles: body.Uncles}), nil
+}
+
+// FetchReceipts reads the receipts of the block from the era1 files,
+// or returns errNotInArchive if the files do not have the block.
+func (s *Era1Store) FetchReceipts(ctx context.Context, bl *types.Block) (types.Receipts, error) {
+	t, err := s.open(bl.NumberU64())
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	header, err := t.Header()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of block %d: %w", bl.NumberU64(), err)
+	}
+	if header.Hash() != bl.Hash() {
+		// e.g. a block of another chain, let the fallback deal with it
+		return nil, errNotInArchive
+	}
+	receipts, err := t.Receipts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read receipts of block %d: %w", bl.NumberU64(), err)
+	}
+	txs := bl.Transactions()
+	if len(receipts) != len(txs) {
+		return nil, fmt.Errorf("got %d receipts but block %d has %d transactions", len(receipts), bl.NumberU64(), len(txs))
+	}
+	// the archive only has the consensus fields, or even less with the storage encoding, which lacks the type
+	if err := receipts.DeriveFields(s.config, bl.Hash(), bl.NumberU64(), bl.Time(), bl.BaseFee(), nil, txs); err != nil {
+		return nil, fmt.Errorf("failed to derive receipt fields of block %d: %w", bl.NumberU64(), err)
+	}
+	if receiptHash := types.DeriveSha(receipts, trie.NewStackTrie(nil)); receiptHash != header.ReceiptHash {
+		return nil, fmt.Errorf("era1 file is inconsistent, receipts of block %d do not match the header", bl.NumberU64())
+	}
+	return receipts, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// era1TestBlock is a block of an era1 file
+type era1TestBlock struct {
+	number uint64
+	// whether the receipts are in the storage encoding, instead of the consensus encoding
+	storageReceipts bool
+	// number in the header, if it differs from the number of the block
+	headerNumber uint64
+}
+
+// writeEra1File writes an era1 file of the epoch with the given blocks, and returns the blocks by number.
+func writeEra1File(t *testing.T, dir string, epoch uint64, blocks []era1TestBlock) map[uint64]*types.Block {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[uint64]*types.Block)
+	var w e2Writer
+	w.record(e2Version, nil)
+	positions := make([]int64, blocksPerEra1)
+	for _, b := range blocks {
+		tx, err := types.SignNewTx(key, types.HomesteadSigner{}, &types.LegacyTx{
+			Nonce: b.number, GasPrice: big.NewInt(20e9), Gas: 50000, To: &common.Address{0xaa}, Value: big.NewInt(1),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		receipt := &types.Receipt{
+			PostState:         common.Hash{byte(b.number)}.Bytes(),
+			CumulativeGasUsed: 30000,
+			Logs:              []*types.Log{{Address: common.Address{0xaa}, Topics: []common.Hash{{0x01}}, Data: []byte{2}}},
+		}
+		receipt.Bloom = types.CreateBloom(receipt)
+		txs, receipts := types.Transactions{tx}, types.Receipts{receipt}
+		num := b.number
+		if b.headerNumber != 0 {
+			num = b.headerNumber
+		}
+		header := &types.Header{
+			ParentHash:  common.Hash{byte(b.number - 1)},
+			UncleHash:   types.EmptyUncleHash,
+			Coinbase:    common.Address{0xcb},
+			Root:        common.Hash{0x01},
+			TxHash:      types.DeriveSha(txs, trie.NewStackTrie(nil)),
+			ReceiptHash: types.DeriveSha(receipts, trie.NewStackTrie(nil)),
+			Bloom:       types.MergeBloom(receipts),
+			Difficulty:  big.NewInt(17_000_000_000),
+			Number:      new(big.Int).SetUint64(num),
+			GasLimit:    3_141_592,
+			GasUsed:     30000,
+			Time:        1_440_000_000 + b.number*15,
+		}
+		out[b.number] = types.NewBlockWithHeader(header).WithBody(types.Body{Transactions: txs})
+
+		headerData, err := rlp.EncodeToBytes(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodyData, err := rlp.EncodeToBytes(&types.Body{Transactions: txs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var receiptsData []byte
+		if b.storageReceipts {
+			receiptsData, err = rlp.EncodeToBytes([]*types.ReceiptForStorage{(*types.ReceiptForStorage)(receipt)})
+		} else {
+			receiptsData, err = rlp.EncodeToBytes(receipts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions[b.number-epoch*blocksPerEra1] = w.compressed(e2CompressedHeader, headerData)
+		w.compressed(e2CompressedBody, bodyData)
+		w.compressed(e2CompressedReceipts, receiptsData)
+		w.record([2]byte{0x06, 0x00}, make([]byte, 32)) // total difficulty
+	}
+	w.record([2]byte{0x07, 0x00}, make([]byte, 32)) // accumulator
+	w.index(e2BlockIndex, epoch*blocksPerEra1, positions)
+	if err := os.WriteFile(filepath.Join(dir, "mainnet-00002-5f5d4516.era1"), w.buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestEra1Store(t *testing.T) {
+	dir := t.TempDir()
+	blocks := writeEra1File(t, dir, 2, []era1TestBlock{
+		{number: 16384},
+		{number: 16385, storageReceipts: true},
+		{number: 16387, headerNumber: 16386},
+	})
+	s, err := OpenEra1Store(log.NewLogger(log.DiscardHandler()), dir, params.MainnetChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := blocks[16384].WithSeal(&types.Header{Number: big.NewInt(16385), Difficulty: new(big.Int)})
+
+	tests := []struct {
+		name string
+		num  uint64
+		// block to fetch the receipts of, the receipts are not fetched if nil
+		receiptsOf *types.Block
+		wantErr    string
+		// whether the error is errNotInArchive
+		wantNotInArchive bool
+	}{
+		{name: "block", num: 16384, receiptsOf: blocks[16384]},
+		{name: "receipts in storage encoding", num: 16385, receiptsOf: blocks[16385]},
+		{name: "missing block", num: 16386, wantNotInArchive: true},
+		{name: "missing file", num: 8191, wantNotInArchive: true},
+		{name: "inconsistent header", num: 16387, wantErr: "expected block 16387 but got 16386"},
+		{name: "receipts of another chain", num: 16385, receiptsOf: other, wantNotInArchive: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var receipts types.Receipts
+			bl, err := s.BlockByNumber(context.Background(), tt.num)
+			if err == nil && tt.receiptsOf != nil {
+				receipts, err = s.FetchReceipts(context.Background(), tt.receiptsOf)
+			}
+			if tt.wantNotInArchive {
+				if !errors.Is(err, errNotInArchive) {
+					t.Fatalf("got error %v, want not in archive", err)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := blocks[tt.num]
+			if bl.Hash() != want.Hash() || len(bl.Transactions()) != 1 {
+				t.Fatalf("got block %s, want %s", bl.Hash(), want.Hash())
+			}
+			if len(receipts) != 1 {
+				t.Fatalf("got %d receipts", len(receipts))
+			}
+			r := receipts[0]
+			if r.TxHash != want.Transactions()[0].Hash() || r.BlockHash != want.Hash() || r.GasUsed != 30000 ||
+				len(r.Logs) != 1 || r.Logs[0].TxHash != r.TxHash || r.Bloom != want.Bloom() {
+				t.Errorf("receipt fields are not derived: %+v", r)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// eraTestBlock is a beacon block in an era file, at the given slot in the era
+type eraTestBlock struct {
+	slot uint64
+	// execution block number, or -1 for an empty pre-merge payload
+	number int64
+}
+
+// writeEraFile writes an era file of the network with the given blocks, and returns the execution blocks by number.
+func writeEraFile(t *testing.T, dir string, network string, era uint64, blocks []eraTestBlock) map[uint64]common.Hash {
+	schedule := beaconForkSchedules[network]
+	out := make(map[uint64]common.Hash)
+	var w e2Writer
+	w.record(e2Version, nil)
+	// the blocks, the state, and then the block index and the state index
+	positions := make([]int64, slotsPerEra)
+	if era > 0 {
+		for _, b := range blocks {
+			slot := (era-1)*slotsPerEra + b.slot
+			fork := schedule.Fork(slot)
+			parentRoot := common.Hash{byte(b.slot)}
+			var payload, requests []byte
+			if b.number < 0 {
+				payload = emptySSZPayload(fork)
+			} else {
+				var reqs [][]byte
+				if fork >= ForkElectra {
+					reqs = [][]byte{{0}, {1}, {2}}
+					requests = sszContainer(sszVariable(nil), sszVariable(nil), sszVariable(nil))
+				}
+				bl := testExecutionBlock(t, uint64(b.number), fork, parentRoot, reqs)
+				payload = encodeSSZPayload(bl, fork)
+				out[bl.NumberU64()] = bl.Hash()
+			}
+			positions[b.slot] = w.compressed(e2CompressedSignedBeaconBlock, encodeSignedBeaconBlock(slot, parentRoot, fork, payload, requests))
+		}
+	}
+	state := w.compressed([2]byte{0x02, 0x00}, []byte("state"))
+	if era > 0 {
+		w.index(e2SlotIndex, (era-1)*slotsPerEra, positions)
+	}
+	w.index(e2SlotIndex, era*slotsPerEra, []int64{state})
+	name := fmt.Sprintf("%s-%05d-%08x.era", network, era, era)
+	if err := os.WriteFile(filepath.Join(dir, name), w.buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestEraStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		// blocks of the files, by era
+		files map[uint64][]eraTestBlock
+		// block numbers to read, and whether the files have them
+		reads map[uint64]bool
+	}{
+		{
+			name:    "merge",
+			network: "holesky",
+			files: map[uint64][]eraTestBlock{
+				0: nil,
+				1: {{slot: 1, number: -1}, {slot: 2, number: -1}, {slot: 4, number: 10}, {slot: 5, number: 11}, {slot: 8191, number: 12}},
+				2: {{slot: 0, number: 13}, {slot: 3, number: 14}},
+			},
+			reads: map[uint64]bool{0: false, 9: false, 10: true, 11: true, 12: true, 13: true, 14: true, 15: false},
+		},
+		{
+			name:    "deneb to electra",
+			network: "mainnet",
+			files: map[uint64][]eraTestBlock{
+				1422: {{slot: 8190, number: 500}, {slot: 8191, number: 501}},
+				1423: {{slot: 0, number: 502}, {slot: 2, number: 503}},
+			},
+			reads: map[uint64]bool{499: false, 500: true, 501: true, 502: true, 503: true, 504: false},
+		},
+		{
+			name:    "pre-merge only",
+			network: "sepolia",
+			files: map[uint64][]eraTestBlock{
+				1: {{slot: 3200, number: -1}, {slot: 3201, number: -1}},
+			},
+			reads: map[uint64]bool{0: false, 1: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			hashes := make(map[uint64]common.Hash)
+			for era, blocks := range tt.files {
+				for num, h := range writeEraFile(t, dir, tt.network, era, blocks) {
+					hashes[num] = h
+				}
+			}
+			s, err := OpenEraStore(log.NewLogger(log.DiscardHandler()), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for num, want := range tt.reads {
+				bl, err := s.BlockByNumber(context.Background(), num)
+				if !want {
+					if !errors.Is(err, errNotInArchive) {
+						t.Errorf("block %d: got error %v, want not in archive", num, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("block %d: %v", num, err)
+				} else if bl.Hash() != hashes[num] {
+					t.Errorf("block %d: got hash %s, want %s", num, bl.Hash(), hashes[num])
+				}
+			}
+		})
+	}
+}
+
+func TestOpenEraStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		wantErr string
+	}{
+		{name: "no files", wantErr: "no era files"},
+		{name: "unexpected name", files: []string{"mainnet-00001.era"}, wantErr: "unexpected era file name"},
+		{name: "mixed networks", files: []string{"mainnet-00001-aa.era", "sepolia-00002-bb.era"}, wantErr: "is not of network"},
+		{name: "not contiguous", files: []string{"mainnet-00001-aa.era", "mainnet-00003-bb.era"}, wantErr: "missing era 2"},
+		{name: "unknown network", files: []string{"devnet-00001-aa.era"}, wantErr: "unknown era files network"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := OpenEraStore(log.NewLogger(log.DiscardHandler()), dir)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
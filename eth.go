@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
@@ -11,6 +10,9 @@ import (
 )
 
 func GweiFloat64(v *big.Int) float64 {
+	if v == nil { // e.g. pre-London basefee, or no L1 fee on deposits
+		return 0
+	}
 	if v.IsUint64() { // fast path, not exact but good enough
 		return float64(v.Uint64()) / 1e9
 	}
@@ -110,7 +112,7 @@ func TxHistogram(name string, bounds []float64,
 	fn func(bl *types.Block, tx *types.Transaction) float64) AggregateMetric[*types.Block] {
 	return Histogram[*types.Block](
 		name,
-		feeBounds,
+		bounds,
 		func(bl *types.Block, add func(v float64)) error {
 			for _, tx := range bl.Transactions() {
 				add(fn(bl, tx))
@@ -128,7 +130,7 @@ func ReceiptHistogram(name string, bounds []float64,
 	fn func(bl *types.Block, tx *types.Transaction, rec *types.Receipt) float64) AggregateMetric[*BlockWithReceipts] {
 	return Histogram[*BlockWithReceipts](
 		name,
-		feeBounds,
+		bounds,
 		func(blr *BlockWithReceipts, add func(v float64)) error {
 			for i, tx := range blr.Block.Transactions() {
 				add(fn(blr.Block, tx, blr.Receipts[i]))
@@ -165,7 +167,7 @@ var TxNonceHistogram = ReceiptHistogram("tx_nonce", []float64{0, 1, 5, 10, 100,
 			return float64(*nonce)
 		}
 		if rec.DepositNonce != nil {
-			return float64(*nonce)
+			return float64(*rec.DepositNonce)
 		}
 		return -1
 	})
@@ -231,58 +233,97 @@ var RollupDataHistogram = func(chCfg *params.ChainConfig) AggregateMetric[*types
 	return TxHistogram("tx_rollup_data_gas", []float64{
 		0, 100, 1000, 10_000, 100_000, 1_000_000, 10_000_000,
 	}, func(bl *types.Block, tx *types.Transaction) float64 {
-		return float64(tx.RollupDataGas().DataGas(bl.Time(), chCfg))
+		return float64(rollupDataGas(tx, bl.Time(), chCfg))
 	})
 }
 
-func MakeCalldataStats(l1ChId uint64) AggregateMetric[*types.Block] {
-	inboxes, ok := InboxesByL1[l1ChId]
-	if !ok {
-		panic(fmt.Errorf("unknown L1: %d", l1ChId))
+// rollupDataGas returns the L1 gas that the data of the transaction is charged for:
+// the calldata gas of the transaction, plus a fixed overhead before Regolith,
+// or since Fjord the calldata gas of the estimated compressed size. Deposits are free.
+func rollupDataGas(tx *types.Transaction, time uint64, chCfg *params.ChainConfig) uint64 {
+	rcd := tx.RollupCostData()
+	if rcd == (types.RollupCostData{}) {
+		return 0
 	}
-	addrs := make([]common.Address, len(inboxes))
-	addrToIndex := make(map[common.Address]int)
-	for addr := range inboxes {
-		addrToIndex[addr] = len(addrs)
-		addrs = append(addrs, addr)
+	if chCfg.IsFjord(time) {
+		return rcd.EstimatedDASize().Uint64() * params.TxDataNonZeroGasEIP2028
 	}
-	names := make([]string, 0, len(addrs))
-	for _, addr := range addrs {
-		names = append(names, inboxes[addr].Name)
+	gas := rcd.Zeroes*params.TxDataZeroGas + rcd.Ones*params.TxDataNonZeroGasEIP2028
+	if !chCfg.IsRegolith(time) {
+		gas += 68 * params.TxDataNonZeroGasEIP2028
 	}
-	names = append(names, "contract deploys", "unknown method", "other")
+	return gas
+}
 
-	return ParametrizedMetric[*types.Block]("calldata_txs", "inbox", names, func(elem *types.Block, dest []float64) error {
-		for _, tx := range elem.Transactions() {
-			to := tx.To()
-			if to == nil {
-				// count as contract-deploy
-				dest[len(dest)-3] += float64(tx.Size())
-				continue
-			}
-			inbox, ok := inboxes[*to]
-			if !ok {
-				// count as other
-				dest[len(dest)-1] += float64(tx.Size())
-				continue
-			}
-			found := len(inbox.MethodSig) == 0
-			for _, sig := range inbox.MethodSig {
-				if bytes.HasPrefix(tx.Data(), sig[:]) {
-					found = true
-					break
+// inbox metrics, per inbox label
+var inboxMetricNames = []string{
+	"calldata_txs", // transaction bytes
+	"inbox_txs",
+	"inbox_gas_used",
+	"inbox_fees",
+	"inbox_priority_fees",
+}
+
+// MakeInboxStats attributes the transactions of each block, and their L1 costs, to the rollup inboxes of the L1 chain.
+// The fees are in gwei: the total is the gas used at the effective gas price of the receipt,
+// of which the priority fee is the part above the base fee.
+// The sender of a transaction is only recovered if the inboxes at its recipient restrict their batch senders.
+func MakeInboxStats(chCfg *params.ChainConfig, inboxes *Inboxes) AggregateMetric[*BlockWithReceipts] {
+	values := inboxes.Labels("contract deploys", "unknown method", "other")
+	deployIndex, unknownIndex, otherIndex := len(values)-3, len(values)-2, len(values)-1
+	var names []string
+	var labels [][]Label
+	for _, name := range inboxMetricNames {
+		for _, v := range values {
+			names = append(names, name)
+			labels = append(labels, []Label{{Key: "inbox", Value: v}})
+		}
+	}
+
+	return AggregateMetric[*BlockWithReceipts]{
+		Names:  names,
+		Labels: labels,
+		Fn: func(elem *BlockWithReceipts, dest []float64) error {
+			bl := elem.Block
+			signer := types.MakeSigner(chCfg, bl.Number(), bl.Time())
+			for j, tx := range bl.Transactions() {
+				i, err := inboxes.Match(tx.To(), tx.Data(), true, func() (common.Address, error) {
+					return types.Sender(signer, tx)
+				})
+				if err != nil {
+					return fmt.Errorf("failed to recover sender of tx %s: %w", tx.Hash(), err)
+				}
+				switch {
+				case tx.To() == nil:
+					i = deployIndex
+				case i == notInbox:
+					i = otherIndex
+				case i == unknownMethod:
+					i = unknownIndex
+				}
+				rec := elem.Receipts[j]
+				gasUsed := new(big.Int).SetUint64(rec.GasUsed)
+				tip := tx.EffectiveGasTipValue(bl.BaseFee())
+				gasPrice := rec.EffectiveGasPrice
+				if gasPrice == nil { // e.g. receipts derived from an archive
+					gasPrice = tip // before London, there is no base fee
+					if bl.BaseFee() != nil {
+						gasPrice = new(big.Int).Add(tip, bl.BaseFee())
+					}
+				}
+				for k, v := range []float64{
+					float64(tx.Size()),
+					1,
+					float64(rec.GasUsed),
+					GweiFloat64(new(big.Int).Mul(gasUsed, gasPrice)),
+					GweiFloat64(new(big.Int).Mul(gasUsed, tip)),
+				} {
+					dest[k*len(values)+i] += v
 				}
 			}
-			if found {
-				inboxIndex := addrToIndex[*to]
-				dest[inboxIndex] += float64(tx.Size())
-			} else {
-				// count as unknown method
-				dest[len(dest)-2] += float64(tx.Size())
-			}
-		}
-		return nil
-	})
+			return nil
+		},
+	}
 }
 
 var EthMetrics = func(chCfg *params.ChainConfig) AggregateMetric[*BlockWithReceipts] {
@@ -322,15 +363,21 @@ var EthMetrics = func(chCfg *params.ChainConfig) AggregateMetric[*BlockWithRecei
 	)
 }
 
-var OPMetrics = func(chCfg *params.ChainConfig) AggregateMetric[*BlockWithReceipts] {
+var OPMetrics = func(chCfg *params.ChainConfig, l1DB *ChainDB, deposits *DepositMatcher) AggregateMetric[*BlockWithReceipts] {
 	return CombineAggregates[*BlockWithReceipts](
 		EthMetrics(chCfg),
 		BlockTxL1CostHistogram,
+		DepositMetrics(deposits),
+		WithdrawalL2Metrics,
 		TransformAggregate[*types.Block, *BlockWithReceipts](
 			func(b *BlockWithReceipts) *types.Block {
 				return b.Block
 			},
-			RollupDataHistogram(chCfg),
+			CombineAggregates[*types.Block](
+				RollupDataHistogram(chCfg),
+				L1InfoMetrics,
+				L1OriginMetrics(l1DB),
+			),
 		),
 	)
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"math/big"
+	"testing"
+)
+
+// aggregateValues evaluates the metric on the element, and returns the values by labeled metric name
+func aggregateValues[E any](t *testing.T, m AggregateMetric[E], elem E) map[string]float64 {
+	dest := make([]float64, len(m.Names))
+	if err := m.Fn(elem, dest); err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]float64, len(dest))
+	for i, v := range dest {
+		out[formatLabeledMetric(m.Names[i], m.Labels[i])] = v
+	}
+	return out
+}
+
+func TestMakeInboxStats(t *testing.T) {
+	config := &params.ChainConfig{
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: new(big.Int),
+		EIP155Block:    new(big.Int),
+		ByzantiumBlock: new(big.Int),
+		BerlinBlock:    new(big.Int),
+		LondonBlock:    new(big.Int),
+	}
+	batcher, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restricted, open := common.Address{0xa}, common.Address{0xb}
+	method := [4]byte{1, 2, 3, 4}
+	inboxes := newInboxes([]Inbox{
+		{Name: "a", Address: restricted, MethodSig: [][4]byte{method}, Senders: []common.Address{crypto.PubkeyToAddress(batcher.PublicKey)}},
+		{Name: "b", Address: open},
+	})
+
+	signer := types.LatestSignerForChainID(config.ChainID)
+	gwei := big.NewInt(params.GWei)
+	var nonce uint64
+	newTx := func(to *common.Address, data []byte) *types.DynamicFeeTx {
+		nonce++
+		return &types.DynamicFeeTx{ChainID: config.ChainID, Nonce: nonce, To: to, Gas: 200_000, Data: data,
+			GasTipCap: new(big.Int).Mul(big.NewInt(2), gwei), GasFeeCap: new(big.Int).Mul(big.NewInt(100), gwei)}
+	}
+	sign := func(key *ecdsa.PrivateKey, to *common.Address, data []byte) *types.Transaction {
+		tx, err := types.SignNewTx(key, signer, newTx(to, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	batch := append(method[:], 0xff, 0xff)
+	txs := []*types.Transaction{
+		sign(batcher, &restricted, batch),
+		sign(batcher, &restricted, batch),
+		sign(other, &restricted, batch),
+		sign(batcher, &restricted, []byte{0xff, 0xff, 0xff, 0xff}),
+		// the sender is not recovered for inboxes that accept any sender
+		types.NewTx(newTx(&open, []byte{0xff})),
+		types.NewTx(newTx(nil, []byte{0x60, 0x00})),
+		types.NewTx(newTx(&common.Address{0xc}, nil)),
+	}
+	receipts := make(types.Receipts, len(txs))
+	for i := range receipts {
+		// the base fee is 10 gwei, so the effective gas price is 12 gwei
+		receipts[i] = &types.Receipt{GasUsed: 100_000, EffectiveGasPrice: new(big.Int).Mul(big.NewInt(12), gwei)}
+	}
+	// receipts derived from an archive have no effective gas price
+	receipts[1].EffectiveGasPrice = nil
+	bl := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(100), Time: 1000, BaseFee: new(big.Int).Mul(big.NewInt(10), gwei)}).
+		WithBody(types.Body{Transactions: txs})
+
+	got := aggregateValues(t, MakeInboxStats(config, inboxes), &BlockWithReceipts{Block: bl, Receipts: receipts})
+
+	size := func(txs ...*types.Transaction) float64 {
+		var out float64
+		for _, tx := range txs {
+			out += float64(tx.Size())
+		}
+		return out
+	}
+	for _, w := range []struct {
+		inbox string
+		txs   []*types.Transaction
+	}{
+		{inbox: "a", txs: txs[0:2]},
+		{inbox: unauthorizedSenderLabel, txs: txs[2:3]},
+		{inbox: "unknown method", txs: txs[3:4]},
+		{inbox: "b", txs: txs[4:5]},
+		{inbox: "contract deploys", txs: txs[5:6]},
+		{inbox: "other", txs: txs[6:7]},
+	} {
+		n := float64(len(w.txs))
+		for name, want := range map[string]float64{
+			"calldata_txs":        size(w.txs...),
+			"inbox_txs":           n,
+			"inbox_gas_used":      n * 100_000,
+			"inbox_fees":          n * 1_200_000,
+			"inbox_priority_fees": n * 200_000,
+		} {
+			key := formatLabeledMetric(name, []Label{{Key: "inbox", Value: w.inbox}})
+			if got[key] != want {
+				t.Errorf("got %s %v, want %v", key, got[key], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"time"
+)
+
+// ChainLabel is the label that distinguishes the series of different chains
+const ChainLabel = "chain"
+
+// how many recently exported blocks the exporter remembers in memory
+const exporterHistorySize = 1000
+
+type exportedBlock struct {
+	hash common.Hash
+	time uint64
+}
+
+// ChainExporter writes the metrics of the blocks of a chain to VictoriaMetrics, in order.
+// It is the only writer of the chain data, and handles reorgs:
+// when a block replaces a previously exported block, the series of the affected metrics hours are deleted,
+// and the canonical blocks of those hours are exported again.
+type ChainExporter struct {
+	log      log.Logger
+	ch       *Chain
+	victoria *VictoriaClient
+
+	exp       *JSONLinesExporter[*BlockWithReceipts]
+	compactor *Compactor
+	// nil if the chain does not index withdrawals
+	withdrawals *WithdrawalIndexer
+
+	// blocks that were recently added to the exporter, by number, including those that were not flushed yet
+	recent map[uint64]exportedBlock
+	// highest block timestamp that was added to the exporter
+	maxTime uint64
+}
+
+func NewChainExporter(ctx context.Context, log log.Logger, ch *Chain, victoria *VictoriaClient, m AggregateMetric[*BlockWithReceipts]) *ChainExporter {
+	e := &ChainExporter{
+		log:      log,
+		ch:       ch,
+		victoria: victoria,
+		recent:   make(map[uint64]exportedBlock),
+	}
+	e.compactor = NewCompactor(log.New("stage", "compact"), ch, victoria)
+	e.withdrawals = NewWithdrawalIndexer(log.New("stage", "withdrawals"), ch)
+	// victoria-metrics expects millisecond timestamps
+	blockTime := func(b *BlockWithReceipts) int64 {
+		return int64(b.Block.Time()) * 1000
+	}
+	// remember blocks that have been written, so we never re-write them
+	onFlush := func(batch []*BlockWithReceipts) error {
+		// indexing a block again is a no-op, so index before the blocks are marked as exported
+		if e.withdrawals != nil {
+			e.withdrawals.Index(batch)
+		}
+		for _, b := range batch {
+			if err := ch.DB.PutExported(b.Block.NumberU64(), b.Block.Hash(), b.Block.Time()); err != nil {
+				return fmt.Errorf("failed to mark block %d as exported: %w", b.Block.NumberU64(), err)
+			}
+		}
+		return ch.DB.Prune(batch[len(batch)-1].Block.NumberU64())
+	}
+	labels := []Label{{Key: ChainLabel, Value: ch.Name}}
+	e.exp = NewJSONLinesExporter[*BlockWithReceipts](blockTime, m, labels, victoria.ImportWriter(ctx), onFlush)
+	return e
+}
+
+// Run exports the blocks until the context is canceled or the input is closed.
+// Finalized metrics hours are compacted in between.
+func (e *ChainExporter) Run(ctx context.Context, in <-chan *BlockWithReceipts) error {
+	compactTicker := time.NewTicker(compactionInterval)
+	defer compactTicker.Stop()
+	for {
+		select {
+		case <-compactTicker.C:
+			// the current batch may belong to an hour that is about to be compacted
+			if err := e.exp.Flush(); err != nil {
+				return err
+			}
+			if err := e.compactor.Compact(ctx); err != nil {
+				e.log.Warn("failed to compact metrics", "err", err)
+			}
+		case b, ok := <-in:
+			if !ok {
+				return e.exp.Flush()
+			}
+			if err := e.process(ctx, b); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (e *ChainExporter) lookup(num uint64) (BlockRecord, bool) {
+	if b, ok := e.recent[num]; ok {
+		return BlockRecord{Hash: b.hash, Time: b.time, Status: BlockExported}, true
+	}
+	return e.ch.DB.Get(num)
+}
+
+func (e *ChainExporter) process(ctx context.Context, b *BlockWithReceipts) error {
+	num := b.Block.NumberU64()
+	if prev, ok := e.lookup(num); ok {
+		if prev.Written(b.Block.Hash()) {
+			return nil // the head follower and backfiller may both provide the same block
+		}
+		if err := e.rollback(ctx, b.Block, prev.Time); err != nil {
+			return fmt.Errorf("failed to roll back reorg at block %d: %w", num, err)
+		}
+	}
+	return e.add(b)
+}
+
+func (e *ChainExporter) add(b *BlockWithReceipts) error {
+	num := b.Block.NumberU64()
+	if err := e.exp.Add(b); err != nil {
+		return err
+	}
+	// the time is available to the L2 chains before the block is flushed
+	if err := e.ch.DB.PutBlockTime(num, b.Block.Time()); err != nil {
+		return fmt.Errorf("failed to index time of block %d: %w", num, err)
+	}
+	e.recent[num] = exportedBlock{hash: b.Block.Hash(), time: b.Block.Time()}
+	delete(e.recent, num-exporterHistorySize)
+	if b.Block.Time() > e.maxTime {
+		e.maxTime = b.Block.Time()
+	}
+	return nil
+}
+
+// rollback deletes the data of all metrics hours that contain blocks replaced by the given block,
+// and exports the canonical blocks before it in those hours again.
+// Compacted hours have no metrics-hour label to delete them by, so reorgs into those are refused.
+func (e *ChainExporter) rollback(ctx context.Context, bl *types.Block, replacedTime uint64) error {
+	num := bl.NumberU64()
+	fromHour := MetricsHour(int64(replacedTime) * 1000)
+	toHour := MetricsHour(int64(e.maxTime) * 1000)
+	if err := e.checkNotCompacted(fromHour); err != nil {
+		return err
+	}
+	// everything that was exported needs to be in the DB, so we know what to forget
+	if err := e.exp.Flush(); err != nil {
+		return err
+	}
+	for hour := fromHour; hour <= toHour; hour++ {
+		match := fmt.Sprintf(`{%s=%q,%s="%d"}`, ChainLabel, e.ch.Name, MetricsHourLabel, hour)
+		if err := e.victoria.DeleteSeries(ctx, match); err != nil {
+			return fmt.Errorf("failed to delete series of hour %d: %w", hour, err)
+		}
+	}
+	depth, err := e.ch.DB.ForgetFrom(num)
+	if err != nil {
+		return fmt.Errorf("failed to forget replaced blocks: %w", err)
+	}
+	if e.withdrawals != nil {
+		e.withdrawals.Revert(num)
+	}
+	for n := range e.recent {
+		if n >= num {
+			delete(e.recent, n)
+		}
+	}
+	reorgs, err := e.ch.DB.AddReorg()
+	if err != nil {
+		return fmt.Errorf("failed to count reorg: %w", err)
+	}
+	e.log.Warn("rolled back reorg", "number", num, "hash", bl.Hash(), "depth", depth,
+		"from_hour", fromHour, "to_hour", toHour, "reorgs", reorgs)
+	if err := e.exportReorg(ctx, bl.Time(), reorgs, depth); err != nil {
+		return err
+	}
+
+	// The deleted hours also contained blocks that were not replaced, collect and export those again.
+	// The replaced blocks are gone, so the highest exported time is that of the parent of the new block.
+	e.maxTime = 0
+	hourStart := uint64(fromHour) * 60 * 60
+	var blocks []*types.Block
+	for n := num; n > 0; n-- {
+		parent, err := e.ch.Blocks.BlockByNumber(ctx, n-1)
+		if err != nil {
+			return fmt.Errorf("failed to get block %d to export again: %w", n-1, err)
+		}
+		if n == num {
+			e.maxTime = parent.Time()
+		}
+		if parent.Time() < hourStart {
+			break
+		}
+		blocks = append(blocks, parent)
+	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		receipts, err := e.ch.Receipts.FetchReceipts(ctx, blocks[i])
+		if err != nil {
+			return fmt.Errorf("failed to get receipts of block %d to export again: %w", blocks[i].NumberU64(), err)
+		}
+		if err := e.add(&BlockWithReceipts{Block: blocks[i], Receipts: receipts}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNotCompacted returns an error if the metrics hour was compacted, or is being compacted.
+func (e *ChainExporter) checkNotCompacted(hour int64) error {
+	compacted, ok, err := e.ch.DB.CompactedHour()
+	if err != nil {
+		return fmt.Errorf("failed to read compacted hour: %w", err)
+	}
+	if ok && hour <= compacted {
+		return fmt.Errorf("reorg replaces blocks of metrics hour %d, but the hours up to %d are compacted already", hour, compacted)
+	}
+	cp, err := e.ch.DB.CompactionCheckpoint()
+	if err != nil {
+		return fmt.Errorf("failed to read compaction checkpoint: %w", err)
+	}
+	if cp != nil && hour <= cp.Hour {
+		return fmt.Errorf("reorg replaces blocks of metrics hour %d, but hour %d is being compacted", hour, cp.Hour)
+	}
+	return nil
+}
+
+// exportReorg writes the reorg count and depth metrics.
+// These are not labeled by metrics hour, so they do not get deleted by later reorgs.
+// The count is kept in the chain DB, so the series does not reset when the exporter restarts.
+func (e *ChainExporter) exportReorg(ctx context.Context, t uint64, reorgs uint64, depth int) error {
+	var out []byte
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{
+		{"chain_reorgs", float64(reorgs)},
+		{"chain_reorg_depth", float64(depth)},
+	} {
+		var err error
+		out, err = appendImportLine(out, m.name, map[string]string{ChainLabel: e.ch.Name}, m.value, int64(t)*1000)
+		if err != nil {
+			return err
+		}
+	}
+	if err := e.victoria.Import(ctx, out); err != nil {
+		return fmt.Errorf("failed to export reorg metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestChainExporterReorgIntoCompactedHour(t *testing.T) {
+	const blockTime = 1_765_000_000
+	hour := MetricsHour(blockTime * 1000)
+	tests := []struct {
+		name string
+		// highest compacted hour, if any
+		compacted *int64
+		// checkpoint of the compaction in progress, if any
+		checkpoint *CompactionCheckpoint
+		wantErr    string
+	}{
+		{name: "not compacted"},
+		{name: "earlier hour compacted", compacted: ptrTo(hour - 1)},
+		{name: "compacted", compacted: ptrTo(hour), wantErr: "compacted already"},
+		{name: "later hour compacted", compacted: ptrTo(hour + 1), wantErr: "compacted already"},
+		{name: "being compacted", checkpoint: &CompactionCheckpoint{Hour: hour, Imported: true}, wantErr: "is being compacted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var deleted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Path == "/api/v1/admin/tsdb/delete_series" {
+					deleted = append(deleted, r.URL.Query().Get("match[]"))
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+			logger := log.NewLogger(log.DiscardHandler())
+			victoria, err := NewVictoriaClient(logger, &DBConfig{Victoria: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if tt.compacted != nil {
+				if err := db.SetCompactedHour(*tt.compacted); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.SetCompactionCheckpoint(tt.checkpoint); err != nil {
+				t.Fatal(err)
+			}
+			// the genesis block is replaced, so there are no earlier blocks to export again
+			if err := db.PutExported(0, common.Hash{1}, blockTime); err != nil {
+				t.Fatal(err)
+			}
+			ch := &Chain{Name: "test", DB: db}
+			m := Aggregate[*BlockWithReceipts](Metric[*BlockWithReceipts]{
+				Name: "block_time",
+				Fn: func(elem *BlockWithReceipts) (float64, error) {
+					return float64(elem.Block.Time()), nil
+				},
+			})
+			ctx := context.Background()
+			e := NewChainExporter(ctx, logger, ch, victoria, m)
+			e.maxTime = blockTime + 60
+
+			bl := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0), Time: blockTime + 1})
+			err = e.process(ctx, &BlockWithReceipts{Block: bl})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				if len(deleted) != 0 {
+					t.Errorf("deleted %v of a compacted hour", deleted)
+				}
+				if rec, ok := db.Get(0); !ok || rec.Hash != (common.Hash{1}) {
+					t.Errorf("replaced block record was forgotten")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deleted) != 1 || !strings.Contains(deleted[0], `"`+strconv.FormatInt(hour, 10)+`"`) {
+				t.Errorf("got deleted series %v, want hour %d", deleted, hour)
+			}
+			// the replaced block was later than the new one
+			if e.maxTime != bl.Time() {
+				t.Errorf("got max time %d, want %d", e.maxTime, bl.Time())
+			}
+		})
+	}
+}
+
+func ptrTo[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"sync"
+	"time"
+)
+
+// how long to wait before checking for newly finalized elements
+const finalizedPollInterval = time.Minute
+
+// FinalizedSource provides numbered elements, e.g. slots or blocks, of which the metrics only change until finalized.
+type FinalizedSource[E any] interface {
+	// Finalized returns the number of the last finalized element.
+	Finalized(ctx context.Context) (uint64, error)
+	// Start returns the number to start at when nothing was exported yet,
+	// or false if there is nothing to start at yet, e.g. before a fork activated.
+	Start(ctx context.Context, finalized uint64) (uint64, bool, error)
+	// Fetch returns the element with the given number.
+	Fetch(ctx context.Context, num uint64) (E, error)
+}
+
+// FinalizedExporter writes the metrics of the finalized elements of a source to VictoriaMetrics, in order.
+// Only finalized elements are exported, so the series never need to be deleted on reorgs,
+// and are written without the metrics-hour label, so they are not compacted.
+// The number of the last exported element is kept in the chain database, to continue from there on restarts.
+type FinalizedExporter[E any] struct {
+	log log.Logger
+	ch  *Chain
+	// name of the progress record in the chain database
+	name string
+	src  FinalizedSource[E]
+
+	exp *CSVExporter[E]
+	// called with every exported element, in order, after it has been written
+	observers []func(ctx context.Context, elem E)
+
+	// next element to add to the exporter, valid if started
+	next    uint64
+	started bool
+}
+
+func NewFinalizedExporter[E any](ctx context.Context, log log.Logger, ch *Chain, victoria *VictoriaClient, name string,
+	src FinalizedSource[E], numFn func(elem E) uint64, timeFn func(elem E) int64, m AggregateMetric[E]) *FinalizedExporter[E] {
+	e := &FinalizedExporter[E]{log: log, ch: ch, name: name, src: src}
+	write := func(format string, data []byte) error {
+		return victoria.ImportCSV(ctx, format, data)
+	}
+	onFlush := func(batch []E) error {
+		last := numFn(batch[len(batch)-1])
+		if err := ch.DB.SetExportProgress(name, last); err != nil {
+			return fmt.Errorf("failed to store %s progress at %d: %w", name, last, err)
+		}
+		for _, elem := range batch {
+			for _, fn := range e.observers {
+				fn(ctx, elem)
+			}
+		}
+		return nil
+	}
+	labels := []Label{{Key: ChainLabel, Value: ch.Name}}
+	e.exp = NewCSVExporter[E](timeFn, m, labels, write, onFlush)
+	return e
+}
+
+// Observe registers a function to call with every exported element, in order, once it has been written,
+// for processing that does not fit the fixed series of an AggregateMetric.
+func (e *FinalizedExporter[E]) Observe(fn func(ctx context.Context, elem E)) {
+	e.observers = append(e.observers, fn)
+}
+
+// Run exports the finalized elements until the context is canceled, and then flushes the last batch.
+func (e *FinalizedExporter[E]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(finalizedPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := e.update(ctx); err != nil && ctx.Err() == nil {
+			e.log.Warn("failed to export finalized metrics", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return e.exp.Flush()
+		}
+	}
+}
+
+// update exports the elements up to the finalized element.
+func (e *FinalizedExporter[E]) update(ctx context.Context) error {
+	finalized, err := e.src.Finalized(ctx)
+	if err != nil {
+		return err
+	}
+	if !e.started {
+		last, ok, err := e.ch.DB.ExportProgress(e.name)
+		if err != nil {
+			return fmt.Errorf("failed to read %s progress: %w", e.name, err)
+		}
+		if ok {
+			e.next = last + 1
+		} else if e.next, ok, err = e.src.Start(ctx, finalized); err != nil {
+			return fmt.Errorf("failed to find start: %w", err)
+		} else if !ok {
+			e.log.Debug("nothing to export yet", "finalized", finalized)
+			return nil
+		}
+		e.started = true
+		e.log.Info("starting finalized export", "start", e.next, "finalized", finalized)
+	}
+	for e.next <= finalized {
+		end := e.next + backfillBatchSize
+		if end > finalized+1 {
+			end = finalized + 1
+		}
+		elems, err := e.fetch(ctx, e.next, end)
+		if err != nil {
+			return err
+		}
+		for _, elem := range elems {
+			// an element that was added stays in the batch when the flush fails, and is written by the next flush
+			err := e.exp.Add(elem)
+			if err == nil || errors.Is(err, errFlush) {
+				e.next++
+			}
+			if err != nil {
+				return err
+			}
+		}
+		e.log.Debug("exported finalized batch", "end", end, "finalized", finalized)
+	}
+	return e.exp.Flush()
+}
+
+// fetch concurrently fetches the elements in [start, end)
+func (e *FinalizedExporter[E]) fetch(ctx context.Context, start, end uint64) ([]E, error) {
+	elems := make([]E, end-start)
+	errs := make([]error, len(elems))
+	var wg sync.WaitGroup
+	for i := range elems {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			elems[i], errs[i] = e.src.Fetch(ctx, start+uint64(i))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %d: %w", start+uint64(i), err)
+		}
+	}
+	return elems, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"github.com/ethereum/go-ethereum/log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// countingSource is a finalized source of numbers
+type countingSource struct {
+	finalized uint64
+}
+
+func (s *countingSource) Finalized(ctx context.Context) (uint64, error) {
+	return s.finalized, nil
+}
+
+func (s *countingSource) Start(ctx context.Context, finalized uint64) (uint64, bool, error) {
+	return 0, true, nil
+}
+
+func (s *countingSource) Fetch(ctx context.Context, num uint64) (uint64, error) {
+	return num, nil
+}
+
+func TestFinalizedExporterFailedFlush(t *testing.T) {
+	tests := []struct {
+		name string
+		// index of the import request that is rejected, the exporter writes two groups per batch
+		fail int
+	}{
+		{name: "first group", fail: 2},
+		{name: "second group", fail: 3},
+		{name: "last batch", fail: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			// number of samples of every group and timestamp
+			samples := make(map[string]map[string]int)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				requests++
+				if requests-1 == tt.fail {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				gr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					t.Errorf("failed to decompress body: %v", err)
+					return
+				}
+				rows, err := csv.NewReader(gr).ReadAll()
+				if err != nil {
+					t.Errorf("failed to read rows: %v", err)
+				}
+				// the rows have the time, chain and group columns, and the value
+				for _, row := range rows {
+					if samples[row[2]] == nil {
+						samples[row[2]] = make(map[string]int)
+					}
+					samples[row[2]][row[0]]++
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+			logger := log.NewLogger(log.DiscardHandler())
+			victoria, err := NewVictoriaClient(logger, &DBConfig{Victoria: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			ch := &Chain{Name: "test", DB: db}
+
+			m := ParametrizedMetric[uint64]("num", "group", []string{"a", "b"}, func(elem uint64, dest []float64) error {
+				dest[0], dest[1] = float64(elem), float64(elem)
+				return nil
+			})
+			const finalized = 249
+			ctx := context.Background()
+			e := NewFinalizedExporter[uint64](ctx, logger, ch, victoria, "test", &countingSource{finalized: finalized},
+				func(elem uint64) uint64 { return elem }, func(elem uint64) int64 { return int64(elem) * 1000 }, m)
+			var observed []uint64
+			e.Observe(func(ctx context.Context, elem uint64) {
+				observed = append(observed, elem)
+			})
+
+			if err := e.update(ctx); err == nil {
+				t.Fatal("expected the rejected import to fail the update")
+			}
+			if err := e.update(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(samples) != 2 {
+				t.Fatalf("got %d groups, want 2", len(samples))
+			}
+			for group, counts := range samples {
+				if len(counts) != finalized+1 {
+					t.Errorf("group %s: got %d timestamps, want %d", group, len(counts), finalized+1)
+				}
+				for ts, n := range counts {
+					if n != 1 {
+						t.Errorf("group %s: got %d samples at %s, want 1", group, n, ts)
+					}
+				}
+			}
+			for i, num := range observed {
+				if num != uint64(i) {
+					t.Fatalf("observed %d at %d", num, i)
+				}
+			}
+			if len(observed) != finalized+1 {
+				t.Errorf("observed %d elements, want %d", len(observed), finalized+1)
+			}
+			if last, ok, err := db.ExportProgress("test"); err != nil || !ok || last != finalized {
+				t.Errorf("got progress %d %v %v, want %d", last, ok, err, finalized)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"time"
+)
+
+const (
+	// how often the head of the chain is polled
+	headPollInterval = time.Second
+	// how many blocks we traverse back per poll before giving up on finding a known ancestor.
+	// Larger gaps are left to the backfiller.
+	maxHeadTraversal = 200
+	// how many recently emitted blocks we remember to detect reorgs with
+	headHistorySize = 1000
+)
+
+// HeadFollower polls the latest block, walks back by parent-hash until it meets a block it emitted before,
+// and then emits the new canonical blocks in order. Emitted blocks that are no longer canonical are reported as reorg.
+type HeadFollower struct {
+	log log.Logger
+	src *RPCBlockSource
+	db  *ChainDB
+
+	// canonical block hashes by number, of the blocks we emitted
+	emitted map[uint64]common.Hash
+	head    eth.BlockID
+
+	out chan<- *types.Block
+}
+
+func NewHeadFollower(log log.Logger, src *RPCBlockSource, db *ChainDB, out chan<- *types.Block) *HeadFollower {
+	return &HeadFollower{
+		log:     log,
+		src:     src,
+		db:      db,
+		emitted: make(map[uint64]common.Hash),
+		out:     out,
+	}
+}
+
+// Run polls for new blocks until the context is canceled.
+func (f *HeadFollower) Run(ctx context.Context) error {
+	blockPollTicker := time.NewTicker(headPollInterval)
+	defer blockPollTicker.Stop()
+	for {
+		select {
+		case <-blockPollTicker.C:
+			if err := f.step(ctx); err != nil {
+				f.log.Warn("failed to follow chain head", "err", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *HeadFollower) known(bl *types.Block) bool {
+	h, ok := f.emitted[bl.NumberU64()]
+	return ok && h == bl.Hash()
+}
+
+func (f *HeadFollower) step(ctx context.Context) error {
+	bl, err := f.src.BlockByLabel(ctx, eth.Unsafe)
+	if err != nil {
+		return fmt.Errorf("failed to get latest block: %w", err)
+	}
+	if f.known(bl) {
+		return nil
+	}
+	// traverse backwards until we reach a block we've seen already
+	newBlocks := []*types.Block{bl}
+	for len(f.emitted) > 0 && !f.known(bl) && bl.NumberU64() > 0 {
+		if len(newBlocks) > maxHeadTraversal {
+			f.log.Warn("no known ancestor found, resetting head",
+				"head", f.head, "new_head", eth.ToBlockID(newBlocks[0]), "traversed", len(newBlocks))
+			f.emitted = make(map[uint64]common.Hash)
+			break
+		}
+		parent, err := f.src.BlockByHash(ctx, bl.ParentHash())
+		if err != nil {
+			return fmt.Errorf("failed to get parent %s of block %d: %w", bl.ParentHash(), bl.NumberU64(), err)
+		}
+		bl = parent
+		if f.known(bl) {
+			break
+		}
+		newBlocks = append(newBlocks, bl)
+	}
+
+	// The blocks we emitted after the common ancestor were replaced,
+	// the exporter rolls back their data when it gets the new blocks.
+	if first := newBlocks[len(newBlocks)-1].NumberU64(); len(f.emitted) > 0 && first <= f.head.Number {
+		f.log.Warn("detected reorg", "old_head", f.head, "new_head", eth.ToBlockID(newBlocks[0]),
+			"depth", f.head.Number-first+1)
+		for n := first; n <= f.head.Number; n++ {
+			delete(f.emitted, n)
+		}
+	}
+
+	// emit the new canonical blocks in order
+	for i := len(newBlocks) - 1; i >= 0; i-- {
+		bl := newBlocks[i]
+		// the backfiller may have written it already
+		if rec, ok := f.db.Get(bl.NumberU64()); !ok || !rec.Written(bl.Hash()) {
+			select {
+			case f.out <- bl:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		f.emitted[bl.NumberU64()] = bl.Hash()
+		f.head = eth.ToBlockID(bl)
+		if n := bl.NumberU64(); n >= headHistorySize {
+			delete(f.emitted, n-headHistorySize)
+		}
+	}
+	return nil
+}
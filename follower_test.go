@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"path/filepath"
+	"testing"
+)
+
+// chainRPC serves blocks by hash, and the head block for any block label
+type chainRPC struct {
+	byHash map[common.Hash]*types.Block
+	head   *types.Block
+}
+
+func (c *chainRPC) Close() {}
+
+func (c *chainRPC) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	panic("not supported")
+}
+
+func (c *chainRPC) Subscribe(ctx context.Context, namespace string, channel any, args ...any) (ethereum.Subscription, error) {
+	panic("not supported")
+}
+
+func (c *chainRPC) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	bl := c.head
+	if hash, ok := args[0].(common.Hash); ok {
+		bl = c.byHash[hash]
+	}
+	if bl == nil {
+		*result.(*json.RawMessage) = json.RawMessage("null")
+		return nil
+	}
+	header, err := json.Marshal(bl.Header())
+	if err != nil {
+		return err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(header, &fields); err != nil {
+		return err
+	}
+	fields["transactions"] = bl.Transactions()
+	fields["hash"] = bl.Hash()
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	*result.(*json.RawMessage) = out
+	return nil
+}
+
+// extend adds n blocks on top of the parent to the chain, and returns them.
+// The branch byte distinguishes the blocks of different branches.
+func (c *chainRPC) extend(parent *types.Block, n int, branch byte) []*types.Block {
+	out := make([]*types.Block, n)
+	for i := range out {
+		bl := types.NewBlockWithHeader(&types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			Difficulty: new(big.Int),
+			GasLimit:   30_000_000,
+			Time:       parent.Time() + 2,
+			Extra:      []byte{branch},
+			BaseFee:    big.NewInt(1),
+		})
+		c.byHash[bl.Hash()] = bl
+		out[i], parent = bl, bl
+	}
+	return out
+}
+
+func TestHeadFollower(t *testing.T) {
+	type step struct {
+		// the head of the chain
+		head *types.Block
+		// the blocks that the follower is expected to emit, in order
+		want []*types.Block
+	}
+	tests := []struct {
+		name string
+		// blocks that the backfiller exported before the follower started
+		exported []uint64
+		// creates the chain, and returns the heads and expected blocks of every poll
+		steps func(c *chainRPC, genesis *types.Block) []step
+	}{
+		{
+			name: "extends",
+			steps: func(c *chainRPC, genesis *types.Block) []step {
+				a := c.extend(genesis, 5, 'a')
+				return []step{
+					{head: a[2], want: a[2:3]},
+					{head: a[2]},
+					{head: a[4], want: a[3:5]},
+				}
+			},
+		},
+		{
+			name: "reorg",
+			steps: func(c *chainRPC, genesis *types.Block) []step {
+				a := c.extend(genesis, 5, 'a')
+				b := c.extend(a[1], 3, 'b')
+				return []step{
+					{head: a[1], want: a[1:2]},
+					{head: a[4], want: a[2:5]},
+					// b replaces a[2:5], of the same height
+					{head: b[2], want: b},
+					// and then a comes back
+					{head: a[4], want: a[2:5]},
+				}
+			},
+		},
+		{
+			name: "reorg to a lower head",
+			steps: func(c *chainRPC, genesis *types.Block) []step {
+				a := c.extend(genesis, 5, 'a')
+				b := c.extend(a[0], 1, 'b')
+				return []step{
+					{head: a[0], want: a[0:1]},
+					{head: a[4], want: a[1:5]},
+					{head: b[0], want: b},
+					{head: a[4], want: a[1:5]},
+				}
+			},
+		},
+		{
+			name: "gap beyond the traversal limit",
+			steps: func(c *chainRPC, genesis *types.Block) []step {
+				a := c.extend(genesis, maxHeadTraversal+10, 'a')
+				return []step{
+					{head: a[0], want: a[0:1]},
+					// the remainder of the gap is left to the backfiller
+					{head: a[len(a)-1], want: a[len(a)-maxHeadTraversal-1:]},
+				}
+			},
+		},
+		{
+			name:     "exported by the backfiller",
+			exported: []uint64{3, 4},
+			steps: func(c *chainRPC, genesis *types.Block) []step {
+				a := c.extend(genesis, 5, 'a')
+				return []step{
+					{head: a[1], want: a[1:2]},
+					{head: a[4], want: a[4:5]},
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &chainRPC{byHash: make(map[common.Hash]*types.Block)}
+			genesis := types.NewBlockWithHeader(&types.Header{Number: new(big.Int), Difficulty: new(big.Int), BaseFee: big.NewInt(1)})
+			c.byHash[genesis.Hash()] = genesis
+			steps := tt.steps(c, genesis)
+
+			db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for _, bl := range c.byHash {
+				for _, num := range tt.exported {
+					// the blocks of the last step are canonical
+					if bl.NumberU64() == num && bl.Extra()[0] == 'a' {
+						if err := db.PutExported(num, bl.Hash(), bl.Time()); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+			}
+
+			out := make(chan *types.Block, 1000)
+			f := NewHeadFollower(log.NewLogger(log.DiscardHandler()), NewRPCBlockSource(c), db, out)
+			for i, s := range steps {
+				c.head = s.head
+				if err := f.step(context.Background()); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				var got []*types.Block
+				for len(out) > 0 {
+					got = append(got, <-out)
+				}
+				if len(got) != len(s.want) {
+					t.Fatalf("step %d: got %d blocks, want %d", i, len(got), len(s.want))
+				}
+				for j := range got {
+					if got[j].Hash() != s.want[j].Hash() {
+						t.Fatalf("step %d: block %d is %d %s, want %d %s", i, j,
+							got[j].NumberU64(), got[j].Hash(), s.want[j].NumberU64(), s.want[j].Hash())
+					}
+				}
+				if f.head != (eth.BlockID{Hash: s.head.Hash(), Number: s.head.NumberU64()}) {
+					t.Errorf("step %d: got head %s, want %s", i, f.head, s.head.Hash())
+				}
+			}
+		})
+	}
+}
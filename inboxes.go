@@ -1,66 +1,282 @@
 package main
 
 import (
-	"encoding/hex"
+	"bytes"
+	_ "embed"
 	"fmt"
-	"github.com/ethereum-optimism/optimism/op-node/chaincfg"
+	"github.com/ethereum-optimism/optimism/op-node/rollup"
 	"github.com/ethereum/go-ethereum/common"
-	"strings"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
 )
 
+// the built-in inbox registry, used if the config does not reference an inbox registry file
+//
+//go:embed inboxes.yaml
+var defaultInboxesFile []byte
+
 type Inbox struct {
-	Name string
+	Name    string
+	Address common.Address
 	// optional, some inboxes just process raw data (no method sig), some use multiple functions
 	MethodSig [][4]byte
+	// whether the inbox receives OP Stack batcher transactions, with derivation frames as data
+	OPStack bool
+	// optional, the batch senders that may submit data to the inbox, any sender is accepted if empty
+	Senders []common.Address
+	// optional, the L1 time from which the OP Stack chain of the inbox runs Granite
+	GraniteTime *uint64
+	// the rollup config of the OP Stack chain of the inbox, if the chain is configured
+	RollupConfig *rollup.Config
+}
+
+// Authorized returns whether the sender may submit data to the inbox.
+func (in *Inbox) Authorized(sender common.Address) bool {
+	if len(in.Senders) == 0 {
+		return true
+	}
+	for _, s := range in.Senders {
+		if s == sender {
+			return true
+		}
+	}
+	return false
 }
 
-func inbox(name string, methodsig ...string) Inbox {
-	out := Inbox{Name: name}
-	for _, msig := range methodsig {
-		if strings.HasPrefix(msig, "0x") {
-			methodsig = methodsig[2:]
+// AcceptsMethod returns whether the inbox processes calldata of the given method.
+func (in *Inbox) AcceptsMethod(data []byte) bool {
+	if len(in.MethodSig) == 0 {
+		return true
+	}
+	for _, sig := range in.MethodSig {
+		if bytes.HasPrefix(data, sig[:]) {
+			return true
 		}
-		sig, err := hex.DecodeString(msig)
+	}
+	return false
+}
+
+// InboxConfig is an entry of an inbox registry file.
+type InboxConfig struct {
+	L1ChainID uint64 `yaml:"l1_chain_id"`
+	Name      string `yaml:"name"`
+	Address   string `yaml:"address"`
+	// 4-byte method selectors, hex encoded
+	Methods []string `yaml:"methods"`
+	OPStack bool     `yaml:"op_stack"`
+	Senders []string `yaml:"senders"`
+	// Granite activation time of OP Stack inboxes, which shortened the channel timeout
+	GraniteTime *uint64 `yaml:"granite_time"`
+}
+
+func (c *InboxConfig) inbox() (Inbox, error) {
+	if c.L1ChainID == 0 {
+		return Inbox{}, fmt.Errorf("inbox %q has no l1_chain_id", c.Name)
+	}
+	if c.Name == "" {
+		return Inbox{}, fmt.Errorf("inbox at %s has no name", c.Address)
+	}
+	if !common.IsHexAddress(c.Address) {
+		return Inbox{}, fmt.Errorf("inbox %q has invalid address %q", c.Name, c.Address)
+	}
+	out := Inbox{Name: c.Name, Address: common.HexToAddress(c.Address), OPStack: c.OPStack, GraniteTime: c.GraniteTime}
+	for _, msig := range c.Methods {
+		sig, err := hexutil.Decode(msig)
 		if err != nil {
-			panic(err)
+			return Inbox{}, fmt.Errorf("inbox %q has invalid method sig %q: %w", c.Name, msig, err)
 		}
 		if len(sig) != 4 {
-			panic(fmt.Errorf("bad method sig len: %d", len(sig)))
+			return Inbox{}, fmt.Errorf("inbox %q has bad method sig len: %d", c.Name, len(sig))
 		}
 		var x [4]byte
 		copy(x[:], sig)
 		out.MethodSig = append(out.MethodSig, x)
 	}
+	for _, s := range c.Senders {
+		if !common.IsHexAddress(s) {
+			return Inbox{}, fmt.Errorf("inbox %q has invalid sender %q", c.Name, s)
+		}
+		out.Senders = append(out.Senders, common.HexToAddress(s))
+	}
+	return out, nil
+}
+
+// Inboxes are the rollup inboxes of one L1 chain.
+type Inboxes struct {
+	// sorted by name, for a stable order of metric labels
+	List []Inbox
+	// indices in List of the inboxes at each address
+	byAddr map[common.Address][]int
+}
+
+func newInboxes(list []Inbox) *Inboxes {
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	out := &Inboxes{List: list, byAddr: make(map[common.Address][]int)}
+	for i, in := range list {
+		out.byAddr[in.Address] = append(out.byAddr[in.Address], i)
+	}
 	return out
 }
 
-// TODO: we also have to check the batch-sender, since some inboxes are being used by multiple chains (misconfigured alt-op-stack ones)
-var EthMainnetRollupInboxes = map[common.Address]Inbox{
-	chaincfg.Mainnet.BatchInboxAddress:                                inbox("mainnet op"),
-	common.HexToAddress("0x1c479675ad559dc151f6ec7ed3fbf8cee79582b6"): inbox("mainnet arb one sequencer inbox", "0x8f111f3c"),
-	common.HexToAddress("0x1c479675ad559dc151f6ec7ed3fbf8cee79582b6"): inbox("mainnet arb nova sequencer inbox", "0x8f111f3c"),
-	common.HexToAddress("0x3dB52cE065f728011Ac6732222270b3F2360d919"): inbox("mainnet zksync era", "0x0c4dd810", "0x7739cbe7"),    // commitBlocks, proveBlocks
-	common.HexToAddress("0xaBEA9132b05A70803a4E85094fD0e1800777fBEF"): inbox("mainnet zksync lite", "0x45269298"),                 // commitBlocks
-	common.HexToAddress("0x5132A183E9F3CB7C848b0AAC5Ae0c4f0491B7aB2"): inbox("mainnet polygon zkevm", "0x5e9145c9", "0xa50a164b"), // sequenceBatches, verifyBatchesTrustedAggregator
-	common.HexToAddress("0x6F54Ca6F6EdE96662024Ffd61BFd18f3f4e34DFf"): inbox("mainnet zora"),
+// OPStack returns the OP Stack batch inboxes.
+func (r *Inboxes) OPStack() *Inboxes {
+	var list []Inbox
+	for _, in := range r.List {
+		if in.OPStack {
+			list = append(list, in)
+		}
+	}
+	return newInboxes(list)
 }
 
-var EthGoerliRollupInboxes = map[common.Address]Inbox{
-	chaincfg.Goerli.BatchInboxAddress:                                 inbox("goerli op"),
-	common.HexToAddress("0x8453100000000000000000000000000000000000"): inbox("goerli base"),
-	common.HexToAddress("0xa997cfD539E703921fD1e3Cf25b4c241a27a4c7A"): inbox("goerli polygon zkevm", "0x5e9145c9", "0xa50a164b"),            // sequenceBatches, verifyBatchesTrustedAggregator
-	common.HexToAddress("0xB949b4E3945628650862a29Abef3291F2eD52471"): inbox("goerli zksync era", "0x7739cbe7", "0x0c4dd810", "0xce9dcf16"), // proveBlocks, commitBlocks, executeBlocks
-	common.HexToAddress("0x3C584eC7f0f2764CC715ac3180Ae9828465E9833"): inbox("goerli scroll alpha", "0xcb905499"),                           // ?
-	common.HexToAddress("0x0484A87B144745A2E5b7c359552119B6EA2917A9"): inbox("goerli arb sequencer inbox", "0x8f111f3c"),                    // addSequencerL2BatchFromOrigin
-	common.HexToAddress("0xFf00000000000000000000000000000000000421"): inbox("goerli op nightly"),
-	common.HexToAddress("0xff00000000000000000000000000000000000888"): inbox("goerli op chaos"),
-	common.HexToAddress("0x70BaD09280FD342D02fe64119779BC1f0791BAC2"): inbox("goerli linea", "0x4165d6dd"),
-	common.HexToAddress("0xFf00000000000000000000000000000000042069"): inbox("goerli op unknown"),
-	common.HexToAddress("0xff00000000000000000000000000000000000997"): inbox("goerli op internal"),
-	common.HexToAddress("0x427c9a666d3b27873111cE3894712Bf64C6343A0"): inbox("goerli zora"),
+// label of the data that reaches an inbox from a sender that is not authorized for it
+const unauthorizedSenderLabel = "unauthorized sender"
+
+// Labels returns the label values for metrics that attribute data to the inboxes:
+// the inbox names, followed by the unauthorized sender label, and then the given extra labels.
+// The unauthorized sender label is at the Unauthorized index.
+func (r *Inboxes) Labels(extra ...string) []string {
+	names := make([]string, 0, len(r.List)+1+len(extra))
+	for _, in := range r.List {
+		names = append(names, in.Name)
+	}
+	names = append(names, unauthorizedSenderLabel)
+	return append(names, extra...)
 }
 
-var InboxesByL1 = map[uint64]map[common.Address]Inbox{
-	1: EthMainnetRollupInboxes,
-	5: EthGoerliRollupInboxes,
+// Unauthorized is the index that Match returns for data from a sender that no inbox at the address accepts.
+func (r *Inboxes) Unauthorized() int {
+	return len(r.List)
+}
+
+// results of Match for transactions that are not attributed to an inbox
+const (
+	notInbox      = -1
+	unknownMethod = -2
+)
+
+// Match returns the index of the inbox that a transaction to the given address is attributed to,
+// the Unauthorized index if none of the inboxes at the address accept the sender, notInbox if there is no inbox
+// at the address, or unknownMethod if checkMethod is set and none of the inboxes at the address process the calldata.
+// The sender is only requested if the inboxes at the address restrict their batch senders.
+func (r *Inboxes) Match(to *common.Address, data []byte, checkMethod bool, sender func() (common.Address, error)) (int, error) {
+	if to == nil {
+		return notInbox, nil
+	}
+	candidates := r.byAddr[*to]
+	if len(candidates) == 0 {
+		return notInbox, nil
+	}
+	if checkMethod {
+		var accepting []int
+		for _, i := range candidates {
+			if r.List[i].AcceptsMethod(data) {
+				accepting = append(accepting, i)
+			}
+		}
+		if len(accepting) == 0 {
+			return unknownMethod, nil
+		}
+		candidates = accepting
+	}
+	// the registry only allows an inbox without senders if it is the only inbox at its address
+	if len(r.List[candidates[0]].Senders) == 0 {
+		return candidates[0], nil
+	}
+	from, err := sender()
+	if err != nil {
+		return 0, err
+	}
+	for _, i := range candidates {
+		if r.List[i].Authorized(from) {
+			return i, nil
+		}
+	}
+	return r.Unauthorized(), nil
+}
+
+// ParseInboxes parses an inbox registry, a YAML (or JSON) list of inboxes, into the inboxes of each L1 chain.
+// Every address and batch sender pair may only be used by one inbox: an inbox without senders takes its address
+// for all senders. Inbox names are the metric labels, and must be unique per L1 chain.
+func ParseInboxes(data []byte) (map[uint64]*Inboxes, error) {
+	var entries []InboxConfig
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode inbox registry: %w", err)
+	}
+	type key struct {
+		l1     uint64
+		addr   common.Address
+		sender common.Address // zero for all senders
+	}
+	owners := make(map[key]string)
+	names := make(map[uint64]map[string]struct{})
+	lists := make(map[uint64][]Inbox)
+	for i := range entries {
+		in, err := entries[i].inbox()
+		if err != nil {
+			return nil, err
+		}
+		l1 := entries[i].L1ChainID
+		if names[l1] == nil {
+			names[l1] = make(map[string]struct{})
+		}
+		if _, ok := names[l1][in.Name]; ok {
+			return nil, fmt.Errorf("duplicate inbox name %q on L1 %d", in.Name, l1)
+		}
+		names[l1][in.Name] = struct{}{}
+
+		keys := []key{{l1: l1, addr: in.Address}}
+		if len(in.Senders) > 0 {
+			keys = keys[:0]
+			for _, s := range in.Senders {
+				keys = append(keys, key{l1: l1, addr: in.Address, sender: s})
+			}
+		}
+		for _, k := range keys {
+			// an inbox for all senders conflicts with any other inbox at the address
+			conflicts := []key{k, {l1: l1, addr: in.Address}}
+			if k.sender == (common.Address{}) {
+				for other := range owners {
+					if other.l1 == l1 && other.addr == in.Address {
+						conflicts = append(conflicts, other)
+					}
+				}
+			}
+			for _, c := range conflicts {
+				if owner, ok := owners[c]; ok {
+					sender := "any sender"
+					if k.sender != (common.Address{}) {
+						sender = "sender " + k.sender.String()
+					}
+					return nil, fmt.Errorf("inbox %q duplicates address %s and %s of inbox %q on L1 %d",
+						in.Name, in.Address, sender, owner, l1)
+				}
+			}
+			owners[k] = in.Name
+		}
+		lists[l1] = append(lists[l1], in)
+	}
+	out := make(map[uint64]*Inboxes, len(lists))
+	for l1, list := range lists {
+		out[l1] = newInboxes(list)
+	}
+	return out, nil
+}
+
+// LoadInboxes reads the inbox registry file at the path, or the built-in registry if the path is empty.
+func LoadInboxes(path string) (map[uint64]*Inboxes, error) {
+	if path == "" {
+		return ParseInboxes(defaultInboxesFile)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inbox registry %q: %w", path, err)
+	}
+	out, err := ParseInboxes(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inbox registry %q: %w", path, err)
+	}
+	return out, nil
 }
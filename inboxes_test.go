@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInboxes(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		// number of inboxes by L1 chain
+		want    map[uint64]int
+		wantErr string
+	}{
+		{
+			name: "senders share an address",
+			yaml: `
+- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000aa", senders: ["0x0000000000000000000000000000000000000001"]}
+- {l1_chain_id: 1, name: b, address: "0x00000000000000000000000000000000000000aa", senders: ["0x0000000000000000000000000000000000000002"]}
+`,
+			want: map[uint64]int{1: 2},
+		},
+		{
+			name: "address and name reused on another L1",
+			yaml: `
+- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000aa"}
+- {l1_chain_id: 5, name: a, address: "0x00000000000000000000000000000000000000aa"}
+`,
+			want: map[uint64]int{1: 1, 5: 1},
+		},
+		{
+			name: "duplicate name",
+			yaml: `
+- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000aa"}
+- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000bb"}
+`,
+			wantErr: `duplicate inbox name "a" on L1 1`,
+		},
+		{
+			name: "duplicate address",
+			yaml: `
+- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000aa"}
+- {l1_chain_id: 1, name: b, address: "0x00000000000000000000000000000000000000AA"}
+`,
+			wantErr: `inbox "b" duplicates address 0x00000000000000000000000000000000000000AA and any sender of inbox "a"`,
+		},
+		{
+			name: "duplicate sender",
+			yaml: `
+- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000aa", senders: ["0x0000000000000000000000000000000000000001"]}
+- {l1_chain_id: 1, name: b, address: "0x00000000000000000000000000000000000000aa", senders: ["0x0000000000000000000000000000000000000002", "0x0000000000000000000000000000000000000001"]}
+`,
+			wantErr: `inbox "b" duplicates address 0x00000000000000000000000000000000000000AA and sender 0x0000000000000000000000000000000000000001 of inbox "a"`,
+		},
+		{
+			name: "all senders after a sender",
+			yaml: `
+- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000aa", senders: ["0x0000000000000000000000000000000000000001"]}
+- {l1_chain_id: 1, name: b, address: "0x00000000000000000000000000000000000000aa"}
+`,
+			wantErr: `inbox "b" duplicates address 0x00000000000000000000000000000000000000AA and any sender of inbox "a"`,
+		},
+		{
+			name: "sender after all senders",
+			yaml: `
+- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000aa"}
+- {l1_chain_id: 1, name: b, address: "0x00000000000000000000000000000000000000aa", senders: ["0x0000000000000000000000000000000000000001"]}
+`,
+			wantErr: `inbox "b" duplicates address 0x00000000000000000000000000000000000000AA and sender 0x0000000000000000000000000000000000000001 of inbox "a"`,
+		},
+		{
+			name:    "invalid address",
+			yaml:    `- {l1_chain_id: 1, name: a, address: "0xaa"}`,
+			wantErr: `inbox "a" has invalid address "0xaa"`,
+		},
+		{
+			name:    "invalid method",
+			yaml:    `- {l1_chain_id: 1, name: a, address: "0x00000000000000000000000000000000000000aa", methods: ["0x1234"]}`,
+			wantErr: `inbox "a" has bad method sig len: 2`,
+		},
+		{
+			name:    "missing L1",
+			yaml:    `- {name: a, address: "0x00000000000000000000000000000000000000aa"}`,
+			wantErr: `inbox "a" has no l1_chain_id`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseInboxes([]byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got inboxes of %d L1 chains, want %d", len(got), len(tt.want))
+			}
+			for l1, n := range tt.want {
+				if got[l1] == nil || len(got[l1].List) != n {
+					t.Errorf("L1 %d: got %v, want %d inboxes", l1, got[l1], n)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultInboxes(t *testing.T) {
+	if _, err := ParseInboxes(defaultInboxesFile); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
 )
 
 type MetricJSONEntry struct {
@@ -14,100 +15,181 @@ type MetricJSONEntry struct {
 	Timestamps json.RawMessage `json:"timestamps"`
 }
 
-func ExportJSONLines[E any](ctx context.Context, timeFn func(elem E) int64, aggMetric AggregateMetric[E], w io.Writer, elems <-chan E) error {
+// MetricsHourLabel is the label that time-series are split by, so data of a reorged range can be deleted.
+const MetricsHourLabel = "mh"
 
-	n := 100
+// MetricsHour returns the "metrics hour" of the given unix milliseconds timestamp
+func MetricsHour(t int64) int64 {
+	return t / (60 * 60 * 1000)
+}
+
+// JSONLinesExporter writes the metrics of elements in batches, in the VictoriaMetrics JSON-lines format.
+// Each batch is written with a single Write call. All series are labeled with the MetricsHourLabel,
+// and a batch is flushed early when the next element falls in a different hour.
+type JSONLinesExporter[E any] struct {
+	// returns the unix timestamp in milliseconds
+	timeFn    func(elem E) int64
+	aggMetric AggregateMetric[E]
+	// labels to add to every series
+	labels  []Label
+	w       io.Writer
+	onFlush func(batch []E) error
+
+	n int
 	// we can reuse the timestamps array between all metrics we write
-	timestamps := make([]int64, 0, n)
+	timestamps []int64
+	// the elements of the current batch
+	batch []E
+	// the metrics hour of the current batch
+	hour int64
 
 	// temp buffer of values per element
-	dest := make([]float64, len(aggMetric.Names), len(aggMetric.Names))
+	dest []float64
+
+	metrics []MetricJSONEntry
 
-	var metrics []MetricJSONEntry
-	for i, name := range aggMetric.Names {
+	timestampsBuf bytes.Buffer
+	timestampsEnc *json.Encoder
+
+	outBuf  bytes.Buffer
+	jsonOut *json.Encoder
+}
+
+// NewJSONLinesExporter creates an exporter.
+// If onFlush is not nil, it is called with the elements of each batch after the batch has been written.
+func NewJSONLinesExporter[E any](timeFn func(elem E) int64, aggMetric AggregateMetric[E], labels []Label, w io.Writer, onFlush func(batch []E) error) *JSONLinesExporter[E] {
+	n := 100
+	x := &JSONLinesExporter[E]{
+		timeFn:     timeFn,
+		aggMetric:  aggMetric,
+		labels:     labels,
+		w:          w,
+		onFlush:    onFlush,
+		n:          n,
+		timestamps: make([]int64, 0, n),
+		batch:      make([]E, 0, n),
+		dest:       make([]float64, len(aggMetric.Names), len(aggMetric.Names)),
+		metrics:    make([]MetricJSONEntry, len(aggMetric.Names)),
+	}
+	for i := range x.metrics {
+		x.metrics[i].Values = make([]float64, 0, n)
+	}
+	x.timestampsBuf.Grow(14 * n) // 13 bytes per timestamp, plus delimiters
+	x.timestampsEnc = json.NewEncoder(&x.timestampsBuf)
+	x.jsonOut = json.NewEncoder(&x.outBuf)
+	return x
+}
+
+// encodeTags prepares the metric tags of every series, for the given metrics hour
+func (x *JSONLinesExporter[E]) encodeTags(hour int64) error {
+	for i, name := range x.aggMetric.Names {
 		metric := make(map[string]string)
 		metric["__name__"] = name
-		for _, label := range aggMetric.Labels[i] {
+		for _, label := range x.labels {
+			metric[label.Key] = label.Value
+		}
+		for _, label := range x.aggMetric.Labels[i] {
 			metric[label.Key] = label.Value
 		}
+		metric[MetricsHourLabel] = strconv.FormatInt(hour, 10)
 		dat, err := json.Marshal(&metric)
 		if err != nil {
 			return fmt.Errorf("failed to encode metrics tags map of %q: %w", name, err)
 		}
-		metrics = append(metrics, MetricJSONEntry{
-			Metric: json.RawMessage(dat),
-			Values: make([]float64, 0, n),
-		})
+		x.metrics[i].Metric = dat
 	}
-	var timestampsBuf bytes.Buffer
-	timestampsBuf.Grow(14 * n) // 13 bytes per timestamp, plus delimiters
-	timestampsEnc := json.NewEncoder(&timestampsBuf)
-
-	var outBuf bytes.Buffer
-	jsonOut := json.NewEncoder(&outBuf)
+	x.hour = hour
+	return nil
+}
 
-	flush := func() error {
-		if len(timestamps) == 0 {
-			return nil // return early if there is nothing to output
+// Add collects the metrics of the element, and flushes if the batch is full.
+func (x *JSONLinesExporter[E]) Add(elem E) error {
+	t := x.timeFn(elem)
+	if hour := MetricsHour(t); len(x.timestamps) == 0 || hour != x.hour {
+		if err := x.Flush(); err != nil {
+			return err
 		}
-		// only encode timestamps once
-		timestampsBuf.Reset()
-		if err := timestampsEnc.Encode(timestamps); err != nil {
-			return fmt.Errorf("failed to encode timestamps: %w", err)
+		if err := x.encodeTags(hour); err != nil {
+			return err
 		}
-		timestampsData := json.RawMessage(timestampsBuf.Bytes())
-		outBuf.Reset()
-		for i := range metrics {
-			metrics[i].Timestamps = timestampsData
-			if err := jsonOut.Encode(&metrics[i]); err != nil {
-				return fmt.Errorf("failed to encode metrics %d: %w", i, err)
-			}
-			outBuf.WriteByte('\n')
+	}
+	// clean the array
+	for i := range x.dest {
+		x.dest[i] = 0
+	}
+	// collect metrics values
+	if err := x.aggMetric.Fn(elem, x.dest); err != nil {
+		return fmt.Errorf("failed to collect t=%d metric: %w", t, err)
+	}
+	// append to destination metrics
+	for i, v := range x.dest {
+		x.metrics[i].Values = append(x.metrics[i].Values, v)
+	}
+	// append timestamp
+	x.timestamps = append(x.timestamps, t)
+	x.batch = append(x.batch, elem)
+
+	if len(x.timestamps) == x.n {
+		if err := x.Flush(); err != nil {
+			return fmt.Errorf("failed to flush metrics: %w", err)
 		}
-		if _, err := w.Write(outBuf.Bytes()); err != nil {
-			return fmt.Errorf("failed to write metrics (t0 = %d, count=%d) to output: %w", timestamps[0], len(timestamps), err)
+	}
+	return nil
+}
+
+// Flush writes the current batch, if it is not empty.
+func (x *JSONLinesExporter[E]) Flush() error {
+	if len(x.timestamps) == 0 {
+		return nil // return early if there is nothing to output
+	}
+	// only encode timestamps once
+	x.timestampsBuf.Reset()
+	if err := x.timestampsEnc.Encode(x.timestamps); err != nil {
+		return fmt.Errorf("failed to encode timestamps: %w", err)
+	}
+	timestampsData := json.RawMessage(x.timestampsBuf.Bytes())
+	x.outBuf.Reset()
+	for i := range x.metrics {
+		x.metrics[i].Timestamps = timestampsData
+		if err := x.jsonOut.Encode(&x.metrics[i]); err != nil {
+			return fmt.Errorf("failed to encode metrics %d: %w", i, err)
 		}
-		// clear metrics
-		for i := range metrics {
-			metrics[i].Values = metrics[i].Values[:0]
+	}
+	if _, err := x.w.Write(x.outBuf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write metrics (t0 = %d, count=%d) to output: %w", x.timestamps[0], len(x.timestamps), err)
+	}
+	// clear metrics
+	for i := range x.metrics {
+		x.metrics[i].Values = x.metrics[i].Values[:0]
+	}
+	// clear timestamps
+	x.timestamps = x.timestamps[:0]
+	if x.onFlush != nil {
+		if err := x.onFlush(x.batch); err != nil {
+			return fmt.Errorf("failed to process flushed batch: %w", err)
 		}
-		// clear timestamps
-		timestamps = timestamps[:0]
-		return nil
 	}
+	x.batch = x.batch[:0]
+	return nil
+}
 
+// ExportJSONLines exports all elements with a JSONLinesExporter, until the elements channel is closed.
+func ExportJSONLines[E any](ctx context.Context, timeFn func(elem E) int64, aggMetric AggregateMetric[E], labels []Label, w io.Writer, elems <-chan E, onFlush func(batch []E) error) error {
+	x := NewJSONLinesExporter[E](timeFn, aggMetric, labels, w, onFlush)
 	for {
-		// clean the array
-		for i := range dest {
-			dest[i] = 0
-		}
 		// get the next element
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case elem, ok := <-elems:
 			if !ok {
-				if err := flush(); err != nil {
+				if err := x.Flush(); err != nil {
 					return fmt.Errorf("failed to flush metrics (on exit): %w", err)
 				}
 				return nil
 			}
-			t := timeFn(elem)
-			// collect metrics values
-			if err := aggMetric.Fn(elem, dest); err != nil {
-				return fmt.Errorf("failed to collect t=%d metric: %w", t, err)
-			}
-			// append to destination metrics
-			for i, v := range dest {
-				metrics[i].Values = append(metrics[i].Values, v)
-			}
-			// append timestamp
-			timestamps = append(timestamps, t)
-
-			if len(timestamps) == n {
-				if err := flush(); err != nil {
-					return fmt.Errorf("failed to flush metrics: %w", err)
-				}
+			if err := x.Add(elem); err != nil {
+				return err
 			}
 		}
 	}
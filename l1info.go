@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-node/rollup"
+	"github.com/ethereum-optimism/optimism/op-node/rollup/derive"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+)
+
+// without any upgrade activated, derive.L1BlockInfoFromBytes decodes the Bedrock format
+var bedrockRollupConfig = &rollup.Config{}
+
+const (
+	// length of the packed Ecotone L1 attributes calldata
+	l1InfoEcotoneLen = 4 + 4 + 4 + 8 + 8 + 8 + 32 + 32 + 32 + 32
+	// Isthmus appends the operator fee scalar and constant
+	l1InfoIsthmusLen = l1InfoEcotoneLen + 4 + 8
+	// Jovian appends the DA footprint gas scalar
+	l1InfoJovianLen = l1InfoIsthmusLen + 2
+)
+
+// L1Info is the content of the L1 attributes deposit transaction, that starts every OP Stack block.
+type L1Info struct {
+	// L1 origin of the L2 block
+	Number    uint64
+	Time      uint64
+	BaseFee   *big.Int
+	BlockHash common.Hash
+	// nil before Ecotone
+	BlobBaseFee *big.Int
+	// number of L2 blocks since the L1 origin changed
+	SequenceNumber uint64
+	BatcherHash    common.Hash
+
+	// Bedrock fee parameters, zero since Ecotone
+	L1FeeOverhead, L1FeeScalar *big.Int
+	// Ecotone fee parameters, zero before Ecotone
+	BaseFeeScalar, BlobBaseFeeScalar uint32
+}
+
+// ParseL1Info decodes the calldata of the L1 attributes deposit transaction,
+// of the Bedrock format (ABI encoded) or the Ecotone format (packed). Isthmus and Jovian extend the Ecotone format,
+// the additional operator fee and DA footprint parameters are ignored.
+func ParseL1Info(data []byte) (*L1Info, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("L1 info data too short: %d", len(data))
+	}
+	switch sig := data[:4]; {
+	case bytes.Equal(sig, derive.L1InfoFuncBedrockBytes4):
+		info, err := derive.L1BlockInfoFromBytes(bedrockRollupConfig, 0, data)
+		if err != nil {
+			return nil, err
+		}
+		return &L1Info{
+			Number:         info.Number,
+			Time:           info.Time,
+			BaseFee:        info.BaseFee,
+			BlockHash:      info.BlockHash,
+			SequenceNumber: info.SequenceNumber,
+			BatcherHash:    common.BytesToHash(info.BatcherAddr[:]),
+			L1FeeOverhead:  new(big.Int).SetBytes(info.L1FeeOverhead[:]),
+			L1FeeScalar:    new(big.Int).SetBytes(info.L1FeeScalar[:]),
+		}, nil
+	case bytes.Equal(sig, derive.L1InfoFuncEcotoneBytes4) && len(data) == l1InfoEcotoneLen,
+		bytes.Equal(sig, derive.L1InfoFuncIsthmusBytes4) && len(data) == l1InfoIsthmusLen,
+		bytes.Equal(sig, derive.L1InfoFuncJovianBytes4) && len(data) == l1InfoJovianLen:
+		return &L1Info{
+			BaseFeeScalar:     binary.BigEndian.Uint32(data[4:8]),
+			BlobBaseFeeScalar: binary.BigEndian.Uint32(data[8:12]),
+			SequenceNumber:    binary.BigEndian.Uint64(data[12:20]),
+			Time:              binary.BigEndian.Uint64(data[20:28]),
+			Number:            binary.BigEndian.Uint64(data[28:36]),
+			BaseFee:           new(big.Int).SetBytes(data[36:68]),
+			BlobBaseFee:       new(big.Int).SetBytes(data[68:100]),
+			BlockHash:         common.BytesToHash(data[100:132]),
+			BatcherHash:       common.BytesToHash(data[132:164]),
+			L1FeeOverhead:     new(big.Int),
+			L1FeeScalar:       new(big.Int),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized L1 info format %x of length %d", sig, len(data))
+	}
+}
+
+// blockL1Info decodes the L1 attributes of the block, or returns nil if the first transaction is not
+// an L1 attributes deposit of a known format.
+func blockL1Info(bl *types.Block) *L1Info {
+	txs := bl.Transactions()
+	if len(txs) == 0 || txs[0].Type() != types.DepositTxType {
+		return nil
+	}
+	info, err := ParseL1Info(txs[0].Data())
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+// l1InfoMetric creates a metric of the L1 attributes of the block, which is 0 if these cannot be decoded,
+// see L1InfoInvalidMetric.
+func l1InfoMetric(name string, fn func(info *L1Info) float64) Metric[*L1Info] {
+	return Metric[*L1Info]{
+		Name: name,
+		Fn: func(info *L1Info) (float64, error) {
+			if info == nil {
+				return 0, nil
+			}
+			return fn(info), nil
+		},
+	}
+}
+
+// L1InfoInvalidMetric is 1 if the L1 attributes of the block cannot be decoded, so the 0 values of the other
+// L1 info metrics of the block can be told apart from real values.
+var L1InfoInvalidMetric = Metric[*L1Info]{
+	Name: "l1_info_invalid",
+	Fn: func(info *L1Info) (float64, error) {
+		if info == nil {
+			return 1, nil
+		}
+		return 0, nil
+	},
+}
+
+var L1OriginNumberMetric = l1InfoMetric("l1_origin_number", func(info *L1Info) float64 {
+	return float64(info.Number)
+})
+
+var L1OriginBaseFeeMetric = l1InfoMetric("l1_origin_basefee", func(info *L1Info) float64 {
+	return GweiFloat64(info.BaseFee)
+})
+
+var L1OriginBlobBaseFeeMetric = l1InfoMetric("l1_origin_blob_basefee", func(info *L1Info) float64 {
+	return GweiFloat64(info.BlobBaseFee)
+})
+
+var L1FeeOverheadMetric = l1InfoMetric("l1_fee_overhead", func(info *L1Info) float64 {
+	v, _ := new(big.Float).SetInt(info.L1FeeOverhead).Float64()
+	return v
+})
+
+// L1FeeScalarMetric is the raw Bedrock fee scalar, with 6 decimals.
+var L1FeeScalarMetric = l1InfoMetric("l1_fee_scalar", func(info *L1Info) float64 {
+	v, _ := new(big.Float).SetInt(info.L1FeeScalar).Float64()
+	return v
+})
+
+var L1BaseFeeScalarMetric = l1InfoMetric("l1_basefee_scalar", func(info *L1Info) float64 {
+	return float64(info.BaseFeeScalar)
+})
+
+var L1BlobBaseFeeScalarMetric = l1InfoMetric("l1_blob_basefee_scalar", func(info *L1Info) float64 {
+	return float64(info.BlobBaseFeeScalar)
+})
+
+var L1InfoSequenceNumberMetric = l1InfoMetric("l1_info_sequence_number", func(info *L1Info) float64 {
+	return float64(info.SequenceNumber)
+})
+
+// L1BatcherHashMetric maps the batcher address to a float64, like block_hash, to graph batcher changes.
+var L1BatcherHashMetric = l1InfoMetric("l1_batcher_hash", func(info *L1Info) float64 {
+	return float64(binary.LittleEndian.Uint64(info.BatcherHash[12:20]))
+})
+
+var L1InfoMetrics = TransformAggregate[*L1Info, *types.Block](
+	blockL1Info,
+	Aggregate[*L1Info](
+		L1InfoInvalidMetric,
+		L1OriginNumberMetric,
+		L1OriginBaseFeeMetric,
+		L1OriginBlobBaseFeeMetric,
+		L1FeeOverheadMetric,
+		L1FeeScalarMetric,
+		L1BaseFeeScalarMetric,
+		L1BlobBaseFeeScalarMetric,
+		L1InfoSequenceNumberMetric,
+		L1BatcherHashMetric,
+	),
+)
+
+const (
+	// time between L1 blocks, after the merge
+	l1BlockTime = 12
+	// the max sequencer drift of the OP Stack, since Fjord, limits how far the L1 origin can be behind
+	maxL1OriginLag = 1800 / l1BlockTime
+)
+
+// l1HeadAt returns the number of the latest L1 block as of the given time, searching from the L1 origin.
+// L1 blocks are at least an L1 block time apart, so a block is the head until an L1 block time after it,
+// also if the next block is not exported yet. After a missed slot, the next block must be exported.
+// It returns false if the L1 blocks between the origin and the head are not indexed.
+func l1HeadAt(l1DB *ChainDB, origin *L1Info, t uint64) (uint64, bool, error) {
+	for num := origin.Number; num <= origin.Number+maxL1OriginLag; num++ {
+		blTime, ok, err := l1DB.BlockTime(num)
+		if err != nil || !ok {
+			return 0, false, err
+		}
+		if num == origin.Number {
+			// the origin must be the block that the L1 chain exported, and not be newer than the L2 block
+			if blTime != origin.Time || blTime > t {
+				return 0, false, nil
+			}
+		} else if blTime > t {
+			// the next block came after a missed slot
+			return num - 1, true, nil
+		}
+		if blTime+l1BlockTime > t {
+			return num, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// L1OriginMetrics creates the metrics of the L1 origin of each block, relative to the block itself:
+//   - l1_origin_drift: the time of the block minus the time of its L1 origin, the sequencer drift, in seconds.
+//   - l1_origin_lag: the number of L1 blocks that the L1 origin is behind the L1 head as of the time of the block.
+//     The L1 head is looked up in the block times that the L1 chain DB indexes, so the lag does not depend on
+//     when the block is exported. -1 if unknown: without an L1 chain, or if the L1 blocks are not exported (yet).
+func L1OriginMetrics(l1DB *ChainDB) AggregateMetric[*types.Block] {
+	return Aggregate[*types.Block](
+		Metric[*types.Block]{
+			Name: "l1_origin_drift",
+			Fn: func(bl *types.Block) (float64, error) {
+				info := blockL1Info(bl)
+				if info == nil {
+					return 0, nil
+				}
+				return float64(bl.Time()) - float64(info.Time), nil
+			},
+		},
+		Metric[*types.Block]{
+			Name: "l1_origin_lag",
+			Fn: func(bl *types.Block) (float64, error) {
+				info := blockL1Info(bl)
+				if info == nil || l1DB == nil {
+					return -1, nil
+				}
+				head, ok, err := l1HeadAt(l1DB, info, bl.Time())
+				if err != nil || !ok {
+					return -1, err
+				}
+				return float64(head - info.Number), nil
+			},
+		},
+	)
+}
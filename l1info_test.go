@@ -0,0 +1,273 @@
+package main
+
+import (
+	"github.com/ethereum-optimism/optimism/op-node/rollup"
+	"github.com/ethereum-optimism/optimism/op-node/rollup/derive"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// L1 attributes calldata in the formats of the OP mainnet upgrades, with the OP mainnet batcher and fee parameters
+const (
+	l1InfoBedrockData = "0x015d8eb9" +
+		"0000000000000000000000000000000000000000000000000000000001036640" + // number
+		"0000000000000000000000000000000000000000000000000000000064414883" + // time
+		"00000000000000000000000000000000000000000000000000000005d21dba00" + // base fee
+		"c1d2e3f405162738495a6b7c8d9eafb0c1d2e3f405162738495a6b7c8d9eafb0" + // block hash
+		"0000000000000000000000000000000000000000000000000000000000000002" + // sequence number
+		"0000000000000000000000006887246668a3b87f54deb3b94ba47a6f63f32985" + // batcher hash
+		"00000000000000000000000000000000000000000000000000000000000000bc" + // fee overhead
+		"00000000000000000000000000000000000000000000000000000000000a6fe0" // fee scalar
+	l1InfoEcotoneData = "0x440a5e20" +
+		"00000558" + "000c5fc5" + // base fee scalar, blob base fee scalar
+		"0000000000000003" + "0000000066669983" + "0000000001328c90" + // sequence number, time, number
+		"00000000000000000000000000000000000000000000000000000000f5c6f515" + // base fee
+		"0000000000000000000000000000000000000000000000000000000000000001" + // blob base fee
+		"a4b9c1d2e3f405162738495a6b7c8d9eafb0c1d2e3f405162738495a6b7c8d9e" + // block hash
+		"0000000000000000000000006887246668a3b87f54deb3b94ba47a6f63f32985" // batcher hash
+	l1InfoIsthmusData = "0x098999be" +
+		"0000146b" + "000f79c5" + // base fee scalar, blob base fee scalar
+		"0000000000000001" + "0000000068211ac7" + "000000000156b660" + // sequence number, time, number
+		"00000000000000000000000000000000000000000000000000000000499602d2" + // base fee
+		"0000000000000000000000000000000000000000000000000000000000000001" + // blob base fee
+		"5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d" + // block hash
+		"0000000000000000000000006887246668a3b87f54deb3b94ba47a6f63f32985" + // batcher hash
+		"00000000" + "0000000000000000" // operator fee scalar and constant
+)
+
+// jovianRollupConfig activates all upgrades up to Jovian at genesis
+var jovianRollupConfig = func() *rollup.Config {
+	zero := uint64(0)
+	return &rollup.Config{BlockTime: 2, EcotoneTime: &zero, IsthmusTime: &zero, JovianTime: &zero}
+}()
+
+// l1InfoJovianData encodes the L1 attributes calldata of a Jovian block with the upstream encoder,
+// and returns it with the hash of the L1 origin
+func l1InfoJovianData(t *testing.T) (string, common.Hash) {
+	excessBlobGas, blobGasUsed := uint64(0), uint64(0)
+	l1 := &types.Header{
+		Number:        big.NewInt(23_500_000),
+		Time:          1_765_000_007,
+		BaseFee:       big.NewInt(987_654_321),
+		Difficulty:    new(big.Int),
+		ExcessBlobGas: &excessBlobGas,
+		BlobGasUsed:   &blobGasUsed,
+	}
+	sysCfg := eth.SystemConfig{
+		BatcherAddr:          common.HexToAddress("0x6887246668a3b87f54deb3b94ba47a6f63f32985"),
+		Scalar:               eth.EncodeScalar(eth.EcotoneScalars{BaseFeeScalar: 5227, BlobBaseFeeScalar: 1014213}),
+		OperatorFeeParams:    eth.EncodeOperatorFeeParams(eth.OperatorFeeParams{Scalar: 7, Constant: 11}),
+		DAFootprintGasScalar: 400,
+	}
+	tx, err := derive.L1InfoDeposit(jovianRollupConfig, params.MainnetChainConfig, sysCfg, 4, eth.HeaderBlockInfo(l1), 1_765_000_011)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(tx.Data), l1.Hash()
+}
+
+func TestParseL1Info(t *testing.T) {
+	zero := uint64(0)
+	batcherHash := common.HexToHash("0x6887246668a3b87f54deb3b94ba47a6f63f32985")
+	l1InfoJovianData, jovianOrigin := l1InfoJovianData(t)
+	tests := []struct {
+		name string
+		data string
+		want *L1Info
+		// the rollup config with which the upstream decoder decodes the data, nil if it does not apply
+		upstream *rollup.Config
+		wantErr  string
+	}{
+		{
+			name: "bedrock",
+			data: l1InfoBedrockData,
+			want: &L1Info{
+				Number: 17000000, Time: 1682000003, BaseFee: big.NewInt(25e9),
+				BlockHash:      common.HexToHash("0xc1d2e3f405162738495a6b7c8d9eafb0c1d2e3f405162738495a6b7c8d9eafb0"),
+				SequenceNumber: 2, BatcherHash: batcherHash, L1FeeOverhead: big.NewInt(188), L1FeeScalar: big.NewInt(684000),
+			},
+			upstream: &rollup.Config{},
+		},
+		{
+			name: "ecotone",
+			data: l1InfoEcotoneData,
+			want: &L1Info{
+				Number: 20090000, Time: 1718000003, BaseFee: big.NewInt(4_123_456_789), BlobBaseFee: big.NewInt(1),
+				BlockHash:      common.HexToHash("0xa4b9c1d2e3f405162738495a6b7c8d9eafb0c1d2e3f405162738495a6b7c8d9e"),
+				SequenceNumber: 3, BatcherHash: batcherHash, L1FeeOverhead: new(big.Int), L1FeeScalar: new(big.Int),
+				BaseFeeScalar: 1368, BlobBaseFeeScalar: 810949,
+			},
+			upstream: &rollup.Config{BlockTime: 2, EcotoneTime: &zero},
+		},
+		{
+			name: "isthmus",
+			data: l1InfoIsthmusData,
+			want: &L1Info{
+				Number: 22460000, Time: 1747000007, BaseFee: big.NewInt(1_234_567_890), BlobBaseFee: big.NewInt(1),
+				BlockHash:      common.HexToHash("0x5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d"),
+				SequenceNumber: 1, BatcherHash: batcherHash, L1FeeOverhead: new(big.Int), L1FeeScalar: new(big.Int),
+				BaseFeeScalar: 5227, BlobBaseFeeScalar: 1014213,
+			},
+			upstream: &rollup.Config{BlockTime: 2, EcotoneTime: &zero, IsthmusTime: &zero},
+		},
+		{
+			name: "jovian",
+			data: l1InfoJovianData,
+			want: &L1Info{
+				Number: 23_500_000, Time: 1_765_000_007, BaseFee: big.NewInt(987_654_321), BlobBaseFee: big.NewInt(1),
+				BlockHash:      jovianOrigin,
+				SequenceNumber: 4, BatcherHash: batcherHash, L1FeeOverhead: new(big.Int), L1FeeScalar: new(big.Int),
+				BaseFeeScalar: 5227, BlobBaseFeeScalar: 1014213,
+			},
+			upstream: jovianRollupConfig,
+		},
+		{name: "too short", data: "0x440a5e", wantErr: "too short"},
+		{name: "jovian of the isthmus length", data: l1InfoJovianData[:2+2*l1InfoIsthmusLen], wantErr: "unrecognized L1 info format"},
+		{name: "ecotone of the isthmus length", data: "0x440a5e20" + l1InfoIsthmusData[10:], wantErr: "unrecognized L1 info format 440a5e20 of length 176"},
+		{name: "isthmus of the ecotone length", data: "0x098999be" + l1InfoEcotoneData[10:], wantErr: "unrecognized L1 info format 098999be of length 164"},
+		{name: "unknown selector", data: "0x12345678" + l1InfoEcotoneData[10:], wantErr: "unrecognized L1 info format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := hexutil.MustDecode(tt.data)
+			got, err := ParseL1Info(data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkL1Info(t, got, tt.want)
+
+			// the upstream decoder must agree, on the fields that it has in the format
+			info, err := derive.L1BlockInfoFromBytes(tt.upstream, 1000, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			upstream := &L1Info{
+				Number: info.Number, Time: info.Time, BaseFee: info.BaseFee, BlockHash: info.BlockHash,
+				BlobBaseFee: got.BlobBaseFee, SequenceNumber: info.SequenceNumber,
+				BatcherHash:   common.BytesToHash(info.BatcherAddr[:]),
+				L1FeeOverhead: new(big.Int).SetBytes(info.L1FeeOverhead[:]), L1FeeScalar: new(big.Int).SetBytes(info.L1FeeScalar[:]),
+				BaseFeeScalar: info.BaseFeeScalar, BlobBaseFeeScalar: info.BlobBaseFeeScalar,
+			}
+			if info.BlobBaseFee != nil {
+				upstream.BlobBaseFee = info.BlobBaseFee
+			}
+			checkL1Info(t, got, upstream)
+		})
+	}
+}
+
+func TestL1InfoMetrics(t *testing.T) {
+	jovian, _ := l1InfoJovianData(t)
+	tests := []struct {
+		name string
+		data string
+		// expected values by metric name
+		want map[string]float64
+	}{
+		{name: "valid", data: jovian, want: map[string]float64{"l1_info_invalid": 0, "l1_origin_number": 23_500_000}},
+		{name: "invalid", data: jovian[:len(jovian)-2], want: map[string]float64{"l1_info_invalid": 1, "l1_origin_number": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bl := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)}).WithBody(types.Body{
+				Transactions: []*types.Transaction{types.NewTx(&types.DepositTx{Data: hexutil.MustDecode(tt.data)})},
+			})
+			dest := make([]float64, len(L1InfoMetrics.Names))
+			if err := L1InfoMetrics.Fn(bl, dest); err != nil {
+				t.Fatal(err)
+			}
+			found := 0
+			for i, name := range L1InfoMetrics.Names {
+				if want, ok := tt.want[name]; ok {
+					found += 1
+					if dest[i] != want {
+						t.Errorf("%s: got %v, want %v", name, dest[i], want)
+					}
+				}
+			}
+			if found != len(tt.want) {
+				t.Errorf("got %d of the %d metrics", found, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestL1HeadAt(t *testing.T) {
+	// L1 blocks 100 to 104, with a missed slot before block 103
+	times := map[uint64]uint64{100: 1000, 101: 1012, 102: 1024, 103: 1048, 104: 1060}
+	tests := []struct {
+		name   string
+		origin L1Info
+		time   uint64
+		// blocks from which the L1 chain DB forgets the times, 0 if none
+		forget uint64
+		want   uint64
+		wantOK bool
+	}{
+		{name: "origin is the head", origin: L1Info{Number: 100, Time: 1000}, time: 1011, want: 100, wantOK: true},
+		{name: "origin time", origin: L1Info{Number: 100, Time: 1000}, time: 1000, want: 100, wantOK: true},
+		{name: "next block", origin: L1Info{Number: 100, Time: 1000}, time: 1012, want: 101, wantOK: true},
+		{name: "missed slot", origin: L1Info{Number: 101, Time: 1012}, time: 1047, want: 102, wantOK: true},
+		{name: "last exported block", origin: L1Info{Number: 100, Time: 1000}, time: 1071, want: 104, wantOK: true},
+		{name: "after the exported blocks", origin: L1Info{Number: 100, Time: 1000}, time: 1072},
+		{name: "origin is not exported", origin: L1Info{Number: 99, Time: 988}, time: 1000},
+		{name: "origin of another chain", origin: L1Info{Number: 100, Time: 999}, time: 1000},
+		{name: "origin after the block", origin: L1Info{Number: 101, Time: 1012}, time: 1000},
+		{name: "missed slot of the last exported block", origin: L1Info{Number: 101, Time: 1012}, time: 1047, forget: 103},
+		{name: "forgotten", origin: L1Info{Number: 100, Time: 1000}, time: 1012, forget: 101},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			db.IndexTimes()
+			for num, blTime := range times {
+				if err := db.PutBlockTime(num, blTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.forget != 0 {
+				if _, err := db.ForgetFrom(tt.forget); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, ok, err := l1HeadAt(db, &tt.origin, tt.time)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got %d %v, want %d %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func checkL1Info(t *testing.T, got, want *L1Info) {
+	t.Helper()
+	bigEq := func(a, b *big.Int) bool {
+		return (a == nil) == (b == nil) && (a == nil || a.Cmp(b) == 0)
+	}
+	if got.Number != want.Number || got.Time != want.Time || got.BlockHash != want.BlockHash ||
+		got.SequenceNumber != want.SequenceNumber || got.BatcherHash != want.BatcherHash ||
+		got.BaseFeeScalar != want.BaseFeeScalar || got.BlobBaseFeeScalar != want.BlobBaseFeeScalar ||
+		!bigEq(got.BaseFee, want.BaseFee) || !bigEq(got.BlobBaseFee, want.BlobBaseFee) ||
+		!bigEq(got.L1FeeOverhead, want.L1FeeOverhead) || !bigEq(got.L1FeeScalar, want.L1FeeScalar) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"sync"
+	"time"
+)
+
+// how long the pipelines may take to drain after a shutdown is requested, before they are canceled
+const shutdownTimeout = 30 * time.Second
+
+// RunLive runs the pipeline of every chain, until ctx is canceled or a stage fails.
+// When ctx is canceled, the block producers stop, and the blocks that are in flight are drained:
+// their receipts are fetched, and the last partial batch of each chain is flushed.
+// A batch is recorded as written only after it was fully imported,
+// so if draining times out, the unflushed blocks are exported again on the next run.
+func (sys *System) RunLive(ctx context.Context, log log.Logger, withBackfill bool) error {
+	sup := NewSupervisor(context.Background())
+	// producers stop on shutdown, the rest of the pipeline stops when it has drained
+	producerCtx, stopProducers := context.WithCancel(sup.Context())
+	defer stopProducers()
+
+	for _, ch := range sys.Chains {
+		m, ok := chainAggregateMetric(ch)
+		if !ok {
+			log.Warn("not exporting chain, unhandled chain type", "chain", ch.Name, "type", ch.Type)
+			continue
+		}
+		sys.runChain(sup, producerCtx, log.New("chain", ch.Name), ch, m, withBackfill)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-sup.Context().Done():
+			return
+		}
+		log.Info("shutting down, draining pipelines", "timeout", shutdownTimeout)
+		stopProducers()
+		select {
+		case <-time.After(shutdownTimeout):
+			log.Warn("pipelines did not drain in time, stopping")
+			sup.Stop()
+		case <-sup.Context().Done():
+		}
+	}()
+	err := sup.Wait()
+	if err == nil {
+		log.Info("shut down pipelines")
+	}
+	return err
+}
+
+// runChain starts the stages of the chain pipeline: block producers, receipts and exporter,
+// and the finalized-only exporters of the chain.
+func (sys *System) runChain(sup *Supervisor, producerCtx context.Context, log log.Logger, ch *Chain, m AggregateMetric[*BlockWithReceipts], withBackfill bool) {
+	// TODO determine buffer size
+	blocks := make(chan *types.Block, 100)
+
+	var producers sync.WaitGroup
+	producers.Add(1)
+	sup.Go(ch.Name+" follower", func(ctx context.Context) error {
+		defer producers.Done()
+		follower := NewHeadFollower(log.New("stage", "forward"), ch.Blocks, ch.DB, blocks)
+		return follower.Run(producerCtx)
+	})
+	if withBackfill {
+		producers.Add(1)
+		sup.Go(ch.Name+" backfiller", func(ctx context.Context) error {
+			defer producers.Done()
+			backfiller := NewBackfiller(log.New("stage", "backfill"), ch.History, ch.DB, ch.MinTime, blocks)
+			return backfiller.Run(producerCtx)
+		})
+	}
+	// the receipts stage drains when all producers stopped
+	go func() {
+		producers.Wait()
+		close(blocks)
+	}()
+
+	// transform the blocks into processable blocks with receipts
+	sup.Go(ch.Name+" receipts", func(ctx context.Context) error {
+		defer close(ch.Buffer)
+		stage := NewReceiptsStage(log.New("stage", "receipts"), ch.Receipts, ch.Blocks)
+		return stage.Run(ctx, blocks, ch.Buffer)
+	})
+
+	// consumer, flushes the last batch when the buffer is closed
+	sup.Go(ch.Name+" exporter", func(ctx context.Context) error {
+		exporter := NewChainExporter(ctx, log.New("stage", "export"), ch, sys.Victoria, m)
+		return exporter.Run(ctx, ch.Buffer)
+	})
+
+	// the finalized-only metrics are independent of the blocks pipeline, and flush when the producers stop
+	if ch.Type == EthereumChain {
+		sup.Go(ch.Name+" blobs", func(ctx context.Context) error {
+			exporter := NewBlobExporter(ctx, log.New("stage", "blobs"), ch, sys.Victoria)
+			if ch.CandidateThreshold > 0 {
+				// the candidates are detected in the finalized blocks that the blob metrics are exported from
+				detector := NewCandidateDetector(log.New("stage", "candidates"), ch, sys.Victoria,
+					ch.CandidateThreshold, ch.CandidateWindow)
+				exporter.Observe(detector.Process)
+			}
+			return exporter.Run(producerCtx)
+		})
+		if len(ch.Inboxes.OPStack().List) > 0 {
+			sup.Go(ch.Name+" batches", func(ctx context.Context) error {
+				exporter := NewBatcherExporter(ctx, log.New("stage", "batches"), ch, sys.Victoria)
+				return exporter.Run(producerCtx)
+			})
+		}
+	}
+	if ch.Type == OPStackChain {
+		sup.Go(ch.Name+" sync status", func(ctx context.Context) error {
+			poller := NewSyncStatusPoller(log.New("stage", "sync_status"), ch, sys.Victoria)
+			return poller.Run(producerCtx)
+		})
+	}
+	if ch.Beacon != nil {
+		sup.Go(ch.Name+" beacon", func(ctx context.Context) error {
+			exporter := NewBeaconExporter(ctx, log.New("stage", "beacon"), ch, sys.Victoria)
+			return exporter.Run(producerCtx)
+		})
+	}
+}
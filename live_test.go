@@ -0,0 +1,120 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signalingReceiptsSource signals every block of which the receipts are fetched
+type signalingReceiptsSource struct {
+	fetched chan uint64
+}
+
+func (s *signalingReceiptsSource) FetchReceipts(ctx context.Context, bl *types.Block) (types.Receipts, error) {
+	s.fetched <- bl.NumberU64()
+	return types.Receipts{}, nil
+}
+
+func TestRunLiveDrains(t *testing.T) {
+	c := &chainRPC{byHash: make(map[common.Hash]*types.Block)}
+	genesis := types.NewBlockWithHeader(&types.Header{Number: new(big.Int), Difficulty: new(big.Int), BaseFee: big.NewInt(1)})
+	c.byHash[genesis.Hash()] = genesis
+	blocks := c.extend(genesis, 5, 'a')
+	head := blocks[len(blocks)-1]
+	c.head = head
+
+	var mu sync.Mutex
+	var imported strings.Builder
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/import" {
+			gr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("failed to decompress body: %v", err)
+				return
+			}
+			data, err := io.ReadAll(gr)
+			if err != nil {
+				t.Errorf("failed to read body: %v", err)
+			}
+			mu.Lock()
+			imported.Write(data)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	logger := log.NewLogger(log.DiscardHandler())
+	victoria, err := NewVictoriaClient(logger, &DBConfig{Victoria: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	receipts := &signalingReceiptsSource{fetched: make(chan uint64, 10)}
+	ch := &Chain{
+		Name:     "test",
+		Type:     EthereumChain,
+		EthRPC:   c,
+		Config:   &params.ChainConfig{ChainID: big.NewInt(1), LondonBlock: new(big.Int)},
+		Blocks:   NewRPCBlockSource(c),
+		Receipts: receipts,
+		Inboxes:  newInboxes(nil),
+		DB:       db,
+		Buffer:   make(chan *BlockWithReceipts, 100),
+	}
+	sys := &System{Victoria: victoria, Chains: []*Chain{ch}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- sys.RunLive(ctx, logger, false)
+	}()
+	// the follower starts at the head, which is held in the partial batch of the exporter
+	select {
+	case num := <-receipts.fetched:
+		if num != head.NumberU64() {
+			t.Fatalf("got receipts of block %d, want %d", num, head.NumberU64())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the head block")
+	}
+	if _, ok := db.Get(head.NumberU64()); ok {
+		t.Fatal("head block was flushed before the shutdown")
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Fatal("timed out waiting for the pipelines to drain")
+	}
+
+	rec, ok := db.Get(head.NumberU64())
+	if !ok || rec.Status != BlockExported || rec.Hash != head.Hash() {
+		t.Errorf("got record %+v %v of the head block, want it to be exported", rec, ok)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(imported.String(), `"chain":"test"`) {
+		t.Errorf("head block was not imported, got %q", imported.String())
+	}
+}
@@ -1,19 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	oplog "github.com/ethereum-optimism/optimism/op-service/log"
-	"github.com/ethereum-optimism/optimism/op-service/opio"
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
-	"github.com/ethereum/go-ethereum/params"
 	"github.com/urfave/cli/v2"
 	"gopkg.in/yaml.v3"
+	"io"
 	"os"
 	"os/signal"
-	"time"
+	"sync"
+	"syscall"
 )
 
 var (
@@ -22,12 +21,29 @@ var (
 		Usage: "path to config file",
 		Value: "config.yaml",
 	}
+	StartTimeFlag = &cli.Uint64Flag{
+		Name:  "start-time",
+		Usage: "unix timestamp (seconds) of the first block to process, defaults to the min_time of the chain",
+	}
+	EndTimeFlag = &cli.Uint64Flag{
+		Name:  "end-time",
+		Usage: "unix timestamp (seconds) to process blocks up to, exclusive, defaults to the finalized block",
+	}
+	OutputFlag = &cli.PathFlag{
+		Name:  "output",
+		Usage: "path of the file to write to, or - for stdout",
+		Value: "-",
+	}
+	ChainFlag = &cli.StringSliceFlag{
+		Name:  "chain",
+		Usage: "name of a chain to process, can be repeated, defaults to all configured chains",
+	}
 )
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	interruptChannel := make(chan os.Signal, 1)
-	signal.Notify(interruptChannel, opio.DefaultInterruptSignals...)
+	signal.Notify(interruptChannel, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-interruptChannel
 		cancel()
@@ -35,10 +51,29 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "onchain-metrics"
 	app.Description = "export onchain metrics to victoria-metrics"
-	app.Flags = []cli.Flag{
+	app.Flags = append([]cli.Flag{
 		ConfigLocationFlag,
-	}
+	}, oplog.CLIFlags("CHAIN_METRICS")...)
 	app.Action = start
+	app.Commands = []*cli.Command{
+		{
+			Name:   "live",
+			Usage:  "follow the heads of the chains, and export the metrics of new blocks to victoria-metrics",
+			Action: live,
+		},
+		{
+			Name:   "backfill",
+			Usage:  "export the metrics of finalized historical blocks through the victoria-metrics CSV import",
+			Flags:  []cli.Flag{StartTimeFlag, EndTimeFlag, ChainFlag},
+			Action: backfill,
+		},
+		{
+			Name:   "csv",
+			Usage:  "write the metrics of a range of blocks of a chain to a CSV file",
+			Flags:  []cli.Flag{StartTimeFlag, EndTimeFlag, ChainFlag, OutputFlag},
+			Action: dump,
+		},
+	}
 	if err := app.RunContext(ctx, os.Args); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "error: %v", err)
 		os.Exit(1)
@@ -58,115 +93,208 @@ func readConfig(configFilePath string) (*Config, error) {
 	return &cfg, nil
 }
 
-func start(ctx *cli.Context) error {
-	logCfg := oplog.ReadCLIConfig(ctx)
-	if err := logCfg.Check(); err != nil {
-		return err
-	}
-	logger := oplog.NewLogger(logCfg)
+// setup creates the logger and the system, as configured by the CLI flags.
+// The logs are written to logOut.
+func setup(ctx *cli.Context, logOut io.Writer) (log.Logger, *System, error) {
+	logger := oplog.NewLogger(logOut, oplog.ReadCLIConfig(ctx))
+	oplog.SetGlobalLogHandler(logger.Handler())
 
 	config, err := readConfig(ctx.String(ConfigLocationFlag.Name))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	sys, err := NewSystem(ctx.Context, logger, config)
 	if err != nil {
-		return fmt.Errorf("failed to init system: %w", err)
+		return nil, nil, fmt.Errorf("failed to init system: %w", err)
 	}
+	return logger, sys, nil
+}
 
-	for _, ch := range sys.Chains {
-		var m AggregateMetric[*BlockWithReceipts]
-		switch ch.Type {
-		case OPStackChain:
-			var chainConfig params.ChainConfig
-			if err := ch.EthRPC.CallContext(ctx.Context, &chainConfig, "eth_chainConfig"); err != nil {
-				return fmt.Errorf("failed to get chain config of %s: %w", ch.Name, err)
-			}
-			m = OPMetrics(&chainConfig)
-		case EthereumChain:
-			var chainConfig params.ChainConfig
-			if err := ch.EthRPC.CallContext(ctx.Context, &chainConfig, "eth_chainConfig"); err != nil {
-				return fmt.Errorf("failed to get chain config of %s: %w", ch.Name, err)
-			}
-			m = EthMetrics(&chainConfig)
-		default:
-			logger.Info("unhandled chain type", "type", ch.Type)
+// chainAggregateMetric returns the metrics to export for the chain, and false if the chain type has none.
+func chainAggregateMetric(ch *Chain) (AggregateMetric[*BlockWithReceipts], bool) {
+	var m AggregateMetric[*BlockWithReceipts]
+	switch ch.Type {
+	case OPStackChain:
+		var l1DB *ChainDB
+		if ch.L1 != nil {
+			l1DB = ch.L1.DB
 		}
-		go sys.chainMetrics(ctx.Context, logger, ch, m)
+		m = OPMetrics(ch.Config, l1DB, ch.Deposits)
+	case EthereumChain:
+		m = EthMetrics(ch.Config)
+		if len(ch.Inboxes.List) > 0 {
+			// attribute the transactions of the L1, and their costs, to the rollups
+			m = CombineAggregates[*BlockWithReceipts](m, MakeInboxStats(ch.Config, ch.Inboxes))
+		}
+	default:
+		return AggregateMetric[*BlockWithReceipts]{}, false
+	}
+	if len(ch.Rollups) > 0 {
+		// the withdrawals of the rollups that are proven and finalized on this chain
+		m = CombineAggregates[*BlockWithReceipts](m, WithdrawalL1Metrics(ch.Rollups))
 	}
-	<-ctx.Done()
-	return sys.Close()
+	return m, true
 }
 
-func (sys *System) Close() error {
-	// TODO get hold of each chain db, and close
-	return nil
+// start follows and backfills all chains
+func start(ctx *cli.Context) error {
+	return runLive(ctx, true)
 }
 
-func (sys *System) chainMetrics(ctx context.Context, log log.Logger, ch *Chain, m AggregateMetric[*BlockWithReceipts]) {
+// live only follows the chains
+func live(ctx *cli.Context) error {
+	return runLive(ctx, false)
+}
 
-	// TODO determine buffer size
-	blocks := make(chan *types.Block, 100)
+func runLive(ctx *cli.Context, withBackfill bool) error {
+	logger, sys, err := setup(ctx, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if sys.Victoria == nil {
+		return errors.Join(errors.New("no victoria-metrics endpoint configured"), sys.Close())
+	}
+	err = sys.RunLive(ctx.Context, logger, withBackfill)
+	return errors.Join(err, sys.Close())
+}
 
-	// backfiller
-	go func() {
-		// TODO fetch latest
-		// traverse from min to latest
-		// if block exists with same hash: continue
-		// if block exists, but different hash, then remove overlapping metrics series
-		// if above, or if block does not exist, then get it
-		// pause after completing, then repeat
-	}()
+func backfill(ctx *cli.Context) error {
+	logger, sys, err := setup(ctx, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer sys.Close()
+	if sys.Victoria == nil {
+		return errors.New("no victoria-metrics endpoint configured")
+	}
 
-	// forward
-	go func() {
-		blockPollTicker := time.NewTicker(time.Second)
-		for {
-			select {
-			case <-blockPollTicker.C:
-				var bl *types.Block // get latest block
-				// traverse backwards until we reach a block we've seen already
-				// rate-limit the traversal
-			}
+	chains, err := sys.SelectChains(ctx.StringSlice(ChainFlag.Name))
+	if err != nil {
+		return err
+	}
+	backfills, err := newCSVBackfills(logger, sys.Victoria, chains)
+	if err != nil {
+		return err
+	}
+	endTime := ctx.Uint64(EndTimeFlag.Name)
+	var wg sync.WaitGroup
+	summaries := make([]*BackfillSummary, len(chains))
+	errs := make([]error, len(chains))
+	for i, bf := range backfills {
+		startTime := chains[i].MinTime
+		if ctx.IsSet(StartTimeFlag.Name) {
+			startTime = ctx.Uint64(StartTimeFlag.Name)
 		}
-	}()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			summaries[i], errs[i] = bf.Run(ctx.Context, startTime, endTime)
+		}(i)
+	}
+	wg.Wait()
 
-	// transform the blocks into processable blocks with receipts
-	go func() {
-		for {
-			select {
-			case <-blocks:
-				// TODO fetch receipts
-				//ch.EthCl.FetchReceipts()
-				// TODO send to chain buffer (has backpressure)
-				ch.Buffer <- &BlockWithReceipts{Block: nil, Receipts: nil}
-			case <-ctx.Done():
-				return
-			}
+	var result error
+	for i, s := range summaries {
+		logger.Info("backfill summary", "chain", s.Chain, "start", s.Start, "end", s.End,
+			"blocks", s.Blocks, "skipped", s.Skipped, "missing", s.Missing(),
+			"series", s.Series, "samples", s.Samples, "duration", s.Duration)
+		if errs[i] != nil {
+			result = errors.Join(result, fmt.Errorf("failed to backfill chain %s: %w", s.Chain, errs[i]))
 		}
-	}()
+	}
+	return result
+}
 
-	// consumer
-	go func() {
-		blockTime := func(b *BlockWithReceipts) int64 {
-			return int64(b.Block.Time())
+// newCSVBackfills returns the backfill of every chain.
+// All chains are checked before any backfill starts, so none is left running when one cannot be backfilled.
+func newCSVBackfills(log log.Logger, victoria *VictoriaClient, chains []*Chain) ([]*CSVBackfill, error) {
+	backfills := make([]*CSVBackfill, len(chains))
+	for i, ch := range chains {
+		m, ok := chainAggregateMetric(ch)
+		if !ok {
+			return nil, fmt.Errorf("cannot backfill chain %s, unhandled chain type %s", ch.Name, ch.Type)
+		}
+		backfills[i] = NewCSVBackfill(log.New("chain", ch.Name, "stage", "backfill"), ch, victoria, m)
+	}
+	return backfills, nil
+}
+
+// Close closes the RPC clients and databases of all chains.
+func (sys *System) Close() error {
+	var result error
+	for _, ch := range sys.Chains {
+		if ch.EthCl != nil {
+			ch.EthCl.Close() // also closes EthRPC
+		} else if ch.EthRPC != nil {
+			ch.EthRPC.Close()
+		}
+		if ch.OpRPC != nil {
+			ch.OpRPC.Close()
 		}
+		// the chain may not be fully opened, if the system failed to start
+		if ch.DB == nil {
+			continue
+		}
+		if err := ch.DB.Close(); err != nil {
+			result = errors.Join(result, fmt.Errorf("failed to close db of chain %s: %w", ch.Name, err))
+		}
+	}
+	return result
+}
 
-		flushTicker := time.NewTicker(time.Second)
-		for {
-			select {
-			case <-flushTicker.C:
-				var out bytes.Buffer
-				ExportJSONLines[*BlockWithReceipts](ctx, blockTime, m, &out, ch.Buffer)
-				// TODO write output to victoria metrics
+func dump(ctx *cli.Context) error {
+	// keep stdout clean for the CSV output
+	output := ctx.Path(OutputFlag.Name)
+	logOut := io.Writer(os.Stdout)
+	if output == "-" {
+		logOut = os.Stderr
+	}
+	logger, sys, err := setup(ctx, logOut)
+	if err != nil {
+		return err
+	}
+	defer sys.Close()
 
-				// TODO remember blocks that have been written
-				// never re-write blocks
+	chains, err := sys.SelectChains(ctx.StringSlice(ChainFlag.Name))
+	if err != nil {
+		return err
+	}
+	if len(chains) != 1 {
+		return fmt.Errorf("expected a single chain to dump, but got %d, select one with --%s", len(chains), ChainFlag.Name)
+	}
+	ch := chains[0]
+	m, ok := chainAggregateMetric(ch)
+	if !ok {
+		return fmt.Errorf("cannot dump chain %s, unhandled chain type %s", ch.Name, ch.Type)
+	}
+	startTime := ch.MinTime
+	if ctx.IsSet(StartTimeFlag.Name) {
+		startTime = ctx.Uint64(StartTimeFlag.Name)
+	}
 
-			case <-ctx.Done():
-				return
-			}
+	w := io.Writer(os.Stdout)
+	var f *os.File
+	if output != "-" {
+		if f, err = os.Create(output); err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
 		}
-	}()
+		w = f
+	}
+	d := NewCSVDump(logger.New("chain", ch.Name, "stage", "csv"), ch, m)
+	rows, err := d.Run(ctx.Context, w, startTime, ctx.Uint64(EndTimeFlag.Name))
+	if err != nil {
+		if f != nil {
+			_ = f.Close()
+		}
+		return fmt.Errorf("failed to dump chain %s: %w", ch.Name, err)
+	}
+	// the data may only be written out when the file is closed, e.g. on a full disk
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to close output file: %w", err)
+		}
+	}
+	logger.Info("wrote csv", "chain", ch.Name, "rows", rows, "series", len(m.Names), "output", output)
+	return nil
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"strings"
+	"testing"
+)
+
+func TestNewCSVBackfills(t *testing.T) {
+	eth := &Chain{Name: "l1", Type: EthereumChain, Config: params.MainnetChainConfig, Inboxes: newInboxes(nil)}
+	other := &Chain{Name: "other", Type: ChainType("other")}
+	tests := []struct {
+		name    string
+		chains  []*Chain
+		wantErr string
+	}{
+		{name: "handled", chains: []*Chain{eth}},
+		{name: "unhandled first", chains: []*Chain{other, eth}, wantErr: "cannot backfill chain other"},
+		{name: "unhandled last", chains: []*Chain{eth, other}, wantErr: "cannot backfill chain other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backfills, err := newCSVBackfills(log.NewLogger(log.DiscardHandler()), nil, tt.chains)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				// no backfill is returned to run, not even of the chains before the unhandled one
+				if backfills != nil {
+					t.Errorf("got %d backfills", len(backfills))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backfills) != len(tt.chains) {
+				t.Errorf("got %d backfills, want %d", len(backfills), len(tt.chains))
+			}
+		})
+	}
+}
@@ -54,9 +54,9 @@ func Histogram[E any](name string, bounds []float64, fn func(elem E, add func(v
 	n := len(bounds) + 1
 	names := make([]string, n, n)
 	for i := 0; i < n; i++ {
-		names[i] = fmt.Sprintf("_bucket")
+		names[i] = name + "_bucket"
 	}
-	names = append(names, "_sum", "_count")
+	names = append(names, name+"_sum", name+"_count")
 	sumIndex := n
 	countIndex := n + 1
 
@@ -88,6 +88,10 @@ func Histogram[E any](name string, bounds []float64, fn func(elem E, add func(v
 func Aggregate[E any](metrics ...Metric[E]) AggregateMetric[E] {
 	names := make([]string, 0, len(metrics))
 	labels := make([][]Label, 0, len(metrics))
+	for _, m := range metrics {
+		names = append(names, m.Name)
+		labels = append(labels, m.Labels)
+	}
 	fn := func(elem E, dest []float64) error {
 		var err error
 		for i, m := range metrics {
@@ -164,3 +168,16 @@ func TransformAggregate[A, B any](conv func(B) A, agg AggregateMetric[A]) Aggreg
 		},
 	}
 }
+
+// LabelAggregate adds the label to all the series of the aggregate, to distinguish aggregates of the same metrics.
+func LabelAggregate[E any](agg AggregateMetric[E], label Label) AggregateMetric[E] {
+	labels := make([][]Label, len(agg.Labels))
+	for i, ls := range agg.Labels {
+		labels[i] = append(append(make([]Label, 0, len(ls)+1), ls...), label)
+	}
+	return AggregateMetric[E]{
+		Names:  agg.Names,
+		Labels: labels,
+		Fn:     agg.Fn,
+	}
+}
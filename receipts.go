@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-service/sources"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"time"
+)
+
+const (
+	// number of blocks that may have their receipts fetched at the same time
+	receiptsConcurrency = 10
+	// receipts of blocks that were just reorged out never become available, so we don't retry forever
+	receiptsMaxAttempts = 5
+	receiptsRetryDelay  = time.Second
+)
+
+// ReceiptsSource provides the receipts of a block.
+type ReceiptsSource interface {
+	FetchReceipts(ctx context.Context, bl *types.Block) (types.Receipts, error)
+}
+
+// HeaderSource provides the canonical headers of a chain.
+type HeaderSource interface {
+	// HeaderByNumber returns the header of the canonical block with the given number,
+	// or ethereum.NotFound if there is none.
+	HeaderByNumber(ctx context.Context, num uint64) (*types.Header, error)
+}
+
+// RPCReceiptsSource fetches receipts through the op-node EthClient,
+// which picks debug_getRawReceipts or other optimized methods when the RPC supports them.
+type RPCReceiptsSource struct {
+	cl     *sources.EthClient
+	config *params.ChainConfig
+}
+
+func NewRPCReceiptsSource(cl *sources.EthClient, config *params.ChainConfig) *RPCReceiptsSource {
+	return &RPCReceiptsSource{cl: cl, config: config}
+}
+
+var _ ReceiptsSource = (*RPCReceiptsSource)(nil)
+
+func (s *RPCReceiptsSource) FetchReceipts(ctx context.Context, bl *types.Block) (types.Receipts, error) {
+	_, opReceipts, err := s.cl.FetchReceipts(ctx, bl.Hash())
+	if err != nil {
+		return nil, err
+	}
+	receipts := opReceipts.Geth()
+	txs := bl.Transactions()
+	if len(receipts) != len(txs) {
+		return nil, fmt.Errorf("got %d receipts but block %d has %d transactions", len(receipts), bl.NumberU64(), len(txs))
+	}
+	for i, rec := range receipts {
+		if rec.TxHash != txs[i].Hash() {
+			return nil, fmt.Errorf("receipt %d is for tx %s, but expected tx %s", i, rec.TxHash, txs[i].Hash())
+		}
+	}
+	// Raw receipts do not come with the non-consensus fields (effective gas price, L1 fee data) that metrics use
+	if len(receipts) > 0 && receipts[0].EffectiveGasPrice == nil {
+		if err := receipts.DeriveFields(s.config, bl.Hash(), bl.NumberU64(), bl.Time(), bl.BaseFee(), blobGasPrice(s.config, bl.Header()), txs); err != nil {
+			return nil, fmt.Errorf("failed to derive receipt fields of block %d: %w", bl.NumberU64(), err)
+		}
+	}
+	return receipts, nil
+}
+
+// ReceiptsStage turns blocks into blocks with receipts.
+// Receipts are fetched concurrently, but the blocks are output in the same order as they came in.
+type ReceiptsStage struct {
+	log log.Logger
+	src ReceiptsSource
+	// checks whether a block of which the receipts cannot be fetched is still canonical
+	canonical  HeaderSource
+	retryDelay time.Duration
+}
+
+func NewReceiptsStage(log log.Logger, src ReceiptsSource, canonical HeaderSource) *ReceiptsStage {
+	return &ReceiptsStage{log: log, src: src, canonical: canonical, retryDelay: receiptsRetryDelay}
+}
+
+type receiptsResult struct {
+	// nil if the block was skipped
+	blr *BlockWithReceipts
+	err error
+}
+
+// Run processes the blocks from in, and sends them with receipts to out, until the context is canceled or in is closed.
+// Blocks that were reorged out before their receipts were fetched are skipped,
+// Run fails when the receipts of a canonical block cannot be fetched.
+func (s *ReceiptsStage) Run(ctx context.Context, in <-chan *types.Block, out chan<- *BlockWithReceipts) error {
+	// stop the fetches in progress when Run returns early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The capacity of the pending queue bounds how many fetches are in progress.
+	pending := make(chan chan receiptsResult, receiptsConcurrency)
+	go func() {
+		defer close(pending)
+		for {
+			select {
+			case bl, ok := <-in:
+				if !ok {
+					return
+				}
+				res := make(chan receiptsResult, 1)
+				select {
+				case pending <- res:
+				case <-ctx.Done():
+					return
+				}
+				go func() {
+					res <- s.fetch(ctx, bl)
+				}()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for res := range pending {
+		var r receiptsResult
+		select {
+		case r = <-res:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if r.err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return r.err
+		}
+		if r.blr == nil {
+			continue
+		}
+		select {
+		case out <- r.blr:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *ReceiptsStage) fetch(ctx context.Context, bl *types.Block) receiptsResult {
+	var err error
+	for i := 0; i < receiptsMaxAttempts; i++ {
+		var receipts types.Receipts
+		receipts, err = s.src.FetchReceipts(ctx, bl)
+		if err == nil {
+			return receiptsResult{blr: &BlockWithReceipts{Block: bl, Receipts: receipts}}
+		}
+		s.log.Warn("failed to fetch receipts", "number", bl.NumberU64(), "hash", bl.Hash(), "attempt", i, "err", err)
+		select {
+		case <-time.After(s.retryDelay):
+		case <-ctx.Done():
+			return receiptsResult{err: ctx.Err()}
+		}
+	}
+	// the receipts of a block that was reorged out are not available by its hash anymore
+	h, cerr := s.canonical.HeaderByNumber(ctx, bl.NumberU64())
+	if errors.Is(cerr, ethereum.NotFound) || (cerr == nil && h.Hash() != bl.Hash()) {
+		s.log.Warn("skipping block that is no longer canonical", "number", bl.NumberU64(), "hash", bl.Hash())
+		return receiptsResult{}
+	}
+	if cerr != nil {
+		return receiptsResult{err: fmt.Errorf("block %d (%s): %w, and failed to check if it is canonical: %w", bl.NumberU64(), bl.Hash(), err, cerr)}
+	}
+	return receiptsResult{err: fmt.Errorf("block %d (%s): %w", bl.NumberU64(), bl.Hash(), err)}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyReceiptsSource fails to fetch the receipts of a block a number of times before it succeeds
+type flakyReceiptsSource struct {
+	mu       sync.Mutex
+	failures map[uint64]int
+}
+
+func (s *flakyReceiptsSource) FetchReceipts(ctx context.Context, bl *types.Block) (types.Receipts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures[bl.NumberU64()] > 0 {
+		s.failures[bl.NumberU64()] -= 1
+		return nil, errors.New("unavailable")
+	}
+	return types.Receipts{}, nil
+}
+
+// reorgedHeaderSource is a canonical chain of which some blocks were replaced, and that may be shorter than the input
+type reorgedHeaderSource struct {
+	replaced map[uint64]bool
+	// number of canonical blocks, all if 0
+	length uint64
+}
+
+func (s *reorgedHeaderSource) HeaderByNumber(ctx context.Context, num uint64) (*types.Header, error) {
+	if s.length > 0 && num >= s.length {
+		return nil, ethereum.NotFound
+	}
+	h := &types.Header{Number: new(big.Int).SetUint64(num)}
+	if s.replaced[num] {
+		h.Extra = []byte("replacement")
+	}
+	return h, nil
+}
+
+func TestReceiptsStage(t *testing.T) {
+	tests := []struct {
+		name      string
+		blocks    uint64
+		failures  map[uint64]int
+		canonical reorgedHeaderSource
+		// blocks that are output, in order
+		want    []uint64
+		wantErr string
+	}{
+		{name: "in order", blocks: 50, want: numbers(0, 50)},
+		{name: "retried", blocks: 20, failures: map[uint64]int{3: receiptsMaxAttempts - 1}, want: numbers(0, 20)},
+		{name: "failed", blocks: 20, failures: map[uint64]int{3: receiptsMaxAttempts}, want: numbers(0, 3), wantErr: "block 3 "},
+		{name: "reorged out", blocks: 20, failures: map[uint64]int{3: receiptsMaxAttempts},
+			canonical: reorgedHeaderSource{replaced: map[uint64]bool{3: true}}, want: append(numbers(0, 3), numbers(4, 20)...)},
+		{name: "reorged to a shorter chain", blocks: 20, failures: map[uint64]int{19: receiptsMaxAttempts},
+			canonical: reorgedHeaderSource{length: 19}, want: numbers(0, 19)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &flakyReceiptsSource{failures: make(map[uint64]int)}
+			for num, n := range tt.failures {
+				src.failures[num] = n
+			}
+			stage := NewReceiptsStage(log.NewLogger(log.DiscardHandler()), src, &tt.canonical)
+			stage.retryDelay = time.Millisecond
+
+			in := make(chan *types.Block, tt.blocks)
+			for i := uint64(0); i < tt.blocks; i++ {
+				in <- types.NewBlockWithHeader(&types.Header{Number: new(big.Int).SetUint64(i)})
+			}
+			close(in)
+			out := make(chan *BlockWithReceipts, tt.blocks)
+			err := stage.Run(context.Background(), in, out)
+			close(out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint64
+			for bl := range out {
+				got = append(got, bl.Block.NumberU64())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got blocks %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
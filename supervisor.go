@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Supervisor runs a group of tasks, similar to an errgroup.
+// The first task that fails cancels the context of all tasks, and is the error returned by Wait.
+// Tasks that stop because of a canceled context are not considered to have failed.
+type Supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+func NewSupervisor(ctx context.Context) *Supervisor {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Supervisor{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the context that is passed to the tasks.
+func (s *Supervisor) Context() context.Context {
+	return s.ctx
+}
+
+// Go runs the named task in a new goroutine.
+func (s *Supervisor) Go(name string, fn func(ctx context.Context) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := fn(s.ctx); err != nil && !errors.Is(err, context.Canceled) {
+			s.errOnce.Do(func() {
+				s.err = fmt.Errorf("%s failed: %w", name, err)
+				s.cancel()
+			})
+		}
+	}()
+}
+
+// Stop cancels the context of all tasks.
+func (s *Supervisor) Stop() {
+	s.cancel()
+}
+
+// Wait waits for all tasks to stop, and returns the first failure, if any.
+func (s *Supervisor) Wait() error {
+	s.wg.Wait()
+	s.cancel()
+	return s.err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/log"
+	"time"
+)
+
+// how often the sync status of the rollup node is polled
+const syncStatusPollInterval = 10 * time.Second
+
+// SyncStatusPoller periodically exports the sync status of the rollup node of an OP Stack chain:
+// the L2 heads, the L1 blocks that the node sees and derives from, and the lags between these.
+// The series are written with the time of polling, without the metrics-hour label, like the reorg metrics.
+type SyncStatusPoller struct {
+	log      log.Logger
+	ch       *Chain
+	victoria *VictoriaClient
+}
+
+func NewSyncStatusPoller(log log.Logger, ch *Chain, victoria *VictoriaClient) *SyncStatusPoller {
+	return &SyncStatusPoller{log: log, ch: ch, victoria: victoria}
+}
+
+// Run polls the sync status until the context is canceled.
+func (p *SyncStatusPoller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(syncStatusPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+			p.log.Warn("failed to export sync status", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *SyncStatusPoller) poll(ctx context.Context) error {
+	st, err := p.ch.OpCl.SyncStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get sync status: %w", err)
+	}
+	now := time.Now()
+	var out []byte
+	labels := map[string]string{ChainLabel: p.ch.Name}
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{
+		{"rollup_unsafe_l2", float64(st.UnsafeL2.Number)},
+		{"rollup_safe_l2", float64(st.SafeL2.Number)},
+		{"rollup_finalized_l2", float64(st.FinalizedL2.Number)},
+		{"rollup_current_l1", float64(st.CurrentL1.Number)},
+		{"rollup_head_l1", float64(st.HeadL1.Number)},
+		{"rollup_safe_l1", float64(st.SafeL1.Number)},
+		{"rollup_finalized_l1", float64(st.FinalizedL1.Number)},
+		// the L2 blocks that are not safe or final yet: the batcher and derivation lag behind the sequencer
+		{"rollup_safe_lag_blocks", float64(st.UnsafeL2.Number) - float64(st.SafeL2.Number)},
+		{"rollup_safe_lag_seconds", float64(st.UnsafeL2.Time) - float64(st.SafeL2.Time)},
+		{"rollup_finalized_lag_blocks", float64(st.UnsafeL2.Number) - float64(st.FinalizedL2.Number)},
+		{"rollup_finalized_lag_seconds", float64(st.UnsafeL2.Time) - float64(st.FinalizedL2.Time)},
+		// the L1 blocks that the derivation did not process yet
+		{"rollup_derivation_lag_blocks", float64(st.HeadL1.Number) - float64(st.CurrentL1.Number)},
+		{"rollup_derivation_lag_seconds", float64(st.HeadL1.Time) - float64(st.CurrentL1.Time)},
+		// how long ago the sequencer produced the latest block
+		{"rollup_unsafe_lag_seconds", float64(now.Unix()) - float64(st.UnsafeL2.Time)},
+	} {
+		out, err = appendImportLine(out, m.name, labels, m.value, now.UnixMilli())
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.victoria.Import(ctx, out); err != nil {
+		return fmt.Errorf("failed to export sync status: %w", err)
+	}
+	return nil
+}
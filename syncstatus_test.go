@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/ethereum-optimism/optimism/op-service/eth"
+	"github.com/ethereum-optimism/optimism/op-service/sources"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// syncStatusRPC answers the sync status requests of a rollup client
+type syncStatusRPC struct {
+	status *eth.SyncStatus
+}
+
+func (s *syncStatusRPC) Close() {}
+
+func (s *syncStatusRPC) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	panic("not supported")
+}
+
+func (s *syncStatusRPC) Subscribe(ctx context.Context, namespace string, channel any, args ...any) (ethereum.Subscription, error) {
+	panic("not supported")
+}
+
+func (s *syncStatusRPC) CallContext(ctx context.Context, result any, method string, args ...any) error {
+	if method != "optimism_syncStatus" {
+		panic("not supported: " + method)
+	}
+	data, err := json.Marshal(s.status)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func TestSyncStatusPoller(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	status := &eth.SyncStatus{
+		CurrentL1:   eth.L1BlockRef{Number: 990, Time: now - 120},
+		HeadL1:      eth.L1BlockRef{Number: 1000, Time: now},
+		SafeL1:      eth.L1BlockRef{Number: 980, Time: now - 240},
+		FinalizedL1: eth.L1BlockRef{Number: 940, Time: now - 720},
+		UnsafeL2:    eth.L2BlockRef{Number: 5000, Time: now - 4},
+		SafeL2:      eth.L2BlockRef{Number: 4700, Time: now - 604},
+		FinalizedL2: eth.L2BlockRef{Number: 4000, Time: now - 2004},
+	}
+	got := make(map[string]float64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("failed to decompress body: %v", err)
+			return
+		}
+		data, err := io.ReadAll(gr)
+		if err != nil {
+			t.Errorf("failed to read body: %v", err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var line struct {
+				Metric map[string]string `json:"metric"`
+				Values []float64         `json:"values"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Errorf("failed to decode line: %v", err)
+			}
+			if line.Metric[ChainLabel] != "test" || line.Metric[MetricsHourLabel] != "" || len(line.Values) != 1 {
+				t.Errorf("got series %v %v", line.Metric, line.Values)
+			}
+			got[line.Metric["__name__"]] = line.Values[0]
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	logger := log.NewLogger(log.DiscardHandler())
+	victoria, err := NewVictoriaClient(logger, &DBConfig{Victoria: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := &Chain{Name: "test", OpCl: sources.NewRollupClient(&syncStatusRPC{status: status})}
+	if err := NewSyncStatusPoller(logger, ch, victoria).poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{
+		"rollup_unsafe_l2":              5000,
+		"rollup_safe_l2":                4700,
+		"rollup_finalized_l2":           4000,
+		"rollup_current_l1":             990,
+		"rollup_head_l1":                1000,
+		"rollup_safe_l1":                980,
+		"rollup_finalized_l1":           940,
+		"rollup_safe_lag_blocks":        300,
+		"rollup_safe_lag_seconds":       600,
+		"rollup_finalized_lag_blocks":   1000,
+		"rollup_finalized_lag_seconds":  2000,
+		"rollup_derivation_lag_blocks":  10,
+		"rollup_derivation_lag_seconds": 120,
+	}
+	for name, value := range want {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("got %s %v, want %v", name, v, value)
+		}
+	}
+	// the age of the unsafe head depends on the time of polling
+	if v := got["rollup_unsafe_lag_seconds"]; v < 4 || v > 10 {
+		t.Errorf("got rollup_unsafe_lag_seconds %v, want about 4", v)
+	}
+	if len(got) != len(want)+1 {
+		t.Errorf("got %d series, want %d", len(got), len(want)+1)
+	}
+}
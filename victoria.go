@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-service/retry"
+	"github.com/ethereum/go-ethereum/log"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	victoriaMaxAttempts    = 5
+	victoriaRequestTimeout = time.Minute
+)
+
+// VictoriaClient writes to the VictoriaMetrics HTTP API.
+// Requests that fail with a connection error or a 5xx status are retried with backoff,
+// requests that the remote rejects are not.
+type VictoriaClient struct {
+	log      log.Logger
+	endpoint string
+	cfg      *DBConfig
+
+	httpClient *http.Client
+	strategy   retry.Strategy
+}
+
+// VictoriaRejectedErr is returned when VictoriaMetrics refused the request.
+type VictoriaRejectedErr struct {
+	Status int
+	Body   string
+}
+
+func (e *VictoriaRejectedErr) Error() string {
+	return fmt.Sprintf("victoria-metrics rejected request with status %d: %s", e.Status, e.Body)
+}
+
+func NewVictoriaClient(log log.Logger, cfg *DBConfig) (*VictoriaClient, error) {
+	if cfg.Victoria == "" {
+		return nil, errors.New("no victoria-metrics endpoint configured")
+	}
+	if _, err := url.Parse(cfg.Victoria); err != nil {
+		return nil, fmt.Errorf("invalid victoria-metrics endpoint: %w", err)
+	}
+	if cfg.BearerToken != "" && cfg.Username != "" {
+		return nil, errors.New("cannot use both bearer token and basic auth for victoria-metrics")
+	}
+	return &VictoriaClient{
+		log:        log,
+		endpoint:   strings.TrimSuffix(cfg.Victoria, "/"),
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: victoriaRequestTimeout},
+		strategy:   retry.Exponential(),
+	}, nil
+}
+
+// Import writes JSON-lines formatted metrics, as produced by ExportJSONLines, to VictoriaMetrics.
+func (c *VictoriaClient) Import(ctx context.Context, data []byte) error {
+	return c.post(ctx, "/api/v1/import", nil, data)
+}
+
+// ImportCSV writes CSV formatted metrics to VictoriaMetrics, with the given column format.
+// See https://docs.victoriametrics.com/#how-to-import-csv-data
+func (c *VictoriaClient) ImportCSV(ctx context.Context, format string, data []byte) error {
+	return c.post(ctx, "/api/v1/import/csv", url.Values{"format": {format}}, data)
+}
+
+// DeleteSeries deletes all series that match the given series selector.
+// See https://docs.victoriametrics.com/url-examples.html#apiv1admintsdbdelete_series
+func (c *VictoriaClient) DeleteSeries(ctx context.Context, match string) error {
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/admin/tsdb/delete_series", url.Values{"match[]": {match}}, nil, "")
+	return err
+}
+
+// Export reads back all series that match the given series selector, in the JSON-lines format.
+func (c *VictoriaClient) Export(ctx context.Context, match string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, "/api/v1/export", url.Values{"match[]": {match}}, nil, "")
+}
+
+// LabelValues returns the values of the given label, in the series that match the given series selector.
+func (c *VictoriaClient) LabelValues(ctx context.Context, label string, match string) ([]string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/v1/label/"+url.PathEscape(label)+"/values", url.Values{"match[]": {match}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Status string   `json:"status"`
+		Data   []string `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode label values: %w", err)
+	}
+	if out.Status != "success" {
+		return nil, fmt.Errorf("unexpected label values response status: %q", out.Status)
+	}
+	return out.Data, nil
+}
+
+// ImportWriter returns a writer that imports each written chunk into VictoriaMetrics.
+// ExportJSONLines writes every flushed batch with a single Write call, so every request is a complete batch.
+func (c *VictoriaClient) ImportWriter(ctx context.Context) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		if err := c.Import(ctx, p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	})
+}
+
+// appendImportLine appends a single sample of a series, in the JSON-lines format of Import.
+// t is a unix timestamp in milliseconds.
+func appendImportLine(out []byte, name string, labels map[string]string, value float64, t int64) ([]byte, error) {
+	metric := map[string]string{"__name__": name}
+	for k, v := range labels {
+		metric[k] = v
+	}
+	line, err := json.Marshal(map[string]any{
+		"metric":     metric,
+		"values":     []float64{value},
+		"timestamps": []int64{t},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return append(append(out, line...), '\n'), nil
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (fn writerFunc) Write(p []byte) (int, error) {
+	return fn(p)
+}
+
+func (c *VictoriaClient) post(ctx context.Context, path string, query url.Values, data []byte) error {
+	var body bytes.Buffer
+	gw := gzip.NewWriter(&body)
+	if _, err := gw.Write(data); err != nil {
+		return fmt.Errorf("failed to compress request: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to compress request: %w", err)
+	}
+	_, err := c.do(ctx, http.MethodPost, path, query, body.Bytes(), "gzip")
+	return err
+}
+
+// do runs the request, with retries, and returns the response body
+func (c *VictoriaClient) do(ctx context.Context, method string, path string, query url.Values, body []byte, encoding string) ([]byte, error) {
+	u := c.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var lastErr error
+	for attempt := 0; attempt < victoriaMaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.strategy.Duration(attempt - 1)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		resp, err := c.doOnce(ctx, method, u, body, encoding)
+		if err == nil {
+			return resp, nil
+		}
+		var rejected *VictoriaRejectedErr
+		if errors.As(err, &rejected) || ctx.Err() != nil {
+			return nil, err
+		}
+		c.log.Warn("victoria-metrics request failed", "method", method, "path", path, "attempt", attempt, "err", err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("victoria-metrics request %s %s failed after %d attempts: %w", method, path, victoriaMaxAttempts, lastErr)
+}
+
+func (c *VictoriaClient) doOnce(ctx context.Context, method string, u string, body []byte, encoding string) ([]byte, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if c.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.BearerToken)
+	} else if c.cfg.Username != "" {
+		req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("server error %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if resp.StatusCode >= 300 {
+		return nil, &VictoriaRejectedErr{Status: resp.StatusCode, Body: strings.TrimSpace(string(respBody))}
+	}
+	return respBody, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/ethereum-optimism/optimism/op-service/retry"
+	"github.com/ethereum/go-ethereum/log"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVictoriaClientImport(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  DBConfig
+		// status codes of the responses, in order, the last one repeats
+		statuses []int
+		// whether the server drops the connection instead of responding, for the first attempts
+		drops int
+		// the expected Authorization header
+		wantAuth     string
+		wantAttempts int
+		// whether the request is expected to fail with a VictoriaRejectedErr, or with another error
+		wantRejected bool
+		wantErr      bool
+	}{
+		{name: "success", statuses: []int{http.StatusNoContent}, wantAttempts: 1},
+		{name: "bearer token", cfg: DBConfig{BearerToken: "secret"}, statuses: []int{http.StatusNoContent}, wantAuth: "Bearer secret", wantAttempts: 1},
+		{name: "basic auth", cfg: DBConfig{Username: "user", Password: "pass"}, statuses: []int{http.StatusNoContent}, wantAuth: "Basic dXNlcjpwYXNz", wantAttempts: 1},
+		{name: "server error retried", statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusNoContent}, wantAttempts: 3},
+		{name: "connection error retried", drops: 2, statuses: []int{http.StatusNoContent}, wantAttempts: 3},
+		{name: "server errors exhaust the attempts", statuses: []int{http.StatusInternalServerError}, wantAttempts: victoriaMaxAttempts, wantErr: true},
+		{name: "rejected", statuses: []int{http.StatusBadRequest}, wantAttempts: 1, wantRejected: true},
+		{name: "unauthorized", cfg: DBConfig{BearerToken: "wrong"}, statuses: []int{http.StatusUnauthorized}, wantAuth: "Bearer wrong", wantAttempts: 1, wantRejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempt := attempts
+				attempts++
+				mu.Unlock()
+				if attempt < tt.drops {
+					hj, ok := w.(http.Hijacker)
+					if !ok {
+						t.Error("cannot drop connection")
+						return
+					}
+					conn, _, err := hj.Hijack()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					conn.Close()
+					return
+				}
+				if r.URL.Path != "/api/v1/import" || r.Header.Get("Content-Encoding") != "gzip" {
+					t.Errorf("unexpected request %s with encoding %q", r.URL.Path, r.Header.Get("Content-Encoding"))
+				}
+				if got := r.Header.Get("Authorization"); got != tt.wantAuth {
+					t.Errorf("got authorization %q, want %q", got, tt.wantAuth)
+				}
+				gr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					t.Errorf("failed to decompress body: %v", err)
+				} else if body, err := io.ReadAll(gr); err != nil || string(body) != "data\n" {
+					t.Errorf("got body %q (%v)", body, err)
+				}
+				status := tt.statuses[min(attempt-tt.drops, len(tt.statuses)-1)]
+				w.WriteHeader(status)
+				if status >= 300 {
+					_, _ = w.Write([]byte("error message\n"))
+				}
+			}))
+			defer srv.Close()
+
+			cfg := tt.cfg
+			cfg.Victoria = srv.URL + "/"
+			c, err := NewVictoriaClient(log.NewLogger(log.DiscardHandler()), &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.strategy = retry.Fixed(time.Millisecond)
+			err = c.Import(context.Background(), []byte("data\n"))
+
+			var rejected *VictoriaRejectedErr
+			if isRejected := errors.As(err, &rejected); isRejected != tt.wantRejected {
+				t.Errorf("got error %v, want rejected %v", err, tt.wantRejected)
+			} else if isRejected && (rejected.Status != tt.statuses[0] || rejected.Body != "error message") {
+				t.Errorf("got rejection %+v", rejected)
+			}
+			if (err != nil && !tt.wantRejected) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestNewVictoriaClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     DBConfig
+		wantErr string
+	}{
+		{name: "valid", cfg: DBConfig{Victoria: "http://localhost:8428"}},
+		{name: "no endpoint", wantErr: "no victoria-metrics endpoint"},
+		{name: "invalid endpoint", cfg: DBConfig{Victoria: "http://local host:%zz"}, wantErr: "invalid victoria-metrics endpoint"},
+		{name: "both auth methods", cfg: DBConfig{Victoria: "http://localhost:8428", Username: "user", BearerToken: "secret"}, wantErr: "cannot use both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVictoriaClient(log.NewLogger(log.DiscardHandler()), &tt.cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
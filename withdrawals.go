@@ -0,0 +1,482 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum-optimism/optimism/op-core/predeploys"
+	"github.com/ethereum-optimism/optimism/op-node/bindings"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"math"
+	"math/big"
+	"sync"
+)
+
+func mustABI(md *bind.MetaData) *abi.ABI {
+	out, err := md.GetAbi()
+	if err != nil {
+		panic(fmt.Errorf("invalid built-in ABI: %w", err))
+	}
+	return out
+}
+
+var (
+	messagePasserABI = mustABI(bindings.L2ToL1MessagePasserMetaData)
+	portalABI        = mustABI(bindings.OptimismPortalMetaData)
+
+	messagePassedTopic       = messagePasserABI.Events["MessagePassed"].ID
+	withdrawalProvenTopic    = portalABI.Events["WithdrawalProven"].ID
+	withdrawalFinalizedTopic = portalABI.Events["WithdrawalFinalized"].ID
+
+	// the filterers only decode logs, the addresses are checked before
+	messagePasserFilterer, _ = bindings.NewL2ToL1MessagePasserFilterer(predeploys.L2ToL1MessagePasserAddr, nil)
+	portalFilterer, _        = bindings.NewOptimismPortalFilterer(common.Address{}, nil)
+)
+
+// sides of a withdrawal index: the OP Stack chain initiates the withdrawals, its L1 chain proves and finalizes them
+const (
+	withdrawalsL2 = "l2"
+	withdrawalsL1 = "l1"
+)
+
+// withdrawalEvent is a withdrawal that reached a stage in a block.
+type withdrawalEvent struct {
+	hash  common.Hash
+	stage string
+	// withdrawn ETH of initiations
+	value *big.Int
+	// whether the L1 call of a finalization succeeded
+	success bool
+}
+
+// messagePassedEvents decodes the withdrawal initiations of the MessagePassed events of the L2ToL1MessagePasser.
+func messagePassedEvents(receipts types.Receipts) ([]withdrawalEvent, error) {
+	var out []withdrawalEvent
+	for _, rec := range receipts {
+		for _, l := range rec.Logs {
+			if l.Address != predeploys.L2ToL1MessagePasserAddr || len(l.Topics) == 0 || l.Topics[0] != messagePassedTopic {
+				continue
+			}
+			ev, err := messagePasserFilterer.ParseMessagePassed(*l)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode MessagePassed event of tx %s: %w", l.TxHash, err)
+			}
+			out = append(out, withdrawalEvent{hash: ev.WithdrawalHash, stage: "initiated", value: ev.Value})
+		}
+	}
+	return out, nil
+}
+
+// portalEvents decodes the proofs and finalizations of the WithdrawalProven and WithdrawalFinalized events
+// of the OptimismPortal.
+func portalEvents(portal common.Address, receipts types.Receipts) ([]withdrawalEvent, error) {
+	var out []withdrawalEvent
+	for _, rec := range receipts {
+		for _, l := range rec.Logs {
+			if l.Address != portal || len(l.Topics) == 0 {
+				continue
+			}
+			switch l.Topics[0] {
+			case withdrawalProvenTopic:
+				ev, err := portalFilterer.ParseWithdrawalProven(*l)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode WithdrawalProven event of tx %s: %w", l.TxHash, err)
+				}
+				out = append(out, withdrawalEvent{hash: ev.WithdrawalHash, stage: "proven"})
+			case withdrawalFinalizedTopic:
+				ev, err := portalFilterer.ParseWithdrawalFinalized(*l)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode WithdrawalFinalized event of tx %s: %w", l.TxHash, err)
+				}
+				out = append(out, withdrawalEvent{hash: ev.WithdrawalHash, stage: "finalized", success: ev.Success})
+			}
+		}
+	}
+	return out, nil
+}
+
+// WithdrawalIndex is the persistent index of the withdrawals of an OP Stack chain, in the database of the chain,
+// to measure the time between the stages of a withdrawal across restarts.
+// The exported blocks of the L2 chain record the initiations, those of the L1 chain the proofs and finalizations.
+// Every block that changes the index keeps a journal of the previous records, by block number and hash,
+// so that its changes can be reverted when the block is reorged out, and are not applied twice.
+// The metrics only read the index, as it was before the block: exporting a block again gives the same metrics.
+type WithdrawalIndex struct {
+	db *ChainDB
+	// the pipelines of the L2 and L1 chain update the records concurrently
+	mu sync.Mutex
+}
+
+func NewWithdrawalIndex(db *ChainDB) *WithdrawalIndex {
+	return &WithdrawalIndex{db: db}
+}
+
+// Lookup returns the record of the withdrawal as it was before the block of the given side was indexed,
+// or nil if there was none.
+func (w *WithdrawalIndex) Lookup(side string, num uint64, block common.Hash, hash common.Hash) (*WithdrawalRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	j, err := w.db.WithdrawalJournal(side, num, block)
+	if err != nil {
+		return nil, err
+	}
+	if j != nil {
+		if prev, ok := j.Prev[hash]; ok {
+			return prev, nil
+		}
+	}
+	return w.db.Withdrawal(hash)
+}
+
+// Index records the withdrawal events of the block of the given side, unless the block was indexed already.
+// A withdrawal may be proven again, which restarts the finalization period, so the latest proof is kept.
+func (w *WithdrawalIndex) Index(side string, num uint64, block common.Hash, time uint64, events []withdrawalEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if j, err := w.db.WithdrawalJournal(side, num, block); err != nil || j != nil {
+		return err
+	}
+	j := &WithdrawalJournal{Num: num, Block: block, Prev: make(map[common.Hash]*WithdrawalRecord)}
+	records := make(map[common.Hash]*WithdrawalRecord)
+	for _, ev := range events {
+		rec, ok := records[ev.hash]
+		if !ok {
+			prev, err := w.db.Withdrawal(ev.hash)
+			if err != nil {
+				return err
+			}
+			j.Prev[ev.hash] = prev
+			rec = &WithdrawalRecord{}
+			if prev != nil {
+				*rec = *prev
+			}
+			records[ev.hash] = rec
+		}
+		switch ev.stage {
+		case "initiated":
+			if rec.Initiated == 0 {
+				rec.Initiated = time
+				rec.Value = (*hexutil.Big)(ev.value)
+			}
+		case "proven":
+			if rec.Finalized == 0 {
+				rec.Proven = time
+			}
+		case "finalized":
+			rec.Finalized = time
+		}
+	}
+	return w.db.PutWithdrawals(side, j, records)
+}
+
+// Revert undoes the changes of the indexed blocks of the given side from the given number, latest first.
+// Only the fields that the side sets are restored, the other side may have changed the records since.
+func (w *WithdrawalIndex) Revert(side string, from uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	journals, err := w.db.WithdrawalJournals(side, from, math.MaxUint64)
+	if err != nil {
+		return err
+	}
+	for i := len(journals) - 1; i >= 0; i-- {
+		records := make(map[common.Hash]*WithdrawalRecord)
+		for hash, prev := range journals[i].Prev {
+			rec, err := w.db.Withdrawal(hash)
+			if err != nil {
+				return err
+			}
+			if rec == nil {
+				continue // completed withdrawals are pruned, and cannot be reverted anymore
+			}
+			if prev == nil {
+				prev = &WithdrawalRecord{}
+			}
+			if side == withdrawalsL2 {
+				rec.Initiated, rec.Value = prev.Initiated, prev.Value
+			} else {
+				rec.Proven, rec.Finalized = prev.Proven, prev.Finalized
+			}
+			if *rec == (WithdrawalRecord{}) {
+				rec = nil
+			}
+			records[hash] = rec
+		}
+		if err := w.db.RemoveWithdrawalJournal(side, journals[i], records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes the journals of the given side of the blocks before the given number, which are final,
+// and the records of the withdrawals that are both initiated and finalized.
+// Withdrawals that are never finalized, or that were initiated before the index was populated, stay in the index.
+func (w *WithdrawalIndex) Prune(side string, before uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	journals, err := w.db.WithdrawalJournals(side, 0, before)
+	if err != nil {
+		return err
+	}
+	for _, j := range journals {
+		records := make(map[common.Hash]*WithdrawalRecord)
+		for hash := range j.Prev {
+			rec, err := w.db.Withdrawal(hash)
+			if err != nil {
+				return err
+			}
+			if rec != nil && rec.Initiated != 0 && rec.Finalized != 0 {
+				records[hash] = nil
+			}
+		}
+		if err := w.db.RemoveWithdrawalJournal(side, j, records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withdrawalTarget is a withdrawal index that the blocks of a chain change.
+type withdrawalTarget struct {
+	rollup string
+	index  *WithdrawalIndex
+	side   string
+	events func(receipts types.Receipts) ([]withdrawalEvent, error)
+}
+
+// WithdrawalIndexer indexes the withdrawal events of the blocks of a chain, after these have been exported:
+// the initiations of an OP Stack chain, and the proofs and finalizations of the rollups of an L1 chain.
+// Failing to update an index does not fail the export, the delays of the affected withdrawals are then unknown.
+type WithdrawalIndexer struct {
+	log           log.Logger
+	finalityDepth uint64
+	targets       []withdrawalTarget
+}
+
+// NewWithdrawalIndexer returns the indexer of the chain, or nil if its blocks do not change any withdrawal index.
+func NewWithdrawalIndexer(log log.Logger, ch *Chain) *WithdrawalIndexer {
+	x := &WithdrawalIndexer{log: log, finalityDepth: ch.DB.FinalityDepth()}
+	if ch.Withdrawals != nil {
+		x.targets = append(x.targets, withdrawalTarget{rollup: ch.Name, index: ch.Withdrawals, side: withdrawalsL2, events: messagePassedEvents})
+	}
+	for _, r := range ch.Rollups {
+		portal := r.RollupConfig.DepositContractAddress
+		x.targets = append(x.targets, withdrawalTarget{rollup: r.Name, index: r.Withdrawals, side: withdrawalsL1,
+			events: func(receipts types.Receipts) ([]withdrawalEvent, error) {
+				return portalEvents(portal, receipts)
+			}})
+	}
+	if len(x.targets) == 0 {
+		return nil
+	}
+	return x
+}
+
+// Index records the withdrawal events of the exported blocks, and prunes the journals of the blocks that are final.
+func (x *WithdrawalIndexer) Index(batch []*BlockWithReceipts) {
+	for _, t := range x.targets {
+		for _, b := range batch {
+			events, err := t.events(b.Receipts)
+			if err == nil {
+				err = t.index.Index(t.side, b.Block.NumberU64(), b.Block.Hash(), b.Block.Time(), events)
+			}
+			if err != nil {
+				x.log.Error("failed to index withdrawals", "rollup", t.rollup, "number", b.Block.NumberU64(), "hash", b.Block.Hash(), "err", err)
+			}
+		}
+		head := batch[len(batch)-1].Block.NumberU64()
+		if head < x.finalityDepth {
+			continue
+		}
+		if err := t.index.Prune(t.side, head-x.finalityDepth); err != nil {
+			x.log.Error("failed to prune withdrawal index", "rollup", t.rollup, "err", err)
+		}
+	}
+}
+
+// Revert undoes the indexing of the blocks from the given number, which are replaced by a reorg.
+func (x *WithdrawalIndexer) Revert(from uint64) {
+	for _, t := range x.targets {
+		if err := t.index.Revert(t.side, from); err != nil {
+			x.log.Error("failed to revert withdrawal index", "rollup", t.rollup, "from", from, "err", err)
+		}
+	}
+}
+
+// withdrawalStages are the withdrawals of a block, with their records as these were before the block,
+// for the metrics of the block.
+type withdrawalStages struct {
+	// time of the block
+	time uint64
+	// the withdrawal value of initiated withdrawals, or the record of proven and finalized withdrawals,
+	// nil if unknown
+	initiated []*big.Int
+	proven    []*WithdrawalRecord
+	finalized []*WithdrawalRecord
+	// finalizations that failed, the withdrawal cannot be replayed
+	failed int
+	// the events could not be decoded
+	err error
+}
+
+// recordValue returns the withdrawn ETH in gwei, and false if the initiation of the withdrawal is unknown.
+func recordValue(rec *WithdrawalRecord) (float64, bool) {
+	if rec == nil || rec.Value == nil {
+		return 0, false
+	}
+	return GweiFloat64(rec.Value.ToInt()), true
+}
+
+// withdrawalStageMetrics creates the count and value metrics of the withdrawals of the given stages.
+func withdrawalStageMetrics(stages []string) AggregateMetric[*withdrawalStages] {
+	records := func(w *withdrawalStages, stage string) []*WithdrawalRecord {
+		if stage == "proven" {
+			return w.proven
+		}
+		return w.finalized
+	}
+	return CombineAggregates[*withdrawalStages](
+		ParametrizedMetric[*withdrawalStages]("withdrawals", "stage", stages,
+			func(w *withdrawalStages, dest []float64) error {
+				if w.err != nil {
+					return w.err
+				}
+				for i, stage := range stages {
+					if stage == "initiated" {
+						dest[i] = float64(len(w.initiated))
+					} else {
+						dest[i] = float64(len(records(w, stage)))
+					}
+				}
+				return nil
+			}),
+		ParametrizedMetric[*withdrawalStages]("withdrawal_value", "stage", stages,
+			func(w *withdrawalStages, dest []float64) error {
+				for i, stage := range stages {
+					if stage == "initiated" {
+						for _, v := range w.initiated {
+							dest[i] += GweiFloat64(v)
+						}
+						continue
+					}
+					for _, rec := range records(w, stage) {
+						if v, ok := recordValue(rec); ok {
+							dest[i] += v
+						}
+					}
+				}
+				return nil
+			}),
+	)
+}
+
+// WithdrawalL2Metrics creates the withdrawal metrics of an OP Stack chain, from the MessagePassed events
+// of the L2ToL1MessagePasser:
+//   - withdrawals{stage=initiated}: initiated withdrawals.
+//   - withdrawal_value{stage=initiated}: the withdrawn ETH, in gwei.
+var WithdrawalL2Metrics = TransformAggregate[*withdrawalStages, *BlockWithReceipts](
+	func(elem *BlockWithReceipts) *withdrawalStages {
+		out := &withdrawalStages{time: elem.Block.Time()}
+		events, err := messagePassedEvents(elem.Receipts)
+		if err != nil {
+			out.err = err
+			return out
+		}
+		for _, ev := range events {
+			out.initiated = append(out.initiated, ev.value)
+		}
+		return out
+	},
+	withdrawalStageMetrics([]string{"initiated"}),
+)
+
+// WithdrawalL1Metrics creates the withdrawal metrics of the OP Stack rollups of an L1 chain,
+// from the WithdrawalProven and WithdrawalFinalized events of their OptimismPortal, labeled by rollup.
+// The durations between the stages are measured with the withdrawal index of the rollup, which is only read:
+// the WithdrawalIndexer of the L1 chain records the events after the block is exported.
+//   - withdrawals{stage=proven|finalized}: proven and finalized withdrawals.
+//   - withdrawal_value{stage=proven|finalized}: the withdrawn ETH, in gwei, of withdrawals of which the initiation
+//     is indexed.
+//   - withdrawals_failed: finalized withdrawals of which the L1 call failed.
+//   - withdrawal_prove_delay: histogram of the time from initiation to proof, in seconds.
+//   - withdrawal_finalize_delay: histogram of the time from the latest proof to finalization, in seconds.
+func WithdrawalL1Metrics(rollups []*Chain) AggregateMetric[*BlockWithReceipts] {
+	aggs := make([]AggregateMetric[*BlockWithReceipts], 0, len(rollups))
+	for _, r := range rollups {
+		portal := r.RollupConfig.DepositContractAddress
+		index := r.Withdrawals
+		agg := TransformAggregate[*withdrawalStages, *BlockWithReceipts](
+			func(elem *BlockWithReceipts) *withdrawalStages {
+				out := &withdrawalStages{time: elem.Block.Time()}
+				events, err := portalEvents(portal, elem.Receipts)
+				if err != nil {
+					out.err = err
+					return out
+				}
+				for _, ev := range events {
+					// an unreadable record is an unknown one, like that of a withdrawal that was not indexed
+					rec, _ := index.Lookup(withdrawalsL1, elem.Block.NumberU64(), elem.Block.Hash(), ev.hash)
+					if ev.stage == "proven" {
+						out.proven = append(out.proven, rec)
+						continue
+					}
+					out.finalized = append(out.finalized, rec)
+					if !ev.success {
+						out.failed += 1
+					}
+				}
+				return out
+			},
+			CombineAggregates[*withdrawalStages](
+				withdrawalStageMetrics([]string{"proven", "finalized"}),
+				Aggregate[*withdrawalStages](Metric[*withdrawalStages]{
+					Name: "withdrawals_failed",
+					Fn: func(w *withdrawalStages) (float64, error) {
+						return float64(w.failed), nil
+					},
+				}),
+				Histogram[*withdrawalStages]("withdrawal_prove_delay", []float64{
+					600,
+					1800,
+					3600,
+					2 * 3600,
+					6 * 3600,
+					24 * 3600,
+					7 * 24 * 3600,
+					30 * 24 * 3600,
+				}, func(w *withdrawalStages, add func(v float64)) error {
+					for _, rec := range w.proven {
+						if rec != nil && rec.Initiated != 0 {
+							add(float64(w.time) - float64(rec.Initiated))
+						}
+					}
+					return nil
+				}),
+				Histogram[*withdrawalStages]("withdrawal_finalize_delay", []float64{
+					24 * 3600,
+					7 * 24 * 3600,
+					8 * 24 * 3600,
+					10 * 24 * 3600,
+					14 * 24 * 3600,
+					30 * 24 * 3600,
+					90 * 24 * 3600,
+				}, func(w *withdrawalStages, add func(v float64)) error {
+					for _, rec := range w.finalized {
+						if rec != nil && rec.Proven != 0 {
+							add(float64(w.time) - float64(rec.Proven))
+						}
+					}
+					return nil
+				}),
+			),
+		)
+		aggs = append(aggs, LabelAggregate(agg, Label{Key: "rollup", Value: r.Name}))
+	}
+	return CombineAggregates[*BlockWithReceipts](aggs...)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithdrawalIndex(t *testing.T) {
+	wh := common.HexToHash("0x1234")
+	l2Block := common.HexToHash("0xaa")
+	l1Prove := common.HexToHash("0xbb")
+	l1Finalize := common.HexToHash("0xcc")
+	initiated := &WithdrawalRecord{Initiated: 1000, Value: (*hexutil.Big)(big.NewInt(3e9))}
+	proven := &WithdrawalRecord{Initiated: 1000, Value: (*hexutil.Big)(big.NewInt(3e9)), Proven: 8200}
+
+	initiate := func(idx *WithdrawalIndex) error {
+		return idx.Index(withdrawalsL2, 10, l2Block, 1000, []withdrawalEvent{{hash: wh, stage: "initiated", value: big.NewInt(3e9)}})
+	}
+	prove := func(idx *WithdrawalIndex) error {
+		return idx.Index(withdrawalsL1, 5, l1Prove, 8200, []withdrawalEvent{{hash: wh, stage: "proven"}})
+	}
+	finalize := func(idx *WithdrawalIndex) error {
+		return idx.Index(withdrawalsL1, 8, l1Finalize, 700000, []withdrawalEvent{{hash: wh, stage: "finalized", success: true}})
+	}
+
+	tests := []struct {
+		name  string
+		steps []func(idx *WithdrawalIndex) error
+		// the record after the steps
+		want *WithdrawalRecord
+		// the record that the metrics of the proof and finalization see
+		wantAtProve, wantAtFinalize *WithdrawalRecord
+	}{
+		{
+			name:           "in order",
+			steps:          []func(idx *WithdrawalIndex) error{initiate, prove, finalize},
+			want:           &WithdrawalRecord{Initiated: 1000, Value: (*hexutil.Big)(big.NewInt(3e9)), Proven: 8200, Finalized: 700000},
+			wantAtProve:    initiated,
+			wantAtFinalize: proven,
+		},
+		{
+			name:           "indexed twice",
+			steps:          []func(idx *WithdrawalIndex) error{initiate, prove, finalize, initiate, prove, finalize},
+			want:           &WithdrawalRecord{Initiated: 1000, Value: (*hexutil.Big)(big.NewInt(3e9)), Proven: 8200, Finalized: 700000},
+			wantAtProve:    initiated,
+			wantAtFinalize: proven,
+		},
+		{
+			name:           "initiation backfilled after the finalization",
+			steps:          []func(idx *WithdrawalIndex) error{prove, finalize, initiate},
+			want:           &WithdrawalRecord{Initiated: 1000, Value: (*hexutil.Big)(big.NewInt(3e9)), Proven: 8200, Finalized: 700000},
+			wantAtProve:    nil,
+			wantAtFinalize: &WithdrawalRecord{Proven: 8200},
+		},
+		{
+			name: "finalization reorged out",
+			steps: []func(idx *WithdrawalIndex) error{initiate, prove, finalize, func(idx *WithdrawalIndex) error {
+				return idx.Revert(withdrawalsL1, 8)
+			}},
+			want:           proven,
+			wantAtProve:    initiated,
+			wantAtFinalize: proven,
+		},
+		{
+			name: "proof reorged out after the initiation",
+			steps: []func(idx *WithdrawalIndex) error{prove, initiate, func(idx *WithdrawalIndex) error {
+				return idx.Revert(withdrawalsL1, 5)
+			}},
+			want:           initiated,
+			wantAtProve:    initiated,
+			wantAtFinalize: initiated,
+		},
+		{
+			name: "initiation reorged out",
+			steps: []func(idx *WithdrawalIndex) error{initiate, func(idx *WithdrawalIndex) error {
+				return idx.Revert(withdrawalsL2, 10)
+			}},
+			want:           nil,
+			wantAtProve:    nil,
+			wantAtFinalize: nil,
+		},
+		{
+			name: "completed withdrawal pruned",
+			steps: []func(idx *WithdrawalIndex) error{initiate, prove, finalize, func(idx *WithdrawalIndex) error {
+				return idx.Prune(withdrawalsL1, 9)
+			}},
+			want:           nil,
+			wantAtProve:    nil,
+			wantAtFinalize: nil,
+		},
+		{
+			name: "pending withdrawal kept",
+			steps: []func(idx *WithdrawalIndex) error{initiate, prove, func(idx *WithdrawalIndex) error {
+				return idx.Prune(withdrawalsL1, 9)
+			}},
+			want:           proven,
+			wantAtProve:    proven,
+			wantAtFinalize: proven,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := OpenChainDB(filepath.Join(t.TempDir(), "db"), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			idx := NewWithdrawalIndex(db)
+			for i, step := range tt.steps {
+				if err := step(idx); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+			}
+			check := func(what string, got *WithdrawalRecord, err error, want *WithdrawalRecord) {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("%s: %v", what, err)
+				}
+				if (got == nil) != (want == nil) || got != nil && (got.Initiated != want.Initiated ||
+					got.Proven != want.Proven || got.Finalized != want.Finalized ||
+					(got.Value == nil) != (want.Value == nil) || got.Value != nil && got.Value.ToInt().Cmp(want.Value.ToInt()) != 0) {
+					t.Errorf("%s: got %+v, want %+v", what, got, want)
+				}
+			}
+			got, err := db.Withdrawal(wh)
+			check("record", got, err, tt.want)
+			got, err = idx.Lookup(withdrawalsL1, 5, l1Prove, wh)
+			check("record at proof", got, err, tt.wantAtProve)
+			got, err = idx.Lookup(withdrawalsL1, 8, l1Finalize, wh)
+			check("record at finalization", got, err, tt.wantAtFinalize)
+		})
+	}
+}